@@ -0,0 +1,263 @@
+package main
+
+import (
+	"cex/client"
+	"cex/client/recorder"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"cex/engine"
+	"cex/trading"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 回测的定时任务，和Start中的定时任务一致，只是按照回放的时间触发
+type backtestTimer struct {
+	interval int64 // 单位：ms
+	next     int64
+	run      func()
+}
+
+// 单个币种的回测统计
+type backtestAssetStat struct {
+	Asset        string
+	MakerFills   int     // 币本位挂单成交次数
+	MakerVolume  float64 // 币本位挂单成交张数
+	DeliveryCash float64 // 币本位成交的现金流，单位：币，买入张数*面值/价格为正，卖出为负
+	Rebate       float64 // 挂单返佣（Commission），单位：币
+	HedgeFills   int     // 对冲成交次数
+	HedgeBase    float64 // 对冲的币数量变化，U本位按持仓数量计算
+	HedgeQuote   float64 // 对冲的计价资产变化
+	HedgeCost    float64 // 对冲成交价相对中间价的滑点，单位：计价资产
+}
+
+// 回测，用录制的行情驱动和线上一样的挂单、撤单逻辑
+// 币本位挂单在模拟交易所中按照排队位置和价格穿越成交，成交后用现货或U本位市价单对冲
+type Backtest struct {
+	clock   int64 // 当前回放的时间，单位：ms
+	records int64
+	timers  []*backtestTimer
+	assets  map[string]*backtestAssetStat
+	report  *csv.Writer
+}
+
+func RunBacktest(conf *config.Config) {
+	if conf.ReplayPath == "" {
+		logger.Fatal("backtest needs ReplayPath")
+	}
+	bt := &Backtest{assets: map[string]*backtestAssetStat{}}
+	common.SetTimestampFunc(bt.now)
+
+	// 下单使用模拟交易所，行情来自录制文件
+	conf.Simulated = true
+	replayPlayer = recorder.NewPlayer(conf.ReplayPath, 0)
+	ctxt.Init(conf)
+	simulatedMarket = trading.NewSimulatedMarket(conf)
+	simulatedMarket.Delivery.QueuePosition = true
+	// 回测时所有异步调用都改成同步，保证结果可以复现
+	orderHandler.Backtesting = true
+	orderHandler.Init(conf, &ctxt, simulatedMarket)
+	trading.InitOrderSizing(conf)
+	InitStrategy(conf)
+
+	// 录制的订单消息是线上的订单，回测中只处理模拟交易所的订单消息
+	simulatedMarket.Delivery.SetOrderHandler(bt.deliveryOrderHandler)
+	simulatedMarket.Futures.SetOrderHandler(bt.hedgeOrderHandler(trading.HedgeVenueFutures))
+	simulatedMarket.Spot.SetOrderHandler(bt.hedgeOrderHandler(trading.HedgeVenueSpot))
+	replayPlayer.SetPriceHandler(recorder.StreamDelivery, replayPriceHandler(simulatedMarket.Delivery, strategyEngine.PriceHandler(engine.ProductDelivery)))
+	replayPlayer.SetPriceHandler(recorder.StreamFutures, replayPriceHandler(simulatedMarket.Futures, strategyEngine.PriceHandler(engine.ProductFutures)))
+	replayPlayer.SetPriceHandler(recorder.StreamSpot, replayPriceHandler(simulatedMarket.Spot, strategyEngine.PriceHandler(engine.ProductSpot)))
+	replayPlayer.SetTimeHandler(bt.advance)
+
+	// 策略的定时器由回测的时钟驱动
+	for _, timer := range strategyEngine.Timers() {
+		bt.addTimer(timer.Interval, strategyEngine.TimerFunc(timer.Name))
+	}
+	bt.addTimer(100*time.Millisecond, CheckStatus)
+	bt.addTimer(60*time.Second, bt.sample)
+
+	if conf.BacktestReportPath != "" {
+		file, err := os.Create(conf.BacktestReportPath)
+		if err != nil {
+			logger.Fatal("create backtest report error, path=%s, message is %s", conf.BacktestReportPath, err.Error())
+		}
+		defer file.Close()
+		bt.report = csv.NewWriter(file)
+		_ = bt.report.Write([]string{"time", "asset", "position", "hedgeBase", "pnl"})
+		defer bt.report.Flush()
+	}
+
+	start := time.Now()
+	err := replayPlayer.Play()
+	if err != nil {
+		logger.Error("backtest replay %s error, message is %s", conf.ReplayPath, err.Error())
+	}
+	bt.sample()
+	bt.printSummary(time.Since(start))
+}
+
+func (bt *Backtest) now() int64 {
+	return bt.clock
+}
+
+func (bt *Backtest) addTimer(interval time.Duration, run func()) {
+	bt.timers = append(bt.timers, &backtestTimer{interval: interval.Milliseconds(), run: run})
+}
+
+// 推进时钟到timestamp，期间到期的定时任务按照时间先后执行
+func (bt *Backtest) advance(timestamp int64) {
+	bt.records++
+	if bt.clock == 0 {
+		for _, timer := range bt.timers {
+			timer.next = timestamp
+		}
+	}
+	for {
+		var next *backtestTimer
+		for _, timer := range bt.timers {
+			if next == nil || timer.next < next.next {
+				next = timer
+			}
+		}
+		if next == nil || next.next > timestamp {
+			break
+		}
+		bt.clock = next.next
+		next.run()
+		next.next += next.interval
+	}
+	bt.clock = timestamp
+}
+
+func (bt *Backtest) getAssetStat(asset string) *backtestAssetStat {
+	stat, ok := bt.assets[asset]
+	if !ok {
+		stat = &backtestAssetStat{Asset: asset}
+		bt.assets[asset] = stat
+	}
+	return stat
+}
+
+// 统计币本位挂单成交，再交给策略处理，跨期价差的对冲单也在币本位，不算挂单成交
+func (bt *Backtest) deliveryOrderHandler(resp *client.OrderWSResponse) {
+	_, isHedge := common.ParseHedgeClientOrderID(resp.Order.ClientOrderID)
+	if !isHedge && resp.MsgType == "ORDER_TRADE_UPDATE" && (resp.Status == "PARTIALLY_FILLED" || resp.Status == "FILLED") {
+		symbolCfg := cfg.SymbolConfigs[resp.Order.Symbol]
+		stat := bt.getAssetStat(symbolCfg.BaseAsset)
+		amount := resp.Order.OrderVolume * float64(symbolCfg.Cont) / resp.Order.OrderPrice
+		if resp.Order.OrderType == "buy" {
+			stat.DeliveryCash += amount
+		} else {
+			stat.DeliveryCash -= amount
+		}
+		stat.Rebate += amount * cfg.GetQuoteParams(resp.Order.Symbol).Commission
+		stat.MakerFills++
+		stat.MakerVolume += resp.Order.OrderVolume
+	}
+	strategyEngine.OrderHandler(engine.ProductDelivery)(resp)
+}
+
+// 统计对冲成交，再交给策略处理，venue 和产品线的名称一致；U本位的持仓按同样数量的币计算
+func (bt *Backtest) hedgeOrderHandler(venue string) client.OrderProcessHandler {
+	return func(resp *client.OrderWSResponse) {
+		bt.countHedgeFill(venue, resp)
+		strategyEngine.OrderHandler(venue)(resp)
+	}
+}
+
+func (bt *Backtest) countHedgeFill(venue string, resp *client.OrderWSResponse) {
+	if resp.MsgType != "ORDER_TRADE_UPDATE" || (resp.Status != "PARTIALLY_FILLED" && resp.Status != "FILLED") {
+		return
+	}
+	stat := bt.getAssetStat(resp.Order.BaseAsset)
+	volume, price := resp.Order.OrderVolume, resp.Order.OrderPrice
+	mid := price
+	if deliverySymbols := ctxt.GetDeliverySymbol(resp.Order.Symbol); len(deliverySymbols) > 0 {
+		priceItem := ctxt.GetPriceItem(cfg.Exchange, deliverySymbols[0], venue)
+		if priceItem != nil && priceItem.BidPrice > 0 && priceItem.AskPrice > 0 {
+			mid = (priceItem.BidPrice + priceItem.AskPrice) / 2
+		}
+	}
+	if resp.Order.OrderType == "buy" {
+		stat.HedgeBase += volume
+		stat.HedgeQuote -= volume * price
+		stat.HedgeCost += (price - mid) * volume
+	} else {
+		stat.HedgeBase -= volume
+		stat.HedgeQuote += volume * price
+		stat.HedgeCost += (mid - price) * volume
+	}
+	stat.HedgeFills++
+}
+
+// 币种的持仓（张）和按当前价格计算的盈亏（计价资产）
+// 币本位持仓按照中间价平仓计算，币按照现货中间价折算成计价资产
+func (bt *Backtest) getPnl(stat *backtestAssetStat) (position float64, pnl float64) {
+	account := ctxt.Accounts.GetAccount(cfg.Exchange, cfg.SwapType)
+	coin := stat.DeliveryCash + stat.Rebate + stat.HedgeBase
+	spotMid := 0.0
+	for _, symbol := range ctxt.Symbols {
+		symbolCfg := cfg.SymbolConfigs[symbol]
+		if symbolCfg.BaseAsset != stat.Asset {
+			continue
+		}
+		symbolContext := ctxt.GetSymbolContext(symbol)
+		symbolPosition := account.GetPositionsInfo(symbol).Position
+		position += symbolPosition
+		if mid := (symbolContext.BidPrice + symbolContext.AskPrice) / 2; mid > 0 {
+			coin -= symbolPosition * float64(symbolCfg.Cont) / mid
+		}
+		spotPriceItem := ctxt.GetPriceItem(cfg.Exchange, symbol, "spot")
+		if spotPriceItem != nil && spotPriceItem.BidPrice > 0 && spotPriceItem.AskPrice > 0 {
+			spotMid = (spotPriceItem.BidPrice + spotPriceItem.AskPrice) / 2
+		}
+	}
+	return position, coin*spotMid + stat.HedgeQuote
+}
+
+func (bt *Backtest) sortedAssets() []*backtestAssetStat {
+	var stats []*backtestAssetStat
+	for _, stat := range bt.assets {
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Asset < stats[j].Asset })
+	return stats
+}
+
+// 记录库存和盈亏曲线
+func (bt *Backtest) sample() {
+	if bt.report == nil {
+		return
+	}
+	timestamp := time.UnixMilli(bt.clock).UTC().Format(time.RFC3339)
+	for _, stat := range bt.sortedAssets() {
+		position, pnl := bt.getPnl(stat)
+		_ = bt.report.Write([]string{
+			timestamp,
+			stat.Asset,
+			strconv.FormatFloat(position, 'f', -1, 64),
+			strconv.FormatFloat(stat.HedgeBase, 'f', -1, 64),
+			strconv.FormatFloat(pnl, 'f', 4, 64),
+		})
+	}
+}
+
+func (bt *Backtest) printSummary(cost time.Duration) {
+	message := fmt.Sprintf("backtest finished, records=%d, cost=%s\n", bt.records, cost)
+	totalPnl := 0.0
+	for _, stat := range bt.sortedAssets() {
+		position, pnl := bt.getPnl(stat)
+		totalPnl += pnl
+		message += fmt.Sprintf("%s: makerFills=%d, makerVolume=%.0f, rebate=%.8f, hedgeFills=%d, hedgeCost=%.4f, position=%.0f, hedgeBase=%.8f, pnl=%.4f\n",
+			stat.Asset, stat.MakerFills, stat.MakerVolume, stat.Rebate, stat.HedgeFills, stat.HedgeCost,
+			position, stat.HedgeBase, pnl)
+	}
+	message += fmt.Sprintf("total pnl in %s: %.4f\n", cfg.QuoteAsset, totalPnl)
+	logger.Info(message)
+	fmt.Print(message)
+}
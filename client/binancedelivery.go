@@ -4,15 +4,20 @@ import (
 	"cex/common"
 	"cex/common/logger"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"math"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/adshao/go-binance/v2/futures"
-	"github.com/shopspring/decimal"
-
+	binancecommon "github.com/adshao/go-binance/v2/common"
 	"github.com/adshao/go-binance/v2/delivery"
 	"golang.org/x/time/rate"
 )
@@ -21,7 +26,8 @@ type BinanceDeliveryClient struct {
 	OrderClient
 	Name         string
 	orderClient  *delivery.Client
-	limiter      *rate.Limiter
+	limiter      *rate.Limiter // 本地的下单频率限制（APILimit）
+	rateLimiter  *RateLimiter  // 币安的频率限制，同一个 API key 共用
 	limitProcess int
 	precMap      map[string]int
 	qtyMap       map[string]int
@@ -30,8 +36,12 @@ type BinanceDeliveryClient struct {
 func (cli *BinanceDeliveryClient) Init(config Config) bool {
 	cli.Name = "BinanceDelivery"
 	cli.orderClient = delivery.NewClient(config.AccessKey, config.SecretKey)
-	limit := rate.Every(1 * time.Second / time.Duration(config.APILimit))
-	cli.limiter = rate.NewLimiter(limit, 60)
+	cli.rateLimiter = GetRateLimiter(config.AccessKey, ProductDelivery)
+	cli.orderClient.HTTPClient = newRateLimitHTTPClient(cli.rateLimiter)
+	if config.APILimit > 0 {
+		limit := rate.Every(1 * time.Second / time.Duration(config.APILimit))
+		cli.limiter = rate.NewLimiter(limit, 60)
+	}
 	cli.limitProcess = config.LimitProcess
 	cli.ExchangeInfo()
 	return true
@@ -39,9 +49,14 @@ func (cli *BinanceDeliveryClient) Init(config Config) bool {
 
 // 获取下单精度
 func (cli *BinanceDeliveryClient) ExchangeInfo() {
+	if !cli.checkLimit("exchangeInfo") {
+		return
+	}
 	resp, err := cli.orderClient.NewExchangeInfoService().Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error("Get ExchangeInfo failed, message is %s", err.Error())
+		return
 	}
 	precMap := map[string]int{}
 	qtyMap := map[string]int{}
@@ -68,127 +83,483 @@ func (cli *BinanceDeliveryClient) ExchangeInfo() {
 
 // 设置杠杆
 func (cli *BinanceDeliveryClient) ChangeLeverage(symbol string, leverage int) {
+	if !cli.checkLimit("leverage") {
+		return
+	}
 	logger.Debug("==change symbol=%s leverage to %d", symbol, leverage)
 	resp, err := cli.orderClient.NewChangeLeverageService().Symbol(symbol).Leverage(leverage).Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error("change leverage failed, message is %s", err.Error())
 	}
 	logger.Debug("==symbol=%s's leverage has been changed to %+v", symbol, resp)
 }
 
-func (cli *BinanceDeliveryClient) GetListenKey() string {
+func (cli *BinanceDeliveryClient) GetListenKey() (string, error) {
+	if !cli.checkLimit("listenKey") {
+		return "", errOrderRateLimited
+	}
 	listenKey, err := cli.orderClient.NewStartUserStreamService().Do(context.Background())
 	if err != nil {
-		logger.Error(err.Error())
-		return ""
+		err = ParseBinanceError(err)
+		logger.Error("get delivery listen key failed, message is %s", err.Error())
+		return "", err
 	}
-	return listenKey
+	return listenKey, nil
 }
 
-func (cli *BinanceDeliveryClient) KeepAliveListenKey(listenKey string) {
+func (cli *BinanceDeliveryClient) KeepAliveListenKey(listenKey string) error {
+	if !cli.checkLimit("listenKey") {
+		return errOrderRateLimited
+	}
 	err := cli.orderClient.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(context.Background())
 	if err != nil {
-		logger.Error(err.Error())
+		err = ParseBinanceError(err)
+		logger.Error("keep alive delivery listen key failed, message is %s", err.Error())
+		return err
 	}
+	return nil
 }
 
-func (cli *BinanceDeliveryClient) GetAccount() *delivery.Account {
-	account, err := cli.orderClient.NewGetAccountService().Do(context.Background())
+func (cli *BinanceDeliveryClient) GetAccount() (*Account, error) {
+	if !cli.checkLimit("account") {
+		return nil, errOrderRateLimited
+	}
+	resp, err := cli.orderClient.NewGetAccountService().Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error("get delivery account failed, message is %s", err.Error())
+		return nil, err
+	}
+
+	account := new(Account)
+	for _, asset := range resp.Assets {
+		marginBalance, _ := strconv.ParseFloat(asset.MarginBalance, 64)
+		available, _ := strconv.ParseFloat(asset.AvailableBalance, 64)
+		account.Assets = append(account.Assets, AssetBalance{
+			Asset:         asset.Asset,
+			Free:          available,
+			Locked:        marginBalance - available,
+			MarginBalance: marginBalance,
+		})
+	}
+	for _, position := range resp.Positions {
+		positionAmt, _ := strconv.ParseFloat(position.PositionAmt, 64)
+		entryPrice, _ := strconv.ParseFloat(position.EntryPrice, 64)
+		leverage, _ := strconv.Atoi(position.Leverage)
+		account.Positions = append(account.Positions, Position{
+			Symbol:      position.Symbol,
+			PositionAmt: positionAmt,
+			EntryPrice:  entryPrice,
+			Leverage:    leverage,
+		})
+	}
+	return account, nil
+}
+
+func (cli *BinanceDeliveryClient) GetPositions() ([]Position, error) {
+	account, err := cli.GetAccount()
+	if err != nil {
+		return nil, err
 	}
-	return account
+	return account.Positions, nil
 }
 
 func (cli *BinanceDeliveryClient) GetDepthPriceInfo(symbol string) (*delivery.DepthResponse, error) {
-	resp, err := cli.orderClient.NewDepthService().Symbol(symbol).Limit(20).Do(context.Background())
+	if !cli.checkLimit("depth") {
+		return nil, errOrderRateLimited
+	}
+	resp, err := cli.orderClient.NewDepthService().Symbol(symbol).Limit(depthSnapshotLimit).Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error(err.Error())
 		return nil, err
 	}
 	return resp, nil
 }
 
-// 创建订单，如果成功返回orderID，否则返回空
-// 限价单，GTC
-func (cli *BinanceDeliveryClient) PlaceOrderGTX(order *common.Order) string {
+func (cli *BinanceDeliveryClient) GetDepth(symbol string) (*Depth, error) {
+	resp, err := cli.GetDepthPriceInfo(symbol)
+	if err != nil {
+		return nil, err
+	}
+	depth := &Depth{Symbol: symbol, LastUpdateID: resp.LastUpdateID}
+	depth.Bids, err = parsePriceLevels(resp.Bids, resp.LastUpdateID)
+	if err != nil {
+		logger.Error("Binance delivery parse depth bids failed, symbol=%s, message is %s", symbol, err.Error())
+		return nil, err
+	}
+	depth.Asks, err = parsePriceLevels(resp.Asks, resp.LastUpdateID)
+	if err != nil {
+		logger.Error("Binance delivery parse depth asks failed, symbol=%s, message is %s", symbol, err.Error())
+		return nil, err
+	}
+	return depth, nil
+}
+
+func (cli *BinanceDeliveryClient) GetKlines(symbol string, interval string, limit int) ([]Kline, error) {
+	if !cli.checkLimit("klines") {
+		return nil, errOrderRateLimited
+	}
+	resp, err := cli.orderClient.NewKlinesService().Symbol(symbol).Interval(interval).Limit(limit).Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("get delivery klines failed, symbol=%s, message is %s", symbol, err.Error())
+		return nil, err
+	}
+	klines := make([]Kline, 0, len(resp))
+	for _, item := range resp {
+		kline := Kline{OpenTime: item.OpenTime, CloseTime: item.CloseTime}
+		kline.Open, _ = strconv.ParseFloat(item.Open, 64)
+		kline.High, _ = strconv.ParseFloat(item.High, 64)
+		kline.Low, _ = strconv.ParseFloat(item.Low, 64)
+		kline.Close, _ = strconv.ParseFloat(item.Close, 64)
+		klines = append(klines, kline)
+	}
+	return klines, nil
+}
+
+// 查询当前挂单
+func (cli *BinanceDeliveryClient) GetOpenOrders(symbol string) ([]*common.Order, error) {
+	if !cli.checkLimit("openOrders") {
+		return nil, errOrderRateLimited
+	}
+	resp, err := cli.orderClient.NewListOpenOrdersService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("get delivery open orders failed, symbol=%s, message is %s", symbol, err.Error())
+		return nil, err
+	}
+
+	var orders []*common.Order
+	for _, item := range resp {
+		price, _ := strconv.ParseFloat(item.Price, 64)
+		origQty, _ := strconv.ParseFloat(item.OrigQuantity, 64)
+		executedQty, _ := strconv.ParseFloat(item.ExecutedQuantity, 64)
+		status := common.CREATED
+		if executedQty > 0 {
+			status = common.PARTIALLYFILLED
+		}
+		orders = append(orders, &common.Order{
+			Exchange:      "Binance",
+			Symbol:        item.Symbol,
+			OrderType:     strings.ToLower(string(item.Side)),
+			OrderID:       strconv.FormatInt(item.OrderID, 10),
+			OrderPrice:    price,
+			OrderVolume:   origQty - executedQty,
+			CreateAt:      item.Time / 1000,
+			ClientOrderID: item.ClientOrderID,
+			Status:        status,
+		})
+	}
+	return orders, nil
+}
+
+// 创建订单，如果成功返回orderID，否则返回 OrderError
+// 限价单，GTX（post only）
+func (cli *BinanceDeliveryClient) PlaceOrderGTX(order *common.Order) (string, error) {
 	defer common.TimeCost(time.Now(), "placeLimitOrder")
-	if !cli.checkLimit(1) {
-		return ""
+	if !cli.checkLimit("order") {
+		return "", errOrderRateLimited
 	}
 	if order.ClientOrderID == "" {
 		order.ClientOrderID = common.GetClientOrderID()
 	}
+	side := delivery.SideTypeBuy
+	if order.OrderType == "sell" {
+		side = delivery.SideTypeSell
+	} else if order.OrderType != "buy" {
+		return "", errOrderInvalidSide
+	}
 
 	fPrice := strconv.FormatFloat(order.OrderPrice, 'f', cli.precMap[order.Symbol], 64)
 	fQuantity := strconv.FormatFloat(order.OrderVolume, 'f', cli.qtyMap[order.Symbol], 64)
 
-	logger.Info("BinancePlaceOrder: side=%s, price=%s, quantity=%f, clientID=%s", order.OrderType, fPrice, fQuantity, order.ClientOrderID)
-	if order.OrderType == "buy" {
-		res, err := cli.orderClient.NewCreateOrderService().
-			NewClientOrderID(order.ClientOrderID).
-			Symbol(order.Symbol).
-			Side(delivery.SideTypeBuy).
-			Type(delivery.OrderTypeLimit).
-			TimeInForce(delivery.TimeInForceTypeGTX).
-			Price(fPrice).
-			Quantity(fQuantity).
-			Do(context.Background())
-		if err != nil {
-			logger.Error("binance place order error，side=buy, price=%s, amount=%s, symbol=%s, message is %s",
-				fPrice, fQuantity, order.Symbol, err.Error())
-			return ""
+	logger.Info("BinancePlaceOrder: side=%s, price=%s, quantity=%s, clientID=%s", order.OrderType, fPrice, fQuantity, order.ClientOrderID)
+	res, err := cli.orderClient.NewCreateOrderService().
+		NewClientOrderID(order.ClientOrderID).
+		Symbol(order.Symbol).
+		Side(side).
+		Type(delivery.OrderTypeLimit).
+		TimeInForce(delivery.TimeInForceTypeGTX).
+		Price(fPrice).
+		Quantity(fQuantity).
+		Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("binance place order error，side=%s, price=%s, amount=%s, symbol=%s, message is %s",
+			order.OrderType, fPrice, fQuantity, order.Symbol, err.Error())
+		return "", err
+	}
+	return strconv.FormatInt(res.OrderID, 10), nil
+}
+
+// 市价单，如果成功返回orderID，否则返回 OrderError
+func (cli *BinanceDeliveryClient) PlaceMarketOrder(order *common.Order) (string, error) {
+	if !cli.checkLimit("marketOrder") {
+		return "", errOrderRateLimited
+	}
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = common.GetClientOrderID()
+	}
+
+	side := delivery.SideTypeBuy
+	if order.OrderType == "sell" {
+		side = delivery.SideTypeSell
+	} else if order.OrderType != "buy" {
+		return "", errOrderInvalidSide
+	}
+	fQuantity := strconv.FormatFloat(order.OrderVolume, 'f', cli.qtyMap[order.Symbol], 64)
+
+	logger.Info("BinanceDeliveryPlaceMarketOrder: symbol=%s, side=%s, quantity=%s, clientID=%s", order.Symbol, order.OrderType, fQuantity, order.ClientOrderID)
+	res, err := cli.orderClient.NewCreateOrderService().
+		NewClientOrderID(order.ClientOrderID).
+		Symbol(order.Symbol).
+		Side(side).
+		Type(delivery.OrderTypeMarket).
+		Quantity(fQuantity).
+		Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("BinanceDeliveryPlaceMarketOrder error: side=%s, amount=%s, symbol=%s, message is %s",
+			order.OrderType, fQuantity, order.Symbol, err.Error())
+		return "", err
+	}
+	return strconv.FormatInt(res.OrderID, 10), nil
+}
+
+// 币本位批量下单每次最多5个订单
+const deliveryMaxBatchOrders = 5
+
+func (cli *BinanceDeliveryClient) MaxBatchOrders() int {
+	return deliveryMaxBatchOrders
+}
+
+// 批量下单返回的单个订单，失败时只有code和msg
+type batchOrderResponse struct {
+	OrderID       int64  `json:"orderId"`
+	ClientOrderID string `json:"clientOrderId"`
+	Code          int64  `json:"code"`
+	Msg           string `json:"msg"`
+}
+
+// 批量限价挂单（post only），每次最多 MaxBatchOrders 个，结果和orders一一对应
+// go-binance 的 CreateBatchOrdersService 会丢掉失败订单的错误码，这里直接调用 batchOrders 接口
+func (cli *BinanceDeliveryClient) PlaceBatchOrdersGTX(orders []*common.Order) []BatchOrderResult {
+	defer common.TimeCost(time.Now(), "placeBatchOrders")
+	results := make([]BatchOrderResult, len(orders))
+	if len(orders) > deliveryMaxBatchOrders {
+		for i := range results {
+			results[i].Err = NewOrderError(ErrorKindInvalidOrder, ErrTooManyBatchOrders)
 		}
-		return strconv.FormatInt(res.OrderID, 10)
-	} else if order.OrderType == "sell" {
-		res, err := cli.orderClient.NewCreateOrderService().
-			NewClientOrderID(order.ClientOrderID).
-			Symbol(order.Symbol).
-			Side(delivery.SideTypeSell).
-			Type(delivery.OrderTypeLimit).
-			TimeInForce(delivery.TimeInForceTypeGTX).
-			Price(fPrice).
-			Quantity(fQuantity).
-			Do(context.Background())
-		if err != nil {
-			logger.Error("binance place order error，side=buy, price=%s, amount=%s, symbol=%s, message is %s",
-				fPrice, fQuantity, order.Symbol, err.Error())
-			return ""
+		return results
+	}
+
+	var params []map[string]string
+	var indexes []int // params中的订单在orders中的位置
+	for i, order := range orders {
+		if order.ClientOrderID == "" {
+			order.ClientOrderID = common.GetClientOrderID()
+		}
+		side := delivery.SideTypeBuy
+		if order.OrderType == "sell" {
+			side = delivery.SideTypeSell
+		} else if order.OrderType != "buy" {
+			results[i].Err = errOrderInvalidSide
+			continue
+		}
+		fPrice := strconv.FormatFloat(order.OrderPrice, 'f', cli.precMap[order.Symbol], 64)
+		fQuantity := strconv.FormatFloat(order.OrderVolume, 'f', cli.qtyMap[order.Symbol], 64)
+		logger.Info("BinancePlaceBatchOrder: symbol=%s, side=%s, price=%s, quantity=%s, clientID=%s",
+			order.Symbol, order.OrderType, fPrice, fQuantity, order.ClientOrderID)
+		params = append(params, map[string]string{
+			"symbol":           order.Symbol,
+			"side":             string(side),
+			"type":             string(delivery.OrderTypeLimit),
+			"timeInForce":      string(delivery.TimeInForceTypeGTX),
+			"price":            fPrice,
+			"quantity":         fQuantity,
+			"newClientOrderId": order.ClientOrderID,
+		})
+		indexes = append(indexes, i)
+	}
+	if len(params) == 0 {
+		return results
+	}
+	if !cli.checkLimitN("batchOrders", len(params)) {
+		for _, i := range indexes {
+			results[i].Err = errOrderRateLimited
 		}
-		return strconv.FormatInt(res.OrderID, 10)
+		return results
 	}
-	return ""
+
+	responses, err := cli.postBatchOrders(params)
+	if err == nil && len(responses) != len(params) {
+		err = NewOrderError(ErrorKindUnknownStatus, ErrBatchOrdersMismatch)
+	}
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("binance place batch orders error, size=%d, message is %s", len(params), err.Error())
+		for _, i := range indexes {
+			results[i].Err = err
+		}
+		return results
+	}
+
+	for j, response := range responses {
+		i := indexes[j]
+		if response.Code != 0 {
+			results[i].Err = ParseBinanceError(&binancecommon.APIError{Code: response.Code, Message: response.Msg})
+			logger.Error("binance place batch order error, side=%s, price=%f, symbol=%s, clientID=%s, message is %s",
+				orders[i].OrderType, orders[i].OrderPrice, orders[i].Symbol, orders[i].ClientOrderID, results[i].Err.Error())
+			continue
+		}
+		results[i].OrderID = strconv.FormatInt(response.OrderID, 10)
+	}
+	return results
 }
 
-// 判断API调用频率
-// n为api权重
-func (cli *BinanceDeliveryClient) checkLimit(n int) bool {
-	if cli.limitProcess == 1 {
-		err := cli.limiter.WaitN(context.Background(), n)
-		if err != nil {
-			logger.Error(err.Error())
+// 调用 /dapi/v1/batchOrders
+func (cli *BinanceDeliveryClient) postBatchOrders(params []map[string]string) ([]batchOrderResponse, error) {
+	batchOrders, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("batchOrders", string(batchOrders))
+	data, err := cli.signedRequest(http.MethodPost, "/dapi/v1/batchOrders", form)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []batchOrderResponse
+	err = json.Unmarshal(data, &responses)
+	if err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// 修改挂单的价格和数量，不用撤单再重新下单，返回按精度取整后的价格和数量
+// go-binance 没有修改订单的接口，直接调用 PUT /dapi/v1/order
+func (cli *BinanceDeliveryClient) AmendOrder(order *common.Order, price float64, volume float64) (float64, float64, error) {
+	if !cli.checkLimit("modifyOrder") {
+		return 0, 0, errOrderRateLimited
+	}
+	side := delivery.SideTypeBuy
+	if order.OrderType == "sell" {
+		side = delivery.SideTypeSell
+	} else if order.OrderType != "buy" {
+		return 0, 0, errOrderInvalidSide
+	}
+	fPrice := strconv.FormatFloat(price, 'f', cli.precMap[order.Symbol], 64)
+	fQuantity := strconv.FormatFloat(volume, 'f', cli.qtyMap[order.Symbol], 64)
+
+	logger.Info("BinanceAmendOrder: symbol=%s, side=%s, price=%f->%s, quantity=%f->%s, clientID=%s",
+		order.Symbol, order.OrderType, order.OrderPrice, fPrice, order.OrderVolume, fQuantity, order.ClientOrderID)
+	form := url.Values{}
+	form.Set("symbol", order.Symbol)
+	form.Set("side", string(side))
+	form.Set("origClientOrderId", order.ClientOrderID)
+	form.Set("price", fPrice)
+	form.Set("quantity", fQuantity)
+	_, err := cli.signedRequest(http.MethodPut, "/dapi/v1/order", form)
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("binance amend order error, side=%s, price=%s, amount=%s, symbol=%s, clientID=%s, message is %s",
+			order.OrderType, fPrice, fQuantity, order.Symbol, order.ClientOrderID, err.Error())
+		return 0, 0, err
+	}
+	amendedPrice, _ := strconv.ParseFloat(fPrice, 64)
+	amendedVolume, _ := strconv.ParseFloat(fQuantity, 64)
+	return amendedPrice, amendedVolume, nil
+}
+
+// 调用 go-binance 没有封装的签名接口，签名方式和 go-binance 一致，参数放在body中
+func (cli *BinanceDeliveryClient) signedRequest(method string, endpoint string, form url.Values) ([]byte, error) {
+	form.Set("timestamp", strconv.FormatInt(time.Now().UnixNano()/1e6-cli.orderClient.TimeOffset, 10))
+	body := form.Encode()
+	mac := hmac.New(sha256.New, []byte(cli.orderClient.SecretKey))
+	mac.Write([]byte(body))
+	body += "&signature=" + hex.EncodeToString(mac.Sum(nil))
+
+	request, err := http.NewRequest(method, cli.orderClient.BaseURL+endpoint, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-MBX-APIKEY", cli.orderClient.APIKey)
+	response, err := cli.orderClient.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		apiErr := new(binancecommon.APIError)
+		json.Unmarshal(data, apiErr)
+		return nil, apiErr
+	}
+	return data, nil
+}
+
+// 判断API调用频率，name是接口名称，权重见 deliveryRequests
+// 撤单和查询等待额度，下单按照limitProcess等待或者丢弃
+func (cli *BinanceDeliveryClient) checkLimit(name string) bool {
+	return cli.checkLimitN(name, 1)
+}
+
+// 批量接口按 n 个订单计算下单数量
+func (cli *BinanceDeliveryClient) checkLimitN(name string, n int) bool {
+	request := getRateRequest(ProductDelivery, name)
+	request.Orders *= n
+	wait := request.Priority != PriorityOrder || cli.limitProcess == 1
+	if request.Orders > 0 && cli.limiter != nil {
+		if wait {
+			err := cli.limiter.WaitN(context.Background(), request.Orders)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		} else if !cli.limiter.AllowN(time.Now(), request.Orders) {
+			logger.Info("BinanceMM API Limit")
+			return false
 		}
-		return true
 	}
-	ret := cli.limiter.AllowN(time.Now(), n)
-	if !ret {
-		logger.Info("BinanceMM API Limit")
+	err := cli.rateLimiter.AcquireN(name, n, wait)
+	if err != nil {
+		logger.Info("Binance delivery API Limit, request=%s, %s", name, cli.rateLimiter.String())
+		return false
 	}
-	return ret
+	return true
+}
+
+// 币安的频率限制，策略可以查询剩余的额度
+func (cli *BinanceDeliveryClient) GetRateLimiter() *RateLimiter {
+	return cli.rateLimiter
 }
 
 // 取消所有订单
-func (cli *BinanceDeliveryClient) CancelAllOrders(symbol string) bool {
+func (cli *BinanceDeliveryClient) CancelAllOrders(symbol string) error {
 	defer common.TimeCost(time.Now(), "cancelByAll")
+	if !cli.checkLimit("cancelAll") {
+		return errOrderRateLimited
+	}
 	err := cli.orderClient.NewCancelAllOpenOrdersService().Symbol(strings.ToUpper(symbol)).Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error(err.Error())
-		return false
+		return err
 	}
-	return true
+	return nil
 }
 
 func (cli *BinanceDeliveryClient) CancelOrdersByClientID(clientOrderIDs *[]string, symbol string) ([]string, error) {
+	if !cli.checkLimit("batchCancel") {
+		return nil, errOrderRateLimited
+	}
 	orderNum := len(*clientOrderIDs)
 	canceledIds := make([]string, orderNum)
 
@@ -196,6 +567,7 @@ func (cli *BinanceDeliveryClient) CancelOrdersByClientID(clientOrderIDs *[]strin
 		Symbol(symbol).
 		OrigClientOrderIDList(*clientOrderIDs).Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error(err.Error())
 		return canceledIds, err
 	}
@@ -207,6 +579,9 @@ func (cli *BinanceDeliveryClient) CancelOrdersByClientID(clientOrderIDs *[]strin
 }
 
 func (cli *BinanceDeliveryClient) CancelOrdersByOrderID(orderIDs *[]int64, symbol string) ([]int64, error) {
+	if !cli.checkLimit("batchCancel") {
+		return nil, errOrderRateLimited
+	}
 	orderNum := len(*orderIDs)
 	canceledIds := make([]int64, orderNum)
 
@@ -214,6 +589,7 @@ func (cli *BinanceDeliveryClient) CancelOrdersByOrderID(orderIDs *[]int64, symbo
 		Symbol(symbol).
 		OrderIDList(*orderIDs).Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error(err.Error())
 		return canceledIds, err
 	}
@@ -228,30 +604,36 @@ func (cli *BinanceDeliveryClient) CancelOrdersByOrderID(orderIDs *[]int64, symbo
 
 type BinanceDeliveryWSClient struct {
 	WSClient
-	httpClient     BinanceDeliveryClient
+	httpClient     OrderClient
+	streamClient   UserStreamClient
 	priceWSHandler PriceProcessHandler
 	orderWSHandler OrderProcessHandler
 	errorHandler   ErrorHandler
 
-	symbols         []string //多币种
-	listenKey       string
-	bookTickerStopC []chan struct{} // bookTicker channel
-	depthStopC      []chan struct{} // bookTicker channel
-	trxOrderStopC   chan struct{}   // transaction order channel
+	symbols     []string           //多币种
+	connections *ConnectionManager // 按 stream 和 symbol 管理 websocket 连接
+	userStream  *UserStreamManager // 订单消息的 listenKey 管理
+
+	userStreamReconnectHandler func()          // 订单消息重连之后的回调
+	stream                     *CombinedStream // bookTicker 和 depth 共用一条组合流连接
 
 	bookTickerLastUpdateIDMap sync.Map // 上一次symbol 更新 bookTicker 价格的 id
-	depthLastUpdateIDMap      sync.Map // 上一次更新depth 价格的 id
 
-	// depth data
-	Asks sync.Map // symbol => []DepthPriceItem
-	Bids sync.Map
+	// 本地订单簿，由增量深度消息维护
+	mutex      sync.RWMutex          // 运行时可以增删symbol，保护symbols和orderBooks
+	orderBooks map[string]*OrderBook // symbol => OrderBook
 }
 
 func (cli *BinanceDeliveryWSClient) Init(config Config) bool {
-	cli.symbols = config.Symbols
-	for _, symbol := range cli.symbols {
-		cli.bookTickerLastUpdateIDMap.Store(symbol, int64(0))
-		cli.depthLastUpdateIDMap.Store(symbol, int64(0))
+	cli.connections = NewConnectionManager("Binance delivery")
+	cli.stream = NewCombinedStream("Binance delivery", binanceDeliveryCombinedEndpoint())
+	cli.orderBooks = map[string]*OrderBook{}
+	for _, symbol := range config.Symbols {
+		err := cli.addSymbol(symbol)
+		if err != nil {
+			logger.Error("Binance delivery subscribe %s failed, message is %s", symbol, err.Error())
+			return false
+		}
 	}
 	return true
 }
@@ -264,49 +646,140 @@ func (cli *BinanceDeliveryWSClient) SetOrderHandler(handler OrderProcessHandler)
 	cli.orderWSHandler = handler
 }
 
-func (cli *BinanceDeliveryWSClient) SetHttpClient(client BinanceDeliveryClient) {
-	cli.httpClient = client // 获取订单消息时，更新listenKey需要用到
+func (cli *BinanceDeliveryWSClient) SetHttpClient(client OrderClient) {
+	cli.httpClient = client
+	// 获取订单消息时，更新listenKey需要用到
+	if streamClient, ok := client.(UserStreamClient); ok {
+		cli.streamClient = streamClient
+	}
 }
 
 func (cli *BinanceDeliveryWSClient) StartWS() bool {
-	for _, symbol := range cli.symbols {
-		// 启动 bookTicker
-		cli.bookTickerWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
-
-		// 启动 depth
-		cli.depthWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
-	}
+	// bookTicker 和 depth 共用一条组合流连接
+	cli.connections.Add("combined", "", cli.combinedWSConnect)
 
 	// 获取 listenKey，监听transaction 消息时，需要这个 key
-	listenKey := cli.httpClient.GetListenKey()
-	if listenKey == "" {
+	if cli.streamClient == nil {
+		logger.Error("binance delivery http client does not support user data stream")
+		return false
+	}
+	cli.userStream = NewUserStreamManager("Binance delivery", cli.streamClient, cli.connections, cli.userDataServe)
+	cli.userStream.SetReconnectHandler(cli.userStreamReconnectHandler)
+	if !cli.userStream.Start() {
 		logger.Error("get binance delivery listen key failed, exit the program")
 		return false
 	}
-	cli.listenKey = listenKey
-	cli.orderWSConnect()
+	return true
+}
 
-	// listenKey 每60分钟过期一次，所以需要加个定时器，提前续期
-	go common.Timer(30*time.Minute, cli.refreshListenKey)
+// 订单消息重连之后的回调，断线期间的订单消息已经丢失，需要核对订单和持仓
+func (cli *BinanceDeliveryWSClient) SetUserStreamReconnectHandler(handler func()) {
+	cli.userStreamReconnectHandler = handler
+}
+
+// 组合流连接，每次连接都清空订单簿，收到增量消息后重新同步
+func (cli *BinanceDeliveryWSClient) combinedWSConnect(errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	cli.mutex.RLock()
+	for _, book := range cli.orderBooks {
+		book.Reset()
+	}
+	cli.mutex.RUnlock()
+	return cli.stream.Connect(errHandler)
+}
 
+// 运行时增加symbol，通过SUBSCRIBE把bookTicker和depth加到组合流中
+func (cli *BinanceDeliveryWSClient) Subscribe(symbol string) bool {
+	err := cli.addSymbol(symbol)
+	if err != nil {
+		logger.Error("Binance delivery subscribe %s failed, message is %s", symbol, err.Error())
+		return false
+	}
 	return true
 }
 
-func (cli *BinanceDeliveryWSClient) bookTickerWSConnect(symbol string) bool {
-	_, stopC, err := delivery.WsBookTickerServe(symbol, cli.bookTickerMsgHandler, cli.bookTickerErrorHandler)
+// 运行时删除symbol，通过UNSUBSCRIBE取消订阅
+func (cli *BinanceDeliveryWSClient) Unsubscribe(symbol string) bool {
+	cli.removeSymbol(symbol)
+	err := cli.stream.Unsubscribe(cli.streamNames(symbol)...)
 	if err != nil {
-		logger.Error("Failed to establish connection with delivery BookTicker websocket, message is %s", err.Error())
+		logger.Error("Binance delivery unsubscribe %s failed, message is %s", symbol, err.Error())
 		return false
 	}
-	logger.Info("Delivery BookTicker WS is established")
-	cli.bookTickerStopC = append(cli.bookTickerStopC, stopC)
 	return true
 }
 
+func (cli *BinanceDeliveryWSClient) addSymbol(symbol string) error {
+	cli.mutex.Lock()
+	if _, ok := cli.orderBooks[symbol]; ok {
+		cli.mutex.Unlock()
+		return nil
+	}
+	cli.symbols = append(cli.symbols, symbol)
+	cli.orderBooks[symbol] = NewOrderBook(symbol, true)
+	cli.bookTickerLastUpdateIDMap.Store(symbol, int64(0))
+	cli.mutex.Unlock()
+
+	names := cli.streamNames(symbol)
+	err := cli.stream.Subscribe(map[string]CombinedStreamHandler{
+		names[0]: cli.bookTickerDataHandler,
+		names[1]: cli.depthDataHandler,
+	})
+	if err != nil {
+		cli.removeSymbol(symbol)
+		_ = cli.stream.Unsubscribe(names...)
+	}
+	return err
+}
+
+func (cli *BinanceDeliveryWSClient) removeSymbol(symbol string) {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	delete(cli.orderBooks, symbol)
+	cli.bookTickerLastUpdateIDMap.Delete(symbol)
+	for i, item := range cli.symbols {
+		if item == symbol {
+			cli.symbols = append(cli.symbols[:i], cli.symbols[i+1:]...)
+			break
+		}
+	}
+}
+
+// symbol在组合流中的stream名称：bookTicker、depth
+func (cli *BinanceDeliveryWSClient) streamNames(symbol string) []string {
+	name := strings.ToLower(symbol)
+	return []string{name + "@bookTicker", name + "@depth@100ms"}
+}
+
+func (cli *BinanceDeliveryWSClient) bookTickerDataHandler(data []byte) {
+	event := new(delivery.WsBookTickerEvent)
+	err := json.Unmarshal(data, event)
+	if err != nil {
+		logger.Error("Binance delivery parse bookTicker failed, message is %s", err.Error())
+		return
+	}
+	cli.bookTickerMsgHandler(event)
+}
+
+func (cli *BinanceDeliveryWSClient) depthDataHandler(data []byte) {
+	raw := new(binanceDepthEvent)
+	err := json.Unmarshal(data, raw)
+	if err != nil {
+		logger.Error("Binance delivery parse depth failed, message is %s", err.Error())
+		return
+	}
+	cli.depthMsgHandler(&delivery.WsDepthEvent{
+		Time:             raw.Time,
+		Symbol:           raw.Symbol,
+		FirstUpdateID:    raw.FirstUpdateID,
+		LastUpdateID:     raw.LastUpdateID,
+		PrevLastUpdateID: raw.PrevLastUpdateID,
+		Bids:             toPriceLevels(raw.Bids),
+		Asks:             toPriceLevels(raw.Asks),
+	})
+}
+
 func (cli *BinanceDeliveryWSClient) bookTickerMsgHandler(event *delivery.WsBookTickerEvent) {
-	if event.Symbol == "" || !common.InArray(event.Symbol, cli.symbols) {
+	if event.Symbol == "" || cli.GetOrderBook(event.Symbol) == nil {
 		return
 	}
 
@@ -367,154 +840,31 @@ func (cli *BinanceDeliveryWSClient) bookTickerMsgHandler(event *delivery.WsBookT
 	}
 }
 
-func (cli *BinanceDeliveryWSClient) bookTickerErrorHandler(err error) {
-	// todo 可以查看下error的消息，如果能只重连对应的链接是最好的
-	logger.Error("Binance delivery bookTickerErrorHandler emit, message: %s", err.Error())
-	// 重试链接
-	logger.Warn("Binance delivery bookTicker reconnect")
-	if cli.bookTickerStopC != nil {
-		for _, stopC := range cli.bookTickerStopC {
-			stopC <- struct{}{}
-		}
-	}
-	time.Sleep(30 * time.Millisecond)
-	for _, symbol := range cli.symbols {
-		cli.bookTickerWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
-	}
-}
-
-// 增量深度信息
-func (cli *BinanceDeliveryWSClient) depthWSConnect(symbol string) bool {
-	// 清空
-	var askItems []DepthPriceItem
-	var bidItems []DepthPriceItem
-	cli.Asks.Store(symbol, askItems)
-	cli.Bids.Store(symbol, bidItems)
-
-	rate := 100 * time.Millisecond
-	_, stopC, err := delivery.WsDiffDepthServeWithRate(symbol, &rate, cli.depthMsgHandler, cli.depthErrorHandler)
-	if err != nil {
-		logger.Error("failed to establish connection with delivery depth websocket, message is %s", err.Error())
-		return false
-	}
-	logger.Info("delivery depth WS is established")
-	cli.depthStopC = append(cli.depthStopC, stopC)
-
-	// 获取全量数据
-	cli.getDeliveryDepthPrice(symbol)
-	return true
-}
-
 func (cli *BinanceDeliveryWSClient) depthMsgHandler(event *delivery.WsDepthEvent) {
-	if event.Symbol == "" || !common.InArray(event.Symbol, cli.symbols) {
+	book := cli.GetOrderBook(event.Symbol)
+	if book == nil {
 		return
 	}
-	depthLastUpdateID, ok := cli.depthLastUpdateIDMap.Load(event.Symbol)
-	if !ok || depthLastUpdateID.(int64) >= event.LastUpdateID {
+	update, err := newDepthUpdate(event.FirstUpdateID, event.LastUpdateID, event.PrevLastUpdateID, event.Bids, event.Asks)
+	if err != nil {
+		logger.Error("Binance delivery depthMsgHandler parse depth failed, symbol=%s, message is %s", event.Symbol, err.Error())
 		return
 	}
-
-	var priceResp PriceWSResponse
-	symbol := event.Symbol
-
-	cli.parseDepthData(symbol, event.Bids, event.Asks, event.LastUpdateID)
-
-	askPriceItems, _ := cli.Asks.Load(symbol)
-	askItems := askPriceItems.([]DepthPriceItem)
-	bidPriceItems, _ := cli.Asks.Load(symbol)
-	bidItems := bidPriceItems.([]DepthPriceItem)
-	if len(askItems) > 0 || len(bidItems) > 0 {
-		cli.depthLastUpdateIDMap.Store(event.Symbol, event.LastUpdateID)
-		priceResp.Exchange = "Binance"
-		priceResp.MsgType = "deliveryDepth"
-		priceResp.TimeStamp = event.Time
-		priceResp.Asks = askItems
-		priceResp.Bids = bidItems
-		priceResp.Symbol = event.Symbol
-		cli.priceWSHandler(&priceResp)
+	if processDepthUpdate(book, update, cli.httpClient, "Binance delivery") {
+		cli.priceWSHandler(newDepthResponse(book, "deliveryDepth", event.Time))
 	}
 }
 
-func (cli *BinanceDeliveryWSClient) parseDepthData(symbol string, bids []futures.Bid, asks []futures.Ask, LastUpdateID int64) {
-	for _, bid := range bids {
-		bidPrice, err := decimal.NewFromString(bid.Price)
-		if err != nil {
-			logger.Error("Binance delivery depthMsgHandler convert bid price=%s to float64 error, message: %s",
-				bid.Price, err.Error())
-			return
-		}
-		bidQty, err := decimal.NewFromString(bid.Quantity)
-		if err != nil {
-			logger.Error("Binance delivery depthMsgHandler convert bid quantity=%s to float64 error, message: %s",
-				bid.Quantity, err.Error())
-			return
-		}
-		if bidPrice.IsPositive() && !bidQty.IsNegative() {
-			bidPriceItems, _ := cli.Bids.Load(symbol)
-			bidItems := bidPriceItems.([]DepthPriceItem)
-			item := DepthPriceItem{
-				Price:        bidPrice,
-				Volume:       bidQty,
-				LastUpdateID: LastUpdateID}
-			cli.Bids.Store(symbol, processOneStepDepthBinance(bidItems, item, "bid"))
-		}
-	}
-	// logger.Info("process_bid item: %d %+v", len(bids), bids)
-	// logger.Info("process_bid item: %d %+v", len(cli.Bids), cli.Bids)
-
-	for _, ask := range asks {
-		askPrice, err := decimal.NewFromString(ask.Price)
-		if err != nil {
-			logger.Error("Binance depthMsgHandler convert ask price=%s to float64 error, message: %s",
-				ask.Price, err.Error())
-			return
-		}
-		askQty, err := decimal.NewFromString(ask.Quantity)
-		if err != nil {
-			logger.Error("Binance depthMsgHandler convert ask quantity=%s to float64 error, message: %s",
-				ask.Quantity, err.Error())
-			return
-		}
-		if askPrice.IsPositive() && !askQty.IsNegative() {
-			askPriceItems, _ := cli.Asks.Load(symbol)
-			askItems := askPriceItems.([]DepthPriceItem)
-			item := DepthPriceItem{
-				Price:        askPrice,
-				Volume:       askQty,
-				LastUpdateID: LastUpdateID}
-			cli.Asks.Store(symbol, processOneStepDepthBinance(askItems, item, "ask"))
-		}
-	}
-	// logger.Info("process_ask item: %d %+v", len(asks), asks)
-	// logger.Info("process_ask item: %d %+v", len(cli.Asks), cli.Asks)
+// 获取本地订单簿，symbol没有订阅时返回nil
+func (cli *BinanceDeliveryWSClient) GetOrderBook(symbol string) *OrderBook {
+	cli.mutex.RLock()
+	defer cli.mutex.RUnlock()
+	return cli.orderBooks[symbol]
 }
 
-func (cli *BinanceDeliveryWSClient) depthErrorHandler(err error) {
-	logger.Error("Binance delivery depthErrorHandler emit, message: %s", err.Error())
-	// 重试链接
-	logger.Warn("Binance delivery depthErrorHandler reconnect")
-	if cli.depthStopC != nil {
-		for _, stopC := range cli.depthStopC {
-			stopC <- struct{}{}
-		}
-	}
-	time.Sleep(30 * time.Millisecond)
-	for _, symbol := range cli.symbols {
-		cli.depthWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
-	}
-}
-
-func (cli *BinanceDeliveryWSClient) orderWSConnect() bool {
-	_, stopC, err := delivery.WsUserDataServe(cli.listenKey, cli.orderHandler, cli.orderErrorHandler)
-	if err != nil {
-		logger.Error("failed to establish connection with delivery transaction order websocket, message is %s", err.Error())
-		return false
-	}
-	logger.Info("delivery transaction order WS is established")
-	cli.trxOrderStopC = stopC
-	return true
+// 订单消息连接，listenKey 由 UserStreamManager 管理
+func (cli *BinanceDeliveryWSClient) userDataServe(listenKey string, errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	return delivery.WsUserDataServe(listenKey, cli.orderHandler, errHandler)
 }
 
 func (cli *BinanceDeliveryWSClient) orderHandler(event *delivery.WsUserDataEvent) {
@@ -569,7 +919,7 @@ func (cli *BinanceDeliveryWSClient) orderHandler(event *delivery.WsUserDataEvent
 					orderResp.Exchange = "Binance"
 					orderResp.MsgType = topic
 
-					if common.InArray(item.Symbol, cli.symbols) {
+					if cli.GetOrderBook(item.Symbol) != nil {
 						logger.Info("ACCOUNT_UPDATE: ORDER=%+v", item)
 
 						positionAmount, err := strconv.ParseFloat(item.Amount, 64)
@@ -593,49 +943,22 @@ func (cli *BinanceDeliveryWSClient) orderHandler(event *delivery.WsUserDataEvent
 		for i := 0; i < size; i++ {
 			cli.orderWSHandler(&orderRespArr[i])
 		}
+	} else if event.Event == delivery.UserDataEventTypeListenKeyExpired {
+		cli.userStream.Expire("listenKeyExpired event")
 	}
 
 }
 
-func (cli *BinanceDeliveryWSClient) orderErrorHandler(err error) {
-	logger.Error("Binance delivery trxErrorHandler emit, message: %s", err.Error())
-	// 重试链接
-	logger.Warn("Binance delivery trx reconnect")
-	cli.orderWSConnect()
-}
-
-func (cli *BinanceDeliveryWSClient) refreshListenKey() {
-	cli.httpClient.KeepAliveListenKey(cli.listenKey)
-}
-
-func (cli *BinanceDeliveryWSClient) getDeliveryDepthPrice(symbol string) {
-	resp, err := cli.httpClient.GetDepthPriceInfo(symbol)
-	if err != nil {
-		logger.Error(err.Error())
-		return
-	}
-	cli.parseDepthData(symbol, resp.Bids, resp.Asks, resp.LastUpdateID)
-}
-
 func (cli *BinanceDeliveryWSClient) StopWS() bool {
-	// 关闭 bookTicker ws
-	if cli.bookTickerStopC != nil {
-		for _, stopC := range cli.bookTickerStopC {
-			stopC <- struct{}{}
-		}
+	if cli.userStream != nil {
+		cli.userStream.Stop()
 	}
-
-	// 关闭 depth ws
-	if cli.depthStopC != nil {
-		for _, stopC := range cli.depthStopC {
-			stopC <- struct{}{}
-		}
-	}
-
-	// 关闭 transaction order ws
-	if cli.trxOrderStopC != nil {
-		cli.trxOrderStopC <- struct{}{}
-	}
-
+	// 关闭所有连接，不再重连
+	cli.connections.Stop()
 	return true
 }
+
+// 各条连接的状态
+func (cli *BinanceDeliveryWSClient) StreamStates() []StreamState {
+	return cli.connections.States()
+}
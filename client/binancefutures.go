@@ -4,60 +4,378 @@ import (
 	"cex/common"
 	"cex/common/logger"
 	"context"
+	"encoding/json"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
-
-	"github.com/shopspring/decimal"
 
 	"github.com/adshao/go-binance/v2/futures"
 )
 
 type BinanceFuturesClient struct {
 	OrderClient
-	Name        string
-	orderClient *futures.Client
+	Name         string
+	orderClient  *futures.Client
+	rateLimiter  *RateLimiter // 币安的频率限制，同一个 API key 共用
+	limitProcess int
 }
 
 func (cli *BinanceFuturesClient) Init(config Config) bool {
 	cli.Name = "BinanceFutures"
 	cli.orderClient = futures.NewClient(config.AccessKey, config.SecretKey)
+	cli.rateLimiter = GetRateLimiter(config.AccessKey, ProductFutures)
+	cli.orderClient.HTTPClient = newRateLimitHTTPClient(cli.rateLimiter)
+	cli.limitProcess = config.LimitProcess
+	return true
+}
+
+// 判断API调用频率，name是接口名称，权重见 futuresRequests
+// 撤单和查询等待额度，下单按照limitProcess等待或者丢弃
+func (cli *BinanceFuturesClient) checkLimit(name string) bool {
+	request := getRateRequest(ProductFutures, name)
+	wait := request.Priority != PriorityOrder || cli.limitProcess == 1
+	err := cli.rateLimiter.Acquire(name, wait)
+	if err != nil {
+		logger.Info("Binance futures API Limit, request=%s, %s", name, cli.rateLimiter.String())
+		return false
+	}
 	return true
 }
 
+// 币安的频率限制，策略可以查询剩余的额度
+func (cli *BinanceFuturesClient) GetRateLimiter() *RateLimiter {
+	return cli.rateLimiter
+}
+
 func (cli *BinanceFuturesClient) GetDepthPriceInfo(symbol string) (*futures.DepthResponse, error) {
-	resp, err := cli.orderClient.NewDepthService().Symbol(symbol).Limit(20).Do(context.Background())
+	if !cli.checkLimit("depth") {
+		return nil, errOrderRateLimited
+	}
+	resp, err := cli.orderClient.NewDepthService().Symbol(symbol).Limit(depthSnapshotLimit).Do(context.Background())
 	if err != nil {
+		err = ParseBinanceError(err)
 		logger.Error(err.Error())
 		return nil, err
 	}
 	return resp, nil
 }
 
+func (cli *BinanceFuturesClient) GetDepth(symbol string) (*Depth, error) {
+	resp, err := cli.GetDepthPriceInfo(symbol)
+	if err != nil {
+		return nil, err
+	}
+	depth := &Depth{Symbol: symbol, LastUpdateID: resp.LastUpdateID}
+	depth.Bids, err = parsePriceLevels(resp.Bids, resp.LastUpdateID)
+	if err != nil {
+		logger.Error("Binance futures parse depth bids failed, symbol=%s, message is %s", symbol, err.Error())
+		return nil, err
+	}
+	depth.Asks, err = parsePriceLevels(resp.Asks, resp.LastUpdateID)
+	if err != nil {
+		logger.Error("Binance futures parse depth asks failed, symbol=%s, message is %s", symbol, err.Error())
+		return nil, err
+	}
+	return depth, nil
+}
+
+func (cli *BinanceFuturesClient) GetAccount() (*Account, error) {
+	if !cli.checkLimit("account") {
+		return nil, errOrderRateLimited
+	}
+	resp, err := cli.orderClient.NewGetAccountService().Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("get futures account failed, message is %s", err.Error())
+		return nil, err
+	}
+
+	account := new(Account)
+	for _, asset := range resp.Assets {
+		marginBalance, _ := strconv.ParseFloat(asset.MarginBalance, 64)
+		available, _ := strconv.ParseFloat(asset.MaxWithdrawAmount, 64)
+		account.Assets = append(account.Assets, AssetBalance{
+			Asset:         asset.Asset,
+			Free:          available,
+			Locked:        marginBalance - available,
+			MarginBalance: marginBalance,
+		})
+	}
+	for _, position := range resp.Positions {
+		positionAmt, _ := strconv.ParseFloat(position.PositionAmt, 64)
+		entryPrice, _ := strconv.ParseFloat(position.EntryPrice, 64)
+		leverage, _ := strconv.Atoi(position.Leverage)
+		account.Positions = append(account.Positions, Position{
+			Symbol:      position.Symbol,
+			PositionAmt: positionAmt,
+			EntryPrice:  entryPrice,
+			Leverage:    leverage,
+		})
+	}
+	return account, nil
+}
+
+func (cli *BinanceFuturesClient) GetPositions() ([]Position, error) {
+	account, err := cli.GetAccount()
+	if err != nil {
+		return nil, err
+	}
+	return account.Positions, nil
+}
+
+func (cli *BinanceFuturesClient) GetListenKey() (string, error) {
+	if !cli.checkLimit("listenKey") {
+		return "", errOrderRateLimited
+	}
+	listenKey, err := cli.orderClient.NewStartUserStreamService().Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("get futures listen key failed, message is %s", err.Error())
+		return "", err
+	}
+	return listenKey, nil
+}
+
+func (cli *BinanceFuturesClient) KeepAliveListenKey(listenKey string) error {
+	if !cli.checkLimit("listenKey") {
+		return errOrderRateLimited
+	}
+	err := cli.orderClient.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("keep alive futures listen key failed, message is %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// 查询当前挂单
+func (cli *BinanceFuturesClient) GetOpenOrders(symbol string) ([]*common.Order, error) {
+	if !cli.checkLimit("openOrders") {
+		return nil, errOrderRateLimited
+	}
+	resp, err := cli.orderClient.NewListOpenOrdersService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("get futures open orders failed, symbol=%s, message is %s", symbol, err.Error())
+		return nil, err
+	}
+
+	var orders []*common.Order
+	for _, item := range resp {
+		price, _ := strconv.ParseFloat(item.Price, 64)
+		origQty, _ := strconv.ParseFloat(item.OrigQuantity, 64)
+		executedQty, _ := strconv.ParseFloat(item.ExecutedQuantity, 64)
+		status := common.CREATED
+		if executedQty > 0 {
+			status = common.PARTIALLYFILLED
+		}
+		orders = append(orders, &common.Order{
+			Exchange:      "Binance",
+			Symbol:        item.Symbol,
+			OrderType:     strings.ToLower(string(item.Side)),
+			OrderID:       strconv.FormatInt(item.OrderID, 10),
+			OrderPrice:    price,
+			OrderVolume:   origQty - executedQty,
+			CreateAt:      item.Time / 1000,
+			ClientOrderID: item.ClientOrderID,
+			Status:        status,
+		})
+	}
+	return orders, nil
+}
+
+// 创建订单，如果成功返回orderID，否则返回 OrderError
+// 限价单，GTX（post only）
+func (cli *BinanceFuturesClient) PlaceOrderGTX(order *common.Order) (string, error) {
+	if !cli.checkLimit("order") {
+		return "", errOrderRateLimited
+	}
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = common.GetClientOrderID()
+	}
+	side := futures.SideTypeBuy
+	if order.OrderType == "sell" {
+		side = futures.SideTypeSell
+	} else if order.OrderType != "buy" {
+		return "", errOrderInvalidSide
+	}
+	symbol := common.FormatFuturesSymbol(order.Symbol, order.QuoteAsset)
+	fPrice := strconv.FormatFloat(order.OrderPrice, 'f', order.Precision[1], 64)
+	fQuantity := strconv.FormatFloat(order.OrderVolume, 'f', order.Precision[0], 64)
+
+	logger.Info("BinanceFuturesPlaceOrder: symbol=%s, side=%s, price=%s, quantity=%s, clientID=%s", symbol, order.OrderType, fPrice, fQuantity, order.ClientOrderID)
+	res, err := cli.orderClient.NewCreateOrderService().
+		NewClientOrderID(order.ClientOrderID).
+		Symbol(symbol).
+		Side(side).
+		Type(futures.OrderTypeLimit).
+		TimeInForce(futures.TimeInForceTypeGTX).
+		Price(fPrice).
+		Quantity(fQuantity).
+		Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("BinanceFuturesPlaceOrder error: side=%s, price=%s, amount=%s, symbol=%s, message is %s",
+			order.OrderType, fPrice, fQuantity, symbol, err.Error())
+		return "", err
+	}
+	return strconv.FormatInt(res.OrderID, 10), nil
+}
+
+// 市价单，如果成功返回orderID，否则返回 OrderError
+func (cli *BinanceFuturesClient) PlaceMarketOrder(order *common.Order) (string, error) {
+	if !cli.checkLimit("marketOrder") {
+		return "", errOrderRateLimited
+	}
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = common.GetClientOrderID()
+	}
+	side := futures.SideTypeBuy
+	if order.OrderType == "sell" {
+		side = futures.SideTypeSell
+	} else if order.OrderType != "buy" {
+		return "", errOrderInvalidSide
+	}
+	symbol := common.FormatFuturesSymbol(order.Symbol, order.QuoteAsset)
+	fQuantity := strconv.FormatFloat(order.OrderVolume, 'f', order.Precision[0], 64)
+
+	logger.Info("BinanceFuturesPlaceMarketOrder: symbol=%s, side=%s, quantity=%s, clientID=%s", symbol, order.OrderType, fQuantity, order.ClientOrderID)
+	res, err := cli.orderClient.NewCreateOrderService().
+		NewClientOrderID(order.ClientOrderID).
+		Symbol(symbol).
+		Side(side).
+		Type(futures.OrderTypeMarket).
+		Quantity(fQuantity).
+		Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("BinanceFuturesPlaceMarketOrder error: side=%s, amount=%s, symbol=%s, message is %s",
+			order.OrderType, fQuantity, symbol, err.Error())
+		return "", err
+	}
+	return strconv.FormatInt(res.OrderID, 10), nil
+}
+
+// 限价单，如果成功返回orderID，否则返回 OrderError
+func (cli *BinanceFuturesClient) PlaceLimitOrder(order *common.Order) (string, error) {
+	if !cli.checkLimit("limitOrder") {
+		return "", errOrderRateLimited
+	}
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = common.GetClientOrderID()
+	}
+	side := futures.SideTypeBuy
+	if order.OrderType == "sell" {
+		side = futures.SideTypeSell
+	} else if order.OrderType != "buy" {
+		return "", errOrderInvalidSide
+	}
+	symbol := common.FormatFuturesSymbol(order.Symbol, order.QuoteAsset)
+	fPrice := strconv.FormatFloat(order.OrderPrice, 'f', order.Precision[1], 64)
+	fQuantity := strconv.FormatFloat(order.OrderVolume, 'f', order.Precision[0], 64)
+
+	logger.Info("BinanceFuturesPlaceLimitOrder: symbol=%s, side=%s, price=%s, quantity=%s, clientID=%s", symbol, order.OrderType, fPrice, fQuantity, order.ClientOrderID)
+	res, err := cli.orderClient.NewCreateOrderService().
+		NewClientOrderID(order.ClientOrderID).
+		Symbol(symbol).
+		Side(side).
+		Type(futures.OrderTypeLimit).
+		TimeInForce(futures.TimeInForceTypeGTC).
+		Price(fPrice).
+		Quantity(fQuantity).
+		Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("BinanceFuturesPlaceLimitOrder error: side=%s, price=%s, amount=%s, symbol=%s, message is %s",
+			order.OrderType, fPrice, fQuantity, symbol, err.Error())
+		return "", err
+	}
+	return strconv.FormatInt(res.OrderID, 10), nil
+}
+
+// 按ClientOrderID撤单，返回撤单前已经成交的数量
+func (cli *BinanceFuturesClient) CancelOrder(order *common.Order) (float64, error) {
+	if !cli.checkLimit("cancel") {
+		return 0, errOrderRateLimited
+	}
+	symbol := common.FormatFuturesSymbol(order.Symbol, order.QuoteAsset)
+	resp, err := cli.orderClient.NewCancelOrderService().
+		Symbol(symbol).
+		OrigClientOrderID(order.ClientOrderID).Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error("BinanceFuturesCancelOrder error: symbol=%s, clientID=%s, message is %s", symbol, order.ClientOrderID, err.Error())
+		return 0, err
+	}
+	executedQty, _ := strconv.ParseFloat(resp.ExecutedQuantity, 64)
+	return executedQty, nil
+}
+
+// 取消所有订单
+func (cli *BinanceFuturesClient) CancelAllOrders(symbol string) error {
+	if !cli.checkLimit("cancelAll") {
+		return errOrderRateLimited
+	}
+	err := cli.orderClient.NewCancelAllOpenOrdersService().Symbol(strings.ToUpper(symbol)).Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (cli *BinanceFuturesClient) CancelOrdersByClientID(clientOrderIDs *[]string, symbol string) ([]string, error) {
+	if !cli.checkLimit("batchCancel") {
+		return nil, errOrderRateLimited
+	}
+	var canceledIds []string
+
+	resp, err := cli.orderClient.NewCancelMultipleOrdersService().
+		Symbol(symbol).
+		OrigClientOrderIDList(*clientOrderIDs).Do(context.Background())
+	if err != nil {
+		err = ParseBinanceError(err)
+		logger.Error(err.Error())
+		return canceledIds, err
+	}
+
+	for _, order := range resp {
+		canceledIds = append(canceledIds, order.ClientOrderID)
+	}
+	return canceledIds, nil
+}
+
 type BinanceFuturesWSClient struct {
 	WSClient
-	httpClient     BinanceFuturesClient
+	httpClient     OrderClient
+	streamClient   UserStreamClient
 	priceWSHandler PriceProcessHandler
 	orderWSHandler OrderProcessHandler
 	errorHandler   ErrorHandler
 
-	symbols []string //多币种
-	//listenKey              string
-	bookTickerStopC           []chan struct{} // bookTicker channel
-	depthStopC                []chan struct{} // bookTicker channel
-	bookTickerLastUpdateIDMap sync.Map        // 上一次symbol 更新 bookTicker 价格的 id
-	depthLastUpdateIDMap      sync.Map        // 上一次更新depth 价格的 id
+	symbols                   []string           //多币种
+	connections               *ConnectionManager // 按 stream 和 symbol 管理 websocket 连接
+	stream                    *CombinedStream    // bookTicker 和 depth 共用一条组合流连接
+	userStream                *UserStreamManager // 对冲订单消息的 listenKey 管理
+	bookTickerLastUpdateIDMap sync.Map           // 上一次symbol 更新 bookTicker 价格的 id
 
-	// depth data
-	Asks sync.Map // symbol => []DepthPriceItem
-	Bids sync.Map
+	// 本地订单簿，由增量深度消息维护
+	mutex      sync.RWMutex          // 运行时可以增删symbol，保护symbols和orderBooks
+	orderBooks map[string]*OrderBook // symbol => OrderBook
 }
 
 func (cli *BinanceFuturesWSClient) Init(config Config) bool {
-	cli.symbols = config.Symbols
-	for _, symbol := range cli.symbols {
-		cli.bookTickerLastUpdateIDMap.Store(symbol, int64(0))
-		cli.depthLastUpdateIDMap.Store(symbol, int64(0))
+	cli.connections = NewConnectionManager("Binance futures")
+	cli.stream = NewCombinedStream("Binance futures", binanceFuturesCombinedEndpoint())
+	cli.orderBooks = map[string]*OrderBook{}
+	for _, symbol := range config.Symbols {
+		err := cli.addSymbol(symbol)
+		if err != nil {
+			logger.Error("Binance futures subscribe %s failed, message is %s", symbol, err.Error())
+			return false
+		}
 	}
 	return true
 }
@@ -70,36 +388,180 @@ func (cli *BinanceFuturesWSClient) SetOrderHandler(handler OrderProcessHandler)
 	cli.orderWSHandler = handler
 }
 
-func (cli *BinanceFuturesWSClient) SetHttpClient(client BinanceFuturesClient) {
-	cli.httpClient = client // 获取订单消息时，更新listenKey需要用到
+func (cli *BinanceFuturesWSClient) SetHttpClient(client OrderClient) {
+	cli.httpClient = client // 获取全量深度时需要用到
+	// 获取对冲订单消息时，更新listenKey需要用到
+	if streamClient, ok := client.(UserStreamClient); ok {
+		cli.streamClient = streamClient
+	}
 }
 
 func (cli *BinanceFuturesWSClient) StartWS() bool {
-	for _, symbol := range cli.symbols {
-		// 启动 bookTicker
-		cli.bookTickerWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
+	// bookTicker 和 depth 共用一条组合流连接
+	cli.connections.Add("combined", "", cli.combinedWSConnect)
+
+	// 设置了订单消息的处理函数才订阅用户数据流
+	if cli.orderWSHandler == nil {
+		return true
+	}
+	if cli.streamClient == nil {
+		logger.Error("binance futures http client does not support user data stream")
+		return false
+	}
+	cli.userStream = NewUserStreamManager("Binance futures", cli.streamClient, cli.connections, cli.userDataServe)
+	if !cli.userStream.Start() {
+		logger.Error("get binance futures listen key failed")
+		return false
+	}
+	return true
+}
 
-		// 启动 depth
-		cli.depthWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
+// 订单消息连接，listenKey 由 UserStreamManager 管理
+func (cli *BinanceFuturesWSClient) userDataServe(listenKey string, errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	return futures.WsUserDataServe(listenKey, cli.orderHandler, errHandler)
+}
+
+// ORDER_TRADE_UPDATE 转换成 OrderWSResponse，成交时 OrderPrice、OrderVolume 是这一笔成交的价格和数量
+func (cli *BinanceFuturesWSClient) orderHandler(event *futures.WsUserDataEvent) {
+	if event.Event == futures.UserDataEventTypeListenKeyExpired {
+		cli.userStream.Expire("listenKeyExpired event")
+		return
+	}
+	if event.Event != futures.UserDataEventTypeOrderTradeUpdate {
+		return
+	}
+	orderEvent := event.OrderTradeUpdate
+	logger.Info("futures order event is: %+v", orderEvent)
+
+	var orderResp OrderWSResponse
+	orderResp.Exchange = "Binance"
+	orderResp.MsgType = string(event.Event)
+	orderResp.TimeStamp = event.TransactionTime
+	price, volume := 0.0, 0.0
+	if orderEvent.ExecutionType == futures.OrderExecutionTypeTrade {
+		price, _ = strconv.ParseFloat(orderEvent.LastFilledPrice, 64)
+		volume, _ = strconv.ParseFloat(orderEvent.LastFilledQty, 64)
+		orderResp.Fee, _ = strconv.ParseFloat(orderEvent.Commission, 64)
+		orderResp.FeeAsset = orderEvent.CommissionAsset
+	} else {
+		price, _ = strconv.ParseFloat(orderEvent.OriginalPrice, 64)
+		volume, _ = strconv.ParseFloat(orderEvent.OriginalQty, 64)
+	}
+	orderResp.Order.Exchange = "Binance"
+	orderResp.Order.Symbol = orderEvent.Symbol
+	orderResp.Order.OrderType = strings.ToLower(string(orderEvent.Side))
+	orderResp.Order.ClientOrderID = orderEvent.ClientOrderID
+	orderResp.Order.OrderID = strconv.FormatInt(orderEvent.ID, 10)
+	orderResp.Order.OrderPrice = price
+	orderResp.Order.OrderVolume = volume
+	orderResp.Status = string(orderEvent.Status)
+	orderResp.Symbol = orderEvent.Symbol
+	cli.orderWSHandler(&orderResp)
+}
+
+// 组合流连接，每次连接都清空订单簿，收到增量消息后重新同步
+func (cli *BinanceFuturesWSClient) combinedWSConnect(errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	cli.mutex.RLock()
+	for _, book := range cli.orderBooks {
+		book.Reset()
+	}
+	cli.mutex.RUnlock()
+	return cli.stream.Connect(errHandler)
+}
+
+// 运行时增加symbol，通过SUBSCRIBE把bookTicker和depth加到组合流中
+func (cli *BinanceFuturesWSClient) Subscribe(symbol string) bool {
+	err := cli.addSymbol(symbol)
+	if err != nil {
+		logger.Error("Binance futures subscribe %s failed, message is %s", symbol, err.Error())
+		return false
 	}
 	return true
 }
 
-func (cli *BinanceFuturesWSClient) bookTickerWSConnect(symbol string) bool {
-	_, stopC, err := futures.WsBookTickerServe(symbol, cli.bookTickerMsgHandler, cli.bookTickerErrorHandler)
+// 运行时删除symbol，通过UNSUBSCRIBE取消订阅
+func (cli *BinanceFuturesWSClient) Unsubscribe(symbol string) bool {
+	cli.removeSymbol(symbol)
+	err := cli.stream.Unsubscribe(cli.streamNames(symbol)...)
 	if err != nil {
-		logger.Error("failed to establish connection with binance futures bookTicker websocket, message is %s", err.Error())
+		logger.Error("Binance futures unsubscribe %s failed, message is %s", symbol, err.Error())
 		return false
 	}
-	logger.Info("Binance futures bookTicker WS is established, symbol:%s", symbol)
-	cli.bookTickerStopC = append(cli.bookTickerStopC, stopC)
 	return true
 }
 
+func (cli *BinanceFuturesWSClient) addSymbol(symbol string) error {
+	cli.mutex.Lock()
+	if _, ok := cli.orderBooks[symbol]; ok {
+		cli.mutex.Unlock()
+		return nil
+	}
+	cli.symbols = append(cli.symbols, symbol)
+	cli.orderBooks[symbol] = NewOrderBook(symbol, true)
+	cli.bookTickerLastUpdateIDMap.Store(symbol, int64(0))
+	cli.mutex.Unlock()
+
+	names := cli.streamNames(symbol)
+	err := cli.stream.Subscribe(map[string]CombinedStreamHandler{
+		names[0]: cli.bookTickerDataHandler,
+		names[1]: cli.depthDataHandler,
+	})
+	if err != nil {
+		cli.removeSymbol(symbol)
+		_ = cli.stream.Unsubscribe(names...)
+	}
+	return err
+}
+
+func (cli *BinanceFuturesWSClient) removeSymbol(symbol string) {
+	cli.mutex.Lock()
+	defer cli.mutex.Unlock()
+	delete(cli.orderBooks, symbol)
+	cli.bookTickerLastUpdateIDMap.Delete(symbol)
+	for i, item := range cli.symbols {
+		if item == symbol {
+			cli.symbols = append(cli.symbols[:i], cli.symbols[i+1:]...)
+			break
+		}
+	}
+}
+
+// symbol在组合流中的stream名称：bookTicker、depth
+func (cli *BinanceFuturesWSClient) streamNames(symbol string) []string {
+	name := strings.ToLower(symbol)
+	return []string{name + "@bookTicker", name + "@depth@100ms"}
+}
+
+func (cli *BinanceFuturesWSClient) bookTickerDataHandler(data []byte) {
+	event := new(futures.WsBookTickerEvent)
+	err := json.Unmarshal(data, event)
+	if err != nil {
+		logger.Error("Binance futures parse bookTicker failed, message is %s", err.Error())
+		return
+	}
+	cli.bookTickerMsgHandler(event)
+}
+
+func (cli *BinanceFuturesWSClient) depthDataHandler(data []byte) {
+	raw := new(binanceDepthEvent)
+	err := json.Unmarshal(data, raw)
+	if err != nil {
+		logger.Error("Binance futures parse depth failed, message is %s", err.Error())
+		return
+	}
+	cli.depthMsgHandler(&futures.WsDepthEvent{
+		Time:             raw.Time,
+		Symbol:           raw.Symbol,
+		FirstUpdateID:    raw.FirstUpdateID,
+		LastUpdateID:     raw.LastUpdateID,
+		PrevLastUpdateID: raw.PrevLastUpdateID,
+		Bids:             toPriceLevels(raw.Bids),
+		Asks:             toPriceLevels(raw.Asks),
+	})
+}
+
 func (cli *BinanceFuturesWSClient) bookTickerMsgHandler(event *futures.WsBookTickerEvent) {
-	if event.Symbol == "" || !common.InArray(event.Symbol, cli.symbols) {
+	if event.Symbol == "" || cli.GetOrderBook(event.Symbol) == nil {
 		return
 	}
 
@@ -160,170 +622,38 @@ func (cli *BinanceFuturesWSClient) bookTickerMsgHandler(event *futures.WsBookTic
 	}
 }
 
-func (cli *BinanceFuturesWSClient) bookTickerErrorHandler(err error) {
-	logger.Error("Binance futures bookTickerErrorHandler emit, message: %s", err.Error())
-	// 重试链接
-	logger.Warn("Binance futures  bookTicker reconnect")
-
-	if cli.bookTickerStopC != nil {
-		for _, stopC := range cli.bookTickerStopC {
-			stopC <- struct{}{}
-		}
-	}
-	time.Sleep(30 * time.Millisecond)
-	for _, symbol := range cli.symbols {
-		cli.bookTickerWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
-	}
-}
-
-// 增量深度信息
-func (cli *BinanceFuturesWSClient) depthWSConnect(symbol string) bool {
-	// 清空
-	var askItems []DepthPriceItem
-	var bidItems []DepthPriceItem
-	cli.Asks.Store(symbol, askItems)
-	cli.Bids.Store(symbol, bidItems)
-
-	_, stopC, err := futures.WsDiffDepthServeWithRate(symbol, 100*time.Millisecond, cli.depthMsgHandler, cli.depthErrorHandler)
-	if err != nil {
-		logger.Error("failed to establish connection with depth websocket, message is %s", err.Error())
-		return false
-	}
-	logger.Info("depth WS is established")
-	cli.depthStopC = append(cli.depthStopC, stopC)
-
-	// 获取全量数据
-	cli.getFuturesDepthPrice(symbol)
-
-	return true
-}
-
 func (cli *BinanceFuturesWSClient) depthMsgHandler(event *futures.WsDepthEvent) {
-	if event.Symbol == "" || !common.InArray(event.Symbol, cli.symbols) {
+	book := cli.GetOrderBook(event.Symbol)
+	if book == nil {
 		return
 	}
-	depthLastUpdateID, ok := cli.depthLastUpdateIDMap.Load(event.Symbol)
-	if !ok || depthLastUpdateID.(int64) >= event.LastUpdateID {
+	update, err := newDepthUpdate(event.FirstUpdateID, event.LastUpdateID, event.PrevLastUpdateID, event.Bids, event.Asks)
+	if err != nil {
+		logger.Error("Binance futures depthMsgHandler parse depth failed, symbol=%s, message is %s", event.Symbol, err.Error())
 		return
 	}
-
-	var priceResp PriceWSResponse
-	symbol := event.Symbol
-
-	cli.parseDepthData(symbol, event.Bids, event.Asks, event.LastUpdateID)
-
-	askPriceItems, _ := cli.Asks.Load(symbol)
-	askItems := askPriceItems.([]DepthPriceItem)
-	bidPriceItems, _ := cli.Asks.Load(symbol)
-	bidItems := bidPriceItems.([]DepthPriceItem)
-
-	if len(askItems) > 0 || len(bidItems) > 0 {
-		cli.depthLastUpdateIDMap.Store(event.Symbol, event.LastUpdateID)
-		priceResp.Exchange = "Binance"
-		priceResp.MsgType = "futuresDepth"
-		priceResp.TimeStamp = event.Time
-		priceResp.Asks = askItems
-		priceResp.Bids = bidItems
-		priceResp.Symbol = event.Symbol
-		cli.priceWSHandler(&priceResp)
+	if processDepthUpdate(book, update, cli.httpClient, "Binance futures") {
+		cli.priceWSHandler(newDepthResponse(book, "futuresDepth", event.Time))
 	}
 }
 
-func (cli *BinanceFuturesWSClient) parseDepthData(symbol string, bids []futures.Bid, asks []futures.Ask, LastUpdateID int64) {
-	for _, bid := range bids {
-		bidPrice, err := decimal.NewFromString(bid.Price)
-		if err != nil {
-			logger.Error("Binance futures depthMsgHandler convert bid price=%s to float64 error, message: %s",
-				bid.Price, err.Error())
-			return
-		}
-		bidQty, err := decimal.NewFromString(bid.Quantity)
-		if err != nil {
-			logger.Error("Binance futures depthMsgHandler convert bid quantity=%s to float64 error, message: %s",
-				bid.Quantity, err.Error())
-			return
-		}
-		if bidPrice.IsPositive() && !bidQty.IsNegative() {
-			bidPriceItems, _ := cli.Bids.Load(symbol)
-			bidItems := bidPriceItems.([]DepthPriceItem)
-			item := DepthPriceItem{
-				Price:        bidPrice,
-				Volume:       bidQty,
-				LastUpdateID: LastUpdateID}
-			cli.Bids.Store(symbol, processOneStepDepthBinance(bidItems, item, "bid"))
-		}
-	}
-	// logger.Info("process_bid item: %d %+v", len(bids), bids)
-	// logger.Info("process_bid item: %d %+v", len(cli.Bids), cli.Bids)
-
-	for _, ask := range asks {
-		askPrice, err := decimal.NewFromString(ask.Price)
-		if err != nil {
-			logger.Error("Binance depthMsgHandler convert ask price=%s to float64 error, message: %s",
-				ask.Price, err.Error())
-			return
-		}
-		askQty, err := decimal.NewFromString(ask.Quantity)
-		if err != nil {
-			logger.Error("Binance depthMsgHandler convert ask quantity=%s to float64 error, message: %s",
-				ask.Quantity, err.Error())
-			return
-		}
-		if askPrice.IsPositive() && !askQty.IsNegative() {
-			askPriceItems, _ := cli.Asks.Load(symbol)
-			askItems := askPriceItems.([]DepthPriceItem)
-			item := DepthPriceItem{
-				Price:        askPrice,
-				Volume:       askQty,
-				LastUpdateID: LastUpdateID}
-			cli.Asks.Store(symbol, processOneStepDepthBinance(askItems, item, "ask"))
-		}
-	}
-	// logger.Info("process_ask item: %d %+v", len(asks), asks)
-	// logger.Info("process_ask item: %d %+v", len(cli.Asks), cli.Asks)
-}
-
-func (cli *BinanceFuturesWSClient) depthErrorHandler(err error) {
-	logger.Error("Binance futures depthErrorHandler emit, message: %s", err.Error())
-	// 重试链接
-	logger.Warn("Binance futures depthErrorHandler reconnect")
-	if cli.depthStopC != nil {
-		for _, stopC := range cli.depthStopC {
-			stopC <- struct{}{}
-		}
-	}
-	time.Sleep(30 * time.Millisecond)
-	for _, symbol := range cli.symbols {
-		cli.depthWSConnect(symbol)
-		time.Sleep(30 * time.Millisecond)
-	}
-}
-
-func (cli *BinanceFuturesWSClient) getFuturesDepthPrice(symbol string) {
-	resp, err := cli.httpClient.GetDepthPriceInfo(symbol)
-	if err != nil {
-		logger.Error(err.Error())
-		return
-	}
-
-	cli.parseDepthData(symbol, resp.Bids, resp.Asks, resp.LastUpdateID)
+// 获取本地订单簿，symbol没有订阅时返回nil
+func (cli *BinanceFuturesWSClient) GetOrderBook(symbol string) *OrderBook {
+	cli.mutex.RLock()
+	defer cli.mutex.RUnlock()
+	return cli.orderBooks[symbol]
 }
 
 func (cli *BinanceFuturesWSClient) StopWS() bool {
-	// 关闭 bookTicker ws
-	if cli.bookTickerStopC != nil {
-		for _, stopC := range cli.bookTickerStopC {
-			stopC <- struct{}{}
-		}
+	if cli.userStream != nil {
+		cli.userStream.Stop()
 	}
-
-	// 关闭 depth ws
-	if cli.depthStopC != nil {
-		for _, stopC := range cli.depthStopC {
-			stopC <- struct{}{}
-		}
-	}
-
+	// 关闭所有连接，不再重连
+	cli.connections.Stop()
 	return true
 }
+
+// 各条连接的状态
+func (cli *BinanceFuturesWSClient) StreamStates() []StreamState {
+	return cli.connections.States()
+}
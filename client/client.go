@@ -3,6 +3,7 @@ package client
 import (
 	"cex/common"
 
+	"github.com/adshao/go-binance/v2/futures"
 	"github.com/shopspring/decimal"
 )
 
@@ -20,8 +21,118 @@ type Config struct {
 	ListenFuturesOrderMsg bool    //  是否监听U本位合约订单 order 信息
 }
 
+// 交易接口，策略只依赖这个接口，方便接入其他交易所或者测试用的模拟交易所
 type OrderClient interface {
+	// 初始化
 	Init(config Config) bool
+	// 限价挂单（post only），如果成功返回orderID
+	// 以下接口出错时都返回 OrderError，调用方根据 Kind 区分处理
+	PlaceOrderGTX(order *common.Order) (string, error)
+	// 市价单，如果成功返回orderID
+	PlaceMarketOrder(order *common.Order) (string, error)
+	// 根据ClientOrderID取消订单（必须相同交易对），返回取消成功的ClientOrderID
+	CancelOrdersByClientID(clientOrderIDs *[]string, symbol string) ([]string, error)
+	// 取消交易对的所有订单
+	CancelAllOrders(symbol string) error
+	// 查询交易对当前挂单
+	GetOpenOrders(symbol string) ([]*common.Order, error)
+	// 查询账户资产和持仓
+	GetAccount() (*Account, error)
+	// 查询持仓
+	GetPositions() ([]Position, error)
+	// 查询深度
+	GetDepth(symbol string) (*Depth, error)
+}
+
+// 用户数据流，通过listenKey获取订单、账户变动消息
+type UserStreamClient interface {
+	GetListenKey() (string, error)
+	KeepAliveListenKey(listenKey string) error
+}
+
+// 账户中的资产
+type AssetBalance struct {
+	Asset         string
+	Free          float64 // 可用数量
+	Locked        float64 // 冻结数量
+	MarginBalance float64 // 保证金余额，合约账户才有
+}
+
+// 批量下单中单个订单的结果
+type BatchOrderResult struct {
+	OrderID string
+	Err     error // OrderError
+}
+
+// 支持批量下单的交易接口，策略通过类型断言使用，不支持时逐个下单
+type BatchOrderClient interface {
+	// 每次最多下单的数量
+	MaxBatchOrders() int
+	// 批量限价挂单（post only），结果和orders一一对应
+	PlaceBatchOrdersGTX(orders []*common.Order) []BatchOrderResult
+}
+
+// 支持修改挂单的交易接口，策略通过类型断言使用，不支持时撤单后重新下单
+type AmendOrderClient interface {
+	// 修改挂单的价格和数量，返回交易所上按精度取整后的价格和数量
+	// 不修改order，调用方在orderbook的锁内更新
+	AmendOrder(order *common.Order, price float64, volume float64) (float64, float64, error)
+}
+
+// 支持可以立即成交的限价单的交易接口，对冲时用来限制滑点，不支持时使用市价单
+type LimitOrderClient interface {
+	// GTC 限价单，和对手盘交叉的部分立即成交，剩下的挂在盘口
+	PlaceLimitOrder(order *common.Order) (string, error)
+	// 按ClientOrderID撤单，返回撤单前已经成交的数量
+	CancelOrder(order *common.Order) (float64, error)
+}
+
+// K线
+type Kline struct {
+	OpenTime  int64 // 单位：ms
+	CloseTime int64
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+}
+
+// 支持查询K线的交易接口，启动时用来预热波动估计，不支持时从头积累
+type KlineClient interface {
+	// 最近 limit 根K线，按时间排序，interval e.g. 1m
+	GetKlines(symbol string, interval string, limit int) ([]Kline, error)
+}
+
+// 持仓信息
+type Position struct {
+	Symbol      string
+	PositionAmt float64 // 持仓量（多正，空负）
+	EntryPrice  float64 // 开仓均价
+	Leverage    int     // 杠杆倍数
+}
+
+// 账户信息
+type Account struct {
+	Assets    []AssetBalance
+	Positions []Position
+}
+
+// 获取资产信息，没有的话返回nil
+func (account *Account) GetAsset(asset string) *AssetBalance {
+	for i := 0; i < len(account.Assets); i++ {
+		if account.Assets[i].Asset == asset {
+			return &account.Assets[i]
+		}
+	}
+	return nil
+}
+
+// 全量深度信息
+type Depth struct {
+	Symbol       string
+	LastUpdateID int64
+	Bids         []DepthPriceItem // 价格从高到低
+	Asks         []DepthPriceItem // 价格从低到高
 }
 
 // ------------------以下是websocket相关的内容
@@ -63,6 +174,8 @@ type OrderWSResponse struct {
 	Symbol      string
 	Position    float64
 	PositionAbs float64
+	Fee         float64 // 这一笔成交的手续费，只有现货的成交消息有
+	FeeAsset    string
 }
 
 // 处理ws消息返回的数据
@@ -88,44 +201,19 @@ type WSClient interface {
 	StopWS() bool
 }
 
-func processOneStepDepthBinance(prices []DepthPriceItem, updateItem DepthPriceItem,
-	priceType string) []DepthPriceItem {
-	size := len(prices)
-	if size == 0 && updateItem.Price.IsPositive() && !updateItem.Volume.IsZero() {
-		prices = append(prices, updateItem)
-		return prices
-	}
-	for i := 0; i < size; i++ {
-		item := prices[i]
-		// 价格相同替换原有数量
-		if item.Price.Equal(updateItem.Price) {
-			// 数量为0，删除该档位价格
-			if updateItem.Volume.IsZero() {
-				prices = append(prices[:i], prices[i+1:]...)
-			} else if updateItem.LastUpdateID > item.LastUpdateID {
-				item.Volume = updateItem.Volume
-			}
-			break
-		} else if (priceType == "bid" && updateItem.Price.Cmp(item.Price) > 0) ||
-			(priceType == "ask" && updateItem.Price.Cmp(item.Price) < 0) {
-			if updateItem.Volume.IsZero() {
-				break
-			}
-			var tmp []DepthPriceItem
-			if i > 0 {
-				tmp = append(tmp, prices[:i]...)
-			}
-			tmp = append(tmp, updateItem)
-			prices = append(tmp, prices[i:]...)
-			break
-		} else if i == size-1 {
-			if updateItem.Volume.IsZero() {
-				break
-			}
-			// 添加到最后
-			prices = append(prices, updateItem)
-			break
+// 将币安的档位价格转换成DepthPriceItem
+func parsePriceLevels(levels []futures.Bid, lastUpdateID int64) ([]DepthPriceItem, error) {
+	items := make([]DepthPriceItem, 0, len(levels))
+	for _, level := range levels {
+		price, err := decimal.NewFromString(level.Price)
+		if err != nil {
+			return nil, err
+		}
+		volume, err := decimal.NewFromString(level.Quantity)
+		if err != nil {
+			return nil, err
 		}
+		items = append(items, DepthPriceItem{Price: price, Volume: volume, LastUpdateID: lastUpdateID})
 	}
-	return prices
+	return items, nil
 }
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"cex/common/logger"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/delivery"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/gorilla/websocket"
+)
+
+// 币安组合流的地址
+const (
+	binanceDeliveryCombinedURL        = "wss://dstream.binance.com/stream"
+	binanceDeliveryCombinedTestnetURL = "wss://dstream.binancefuture.com/stream"
+	binanceFuturesCombinedURL         = "wss://fstream.binance.com/stream"
+	binanceFuturesCombinedTestnetURL  = "wss://stream.binancefuture.com/stream"
+	binanceSpotCombinedURL            = "wss://stream.binance.com:9443/stream"
+	binanceSpotCombinedTestnetURL     = "wss://testnet.binance.vision/stream"
+)
+
+func binanceDeliveryCombinedEndpoint() string {
+	if delivery.UseTestnet {
+		return binanceDeliveryCombinedTestnetURL
+	}
+	return binanceDeliveryCombinedURL
+}
+
+func binanceFuturesCombinedEndpoint() string {
+	if futures.UseTestnet {
+		return binanceFuturesCombinedTestnetURL
+	}
+	return binanceFuturesCombinedURL
+}
+
+func binanceSpotCombinedEndpoint() string {
+	if binance.UseTestnet {
+		return binanceSpotCombinedTestnetURL
+	}
+	return binanceSpotCombinedURL
+}
+
+// 单个连接最多订阅的stream数量
+const combinedStreamMaxStreams = 200
+
+var ErrTooManyStreams = errors.New("too many streams in one combined stream")
+
+// 组合流中一个stream的消息回调，data是消息中的data字段
+type CombinedStreamHandler func(data []byte)
+
+// 组合流的消息，订阅的推送带stream和data，SUBSCRIBE等请求的响应带id
+type combinedStreamMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+	ID     int64           `json:"id"`
+	Error  *struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	} `json:"error"`
+}
+
+// 组合流中的增量深度消息，价格档位是["price","quantity"]数组，现货没有pu
+type binanceDepthEvent struct {
+	Time             int64       `json:"E"`
+	Symbol           string      `json:"s"`
+	FirstUpdateID    int64       `json:"U"`
+	LastUpdateID     int64       `json:"u"`
+	PrevLastUpdateID int64       `json:"pu"`
+	Bids             [][2]string `json:"b"`
+	Asks             [][2]string `json:"a"`
+}
+
+func toPriceLevels(items [][2]string) []futures.Bid {
+	levels := make([]futures.Bid, len(items))
+	for i, item := range items {
+		levels[i] = futures.Bid{Price: item[0], Quantity: item[1]}
+	}
+	return levels
+}
+
+type combinedStreamRequest struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	ID     int64    `json:"id"`
+}
+
+// 币安组合流，一条连接承载多个stream
+// 连接上之后订阅所有已注册的stream，运行时通过SUBSCRIBE/UNSUBSCRIBE增删订阅
+type CombinedStream struct {
+	name     string // 用于日志，e.g. Binance delivery
+	endpoint string
+
+	mutex     sync.Mutex
+	handlers  map[string]CombinedStreamHandler // stream name => handler, e.g. btcusd_perp@bookTicker
+	conn      *websocket.Conn
+	requestID int64
+
+	writeMutex sync.Mutex // websocket 不支持并发写
+}
+
+func NewCombinedStream(name string, endpoint string) *CombinedStream {
+	return &CombinedStream{
+		name:     name,
+		endpoint: endpoint,
+		handlers: map[string]CombinedStreamHandler{},
+	}
+}
+
+// 建立连接并订阅所有已注册的stream，可以直接作为 ConnectionManager 的连接函数
+func (stream *CombinedStream) Connect(errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	conn, _, err := websocket.DefaultDialer.Dial(stream.endpoint, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetReadLimit(655350)
+
+	stream.mutex.Lock()
+	stream.conn = conn
+	params := stream.streamNames()
+	stream.mutex.Unlock()
+
+	if len(params) > 0 {
+		err = stream.send(conn, "SUBSCRIBE", params)
+		if err != nil {
+			stream.closeConn(conn)
+			return nil, nil, err
+		}
+	}
+
+	doneC = make(chan struct{})
+	stopC = make(chan struct{})
+	go func() {
+		defer close(doneC)
+		defer stream.closeConn(conn)
+		// ReadMessage会阻塞，在另一个goroutine中等待stopC
+		stoppedC := make(chan struct{})
+		go func() {
+			select {
+			case <-stopC:
+				close(stoppedC)
+			case <-doneC:
+			}
+			conn.Close()
+		}()
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-stoppedC:
+				default:
+					errHandler(err)
+				}
+				return
+			}
+			stream.dispatch(message, errHandler)
+		}
+	}()
+	return doneC, stopC, nil
+}
+
+func (stream *CombinedStream) dispatch(message []byte, errHandler func(err error)) {
+	var msg combinedStreamMessage
+	err := json.Unmarshal(message, &msg)
+	if err != nil {
+		errHandler(err)
+		return
+	}
+	if msg.Stream == "" {
+		if msg.Error != nil {
+			logger.Error("%s combined stream request failed, id=%d, code=%d, message is %s",
+				stream.name, msg.ID, msg.Error.Code, msg.Error.Msg)
+		}
+		return
+	}
+
+	stream.mutex.Lock()
+	handler := stream.handlers[msg.Stream]
+	stream.mutex.Unlock()
+	if handler != nil {
+		handler(msg.Data)
+	}
+}
+
+// 注册并订阅stream，未连接时只注册，连接上之后再订阅
+func (stream *CombinedStream) Subscribe(handlers map[string]CombinedStreamHandler) error {
+	stream.mutex.Lock()
+	var params []string
+	for name := range handlers {
+		if _, ok := stream.handlers[name]; !ok {
+			params = append(params, name)
+		}
+	}
+	if len(stream.handlers)+len(params) > combinedStreamMaxStreams {
+		stream.mutex.Unlock()
+		return ErrTooManyStreams
+	}
+	for name, handler := range handlers {
+		stream.handlers[name] = handler
+	}
+	conn := stream.conn
+	stream.mutex.Unlock()
+
+	if conn == nil || len(params) == 0 {
+		return nil
+	}
+	sort.Strings(params)
+	return stream.send(conn, "SUBSCRIBE", params)
+}
+
+// 取消订阅stream
+func (stream *CombinedStream) Unsubscribe(names ...string) error {
+	stream.mutex.Lock()
+	var params []string
+	for _, name := range names {
+		if _, ok := stream.handlers[name]; ok {
+			delete(stream.handlers, name)
+			params = append(params, name)
+		}
+	}
+	conn := stream.conn
+	stream.mutex.Unlock()
+
+	if conn == nil || len(params) == 0 {
+		return nil
+	}
+	return stream.send(conn, "UNSUBSCRIBE", params)
+}
+
+// 已注册的stream，调用时需要持有锁
+func (stream *CombinedStream) streamNames() []string {
+	names := make([]string, 0, len(stream.handlers))
+	for name := range stream.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (stream *CombinedStream) send(conn *websocket.Conn, method string, params []string) error {
+	stream.mutex.Lock()
+	stream.requestID++
+	request := combinedStreamRequest{Method: method, Params: params, ID: stream.requestID}
+	stream.mutex.Unlock()
+
+	stream.writeMutex.Lock()
+	defer stream.writeMutex.Unlock()
+	logger.Info("%s combined stream %s, id=%d, streams=%v", stream.name, method, request.ID, params)
+	return conn.WriteJSON(request)
+}
+
+func (stream *CombinedStream) closeConn(conn *websocket.Conn) {
+	stream.mutex.Lock()
+	if stream.conn == conn {
+		stream.conn = nil
+	}
+	stream.mutex.Unlock()
+	conn.Close()
+}
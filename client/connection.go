@@ -0,0 +1,260 @@
+package client
+
+import (
+	"cex/common"
+	"cex/common/logger"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 连接状态
+const (
+	StreamConnecting   = "connecting"   // 正在建立连接
+	StreamConnected    = "connected"    // 已连接
+	StreamReconnecting = "reconnecting" // 连接断开，等待重连
+	StreamStopped      = "stopped"      // 已主动关闭
+)
+
+const (
+	streamMinBackoff    = 500 * time.Millisecond
+	streamMaxBackoff    = 30 * time.Second
+	streamStableTimeout = time.Minute // 连接保持超过这个时间，重连次数清零
+)
+
+// 单条 websocket 连接的状态
+type StreamState struct {
+	Exchange   string // e.g. Binance delivery
+	Stream     string // e.g. bookTicker、depth、order
+	Symbol     string
+	State      string
+	Retries    int    // 连续重连次数
+	LastError  string // 最近一次的错误
+	UpdateTime int64  // 状态变化的时间，单位ms
+}
+
+func (state StreamState) String() string {
+	message := fmt.Sprintf("%s %s %s is %s", state.Exchange, state.Symbol, state.Stream, state.State)
+	if state.Retries > 0 {
+		message += fmt.Sprintf(", retries=%d", state.Retries)
+	}
+	if state.LastError != "" {
+		message += ", last error: " + state.LastError
+	}
+	return message
+}
+
+// 可以报告连接状态的 WS client
+type StreamStateReporter interface {
+	StreamStates() []StreamState
+}
+
+// 建立一条连接，errHandler 需要传给 websocket，用来记录连接的错误
+type StreamConnectFunc func(errHandler func(err error)) (doneC, stopC chan struct{}, err error)
+
+type managedStream struct {
+	state       StreamState
+	connect     StreamConnectFunc
+	doneC       chan struct{}
+	stopC       chan struct{}
+	generation  int // 每次重连加1，旧连接的回调不再处理
+	connectTime time.Time
+}
+
+// 按 stream+symbol 管理 websocket 连接，某条连接断开时只重连这一条
+// 重连间隔按指数退避，加随机抖动，最长 streamMaxBackoff
+type ConnectionManager struct {
+	exchange string
+	mutex    sync.Mutex
+	streams  map[string]*managedStream
+	stopped  bool
+}
+
+func NewConnectionManager(exchange string) *ConnectionManager {
+	return &ConnectionManager{
+		exchange: exchange,
+		streams:  map[string]*managedStream{},
+	}
+}
+
+// 添加一条连接并立即连接，连接失败时在后台重试
+func (manager *ConnectionManager) Add(stream string, symbol string, connect StreamConnectFunc) bool {
+	key := stream + "@" + symbol
+	manager.mutex.Lock()
+	if _, ok := manager.streams[key]; ok {
+		manager.mutex.Unlock()
+		logger.Warn("%s %s %s stream already exists", manager.exchange, symbol, stream)
+		return false
+	}
+	managed := &managedStream{
+		state: StreamState{
+			Exchange: manager.exchange,
+			Stream:   stream,
+			Symbol:   symbol,
+		},
+		connect: connect,
+	}
+	manager.streams[key] = managed
+	manager.mutex.Unlock()
+
+	return manager.dial(managed)
+}
+
+func (manager *ConnectionManager) dial(managed *managedStream) bool {
+	manager.mutex.Lock()
+	if manager.stopped || managed.state.State == StreamStopped {
+		manager.mutex.Unlock()
+		return false
+	}
+	managed.generation++
+	generation := managed.generation
+	manager.setState(managed, StreamConnecting)
+	manager.mutex.Unlock()
+
+	doneC, stopC, err := managed.connect(func(err error) {
+		manager.onError(managed, generation, err)
+	})
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if err != nil {
+		logger.Error("failed to establish connection with %s %s %s websocket, message is %s",
+			manager.exchange, managed.state.Symbol, managed.state.Stream, err.Error())
+		managed.state.LastError = err.Error()
+		manager.scheduleReconnect(managed)
+		return false
+	}
+	// 连接期间被关闭了
+	if manager.stopped || generation != managed.generation {
+		closeStream(doneC, stopC)
+		return false
+	}
+	logger.Info("%s %s %s WS is established", manager.exchange, managed.state.Symbol, managed.state.Stream)
+	managed.doneC, managed.stopC = doneC, stopC
+	managed.connectTime = time.Now()
+	manager.setState(managed, StreamConnected)
+	go manager.watch(managed, generation, doneC)
+	return true
+}
+
+// 记录错误，websocket 消息解析失败也会回调，所以是否断开由 doneC 判断
+func (manager *ConnectionManager) onError(managed *managedStream, generation int, err error) {
+	logger.Error("%s %s %s websocket error, message: %s",
+		manager.exchange, managed.state.Symbol, managed.state.Stream, err.Error())
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if generation == managed.generation {
+		managed.state.LastError = err.Error()
+	}
+}
+
+// 连接断开后重连
+func (manager *ConnectionManager) watch(managed *managedStream, generation int, doneC chan struct{}) {
+	<-doneC
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if manager.stopped || generation != managed.generation || managed.state.State != StreamConnected {
+		return
+	}
+	if time.Since(managed.connectTime) > streamStableTimeout {
+		managed.state.Retries = 0
+	}
+	managed.doneC, managed.stopC = nil, nil
+	logger.Warn("%s %s %s websocket disconnected, reconnect", manager.exchange, managed.state.Symbol, managed.state.Stream)
+	manager.scheduleReconnect(managed)
+}
+
+// 主动断开一条连接，由 watch 按退避重连，用于连接参数失效的情况，e.g. listenKey 过期
+// 正在重连的连接下次连接时会使用新的参数，不需要处理
+func (manager *ConnectionManager) Reconnect(stream string, symbol string) bool {
+	manager.mutex.Lock()
+	managed, ok := manager.streams[stream+"@"+symbol]
+	if !ok || manager.stopped {
+		manager.mutex.Unlock()
+		return false
+	}
+	doneC, stopC := managed.doneC, managed.stopC
+	manager.mutex.Unlock()
+
+	closeStream(doneC, stopC)
+	return true
+}
+
+// 调用时需要持有锁
+func (manager *ConnectionManager) scheduleReconnect(managed *managedStream) {
+	if manager.stopped {
+		return
+	}
+	backoff := streamBackoff(managed.state.Retries)
+	managed.state.Retries++
+	manager.setState(managed, StreamReconnecting)
+	logger.Warn("%s %s %s reconnect in %s, retries=%d",
+		manager.exchange, managed.state.Symbol, managed.state.Stream, backoff, managed.state.Retries)
+	time.AfterFunc(backoff, func() {
+		manager.dial(managed)
+	})
+}
+
+// 调用时需要持有锁
+func (manager *ConnectionManager) setState(managed *managedStream, state string) {
+	managed.state.State = state
+	managed.state.UpdateTime = common.GetTimestampInMS()
+}
+
+// 所有连接的状态，按 symbol、stream 排序
+func (manager *ConnectionManager) States() []StreamState {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	states := make([]StreamState, 0, len(manager.streams))
+	for _, managed := range manager.streams {
+		states = append(states, managed.state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Symbol != states[j].Symbol {
+			return states[i].Symbol < states[j].Symbol
+		}
+		return states[i].Stream < states[j].Stream
+	})
+	return states
+}
+
+// 关闭所有连接，不再重连
+func (manager *ConnectionManager) Stop() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.stopped = true
+	for _, managed := range manager.streams {
+		if managed.state.State == StreamStopped {
+			continue
+		}
+		managed.generation++
+		closeStream(managed.doneC, managed.stopC)
+		managed.doneC, managed.stopC = nil, nil
+		manager.setState(managed, StreamStopped)
+	}
+}
+
+// 关闭连接，连接已经断开时不会阻塞
+func closeStream(doneC, stopC chan struct{}) {
+	if stopC == nil {
+		return
+	}
+	select {
+	case stopC <- struct{}{}:
+	case <-doneC:
+	}
+}
+
+// 第 retries 次重连的等待时间，在 [d/2, d] 之间随机，d 按 2 的指数增长
+func streamBackoff(retries int) time.Duration {
+	backoff := streamMaxBackoff
+	if retries < 16 {
+		backoff = streamMinBackoff << uint(retries)
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
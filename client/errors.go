@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	binancecommon "github.com/adshao/go-binance/v2/common"
+)
+
+// 订单错误的分类，调用方根据分类决定重试、改价、暂停交易对还是报警
+type OrderErrorKind int
+
+const (
+	ErrorKindOther              OrderErrorKind = iota // 其他错误，需要人工处理
+	ErrorKindPostOnlyRejected                         // post only 订单会立即成交，被拒绝
+	ErrorKindInsufficientMargin                       // 保证金或余额不足
+	ErrorKindUnknownSymbol                            // 交易对不存在或者不能交易
+	ErrorKindInvalidOrder                             // 价格、数量等参数不符合交易规则
+	ErrorKindUnknownStatus                            // 超时、网络断开等，订单是否成功未知
+	ErrorKindRateLimited                              // 超过频率限制或者被封禁
+	ErrorKindDuplicateOrder                           // clientOrderID 重复，订单已经存在
+	ErrorKindOrderNotFound                            // 撤单时订单不存在，已经成交或者撤销
+	ErrorKindAuth                                     // API key、签名、时间戳错误
+)
+
+var orderErrorKindNames = map[OrderErrorKind]string{
+	ErrorKindOther:              "other",
+	ErrorKindPostOnlyRejected:   "post_only_rejected",
+	ErrorKindInsufficientMargin: "insufficient_margin",
+	ErrorKindUnknownSymbol:      "unknown_symbol",
+	ErrorKindInvalidOrder:       "invalid_order",
+	ErrorKindUnknownStatus:      "unknown_status",
+	ErrorKindRateLimited:        "rate_limited",
+	ErrorKindDuplicateOrder:     "duplicate_order",
+	ErrorKindOrderNotFound:      "order_not_found",
+	ErrorKindAuth:               "auth",
+}
+
+func (kind OrderErrorKind) String() string {
+	if name, ok := orderErrorKindNames[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind(%d)", int(kind))
+}
+
+// 下单、撤单、查询接口返回的错误
+type OrderError struct {
+	Kind    OrderErrorKind
+	Code    int64 // 币安的错误码，不是币安返回的错误时为0
+	Message string
+	Err     error // 原始错误
+}
+
+func (err *OrderError) Error() string {
+	if err.Code != 0 {
+		return fmt.Sprintf("%s: code=%d, msg=%s", err.Kind, err.Code, err.Message)
+	}
+	return fmt.Sprintf("%s: %s", err.Kind, err.Message)
+}
+
+func (err *OrderError) Unwrap() error {
+	return err.Err
+}
+
+func NewOrderError(kind OrderErrorKind, err error) *OrderError {
+	return &OrderError{Kind: kind, Message: err.Error(), Err: err}
+}
+
+var (
+	ErrInvalidSide         = errors.New("invalid order side")
+	ErrTooManyBatchOrders  = errors.New("too many orders in one batch")
+	ErrBatchOrdersMismatch = errors.New("batch orders response size mismatch")
+)
+
+// 币安错误码对应的分类，参考币安 API 文档的错误代码
+// -2010、-2011 是现货和合约共用的笼统错误码，需要根据消息再细分，见 classifyBinanceMessage
+var binanceErrorKinds = map[int64]OrderErrorKind{
+	-1000: ErrorKindUnknownStatus, // UNKNOWN
+	-1001: ErrorKindUnknownStatus, // DISCONNECTED
+	-1006: ErrorKindUnknownStatus, // UNEXPECTED_RESP
+	-1007: ErrorKindUnknownStatus, // TIMEOUT
+	-1003: ErrorKindRateLimited,   // TOO_MANY_REQUESTS
+	-1015: ErrorKindRateLimited,   // TOO_MANY_ORDERS
+	-1008: ErrorKindRateLimited,   // SERVER_BUSY
+	-1021: ErrorKindAuth,          // INVALID_TIMESTAMP
+	-1022: ErrorKindAuth,          // INVALID_SIGNATURE
+	-2014: ErrorKindAuth,          // BAD_API_KEY_FMT
+	-2015: ErrorKindAuth,          // REJECTED_MBX_KEY
+	-1121: ErrorKindUnknownSymbol, // BAD_SYMBOL
+	-4140: ErrorKindUnknownSymbol, // INVALID_SYMBOL_STATUS
+	-2018: ErrorKindInsufficientMargin,
+	-2019: ErrorKindInsufficientMargin,
+	-5022: ErrorKindPostOnlyRejected, // 合约 GTX 订单会立即成交
+	-4116: ErrorKindDuplicateOrder,   // DUPLICATED_CLIENT_ORDER_ID
+	-2013: ErrorKindOrderNotFound,    // NO_SUCH_ORDER
+}
+
+// 把币安接口返回的错误转换成 OrderError，err为nil时返回nil
+func ParseBinanceError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var orderErr *OrderError
+	if errors.As(err, &orderErr) {
+		return orderErr
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return NewOrderError(ErrorKindRateLimited, err)
+	}
+
+	var apiErr *binancecommon.APIError
+	if errors.As(err, &apiErr) {
+		orderErr = &OrderError{Kind: ErrorKindOther, Code: apiErr.Code, Message: apiErr.Message, Err: err}
+		if kind, ok := binanceErrorKinds[apiErr.Code]; ok {
+			orderErr.Kind = kind
+		} else if apiErr.Code == 0 {
+			// 5xx 等响应不是json，订单是否成功未知
+			orderErr.Kind = ErrorKindUnknownStatus
+			orderErr.Message = "unexpected response"
+		} else if apiErr.Code == -2010 || apiErr.Code == -2011 {
+			orderErr.Kind = classifyBinanceMessage(apiErr.Message)
+		} else if apiErr.Code <= -1100 && apiErr.Code > -1200 || apiErr.Code <= -4000 && apiErr.Code > -5000 || apiErr.Code == -1013 {
+			// -11xx 参数错误，-4xxx 合约的下单规则错误，-1013 现货的 filter failure
+			orderErr.Kind = ErrorKindInvalidOrder
+		}
+		return orderErr
+	}
+
+	// 请求已经发出但没有收到响应，订单可能已经成功
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return NewOrderError(ErrorKindUnknownStatus, err)
+	}
+	return NewOrderError(ErrorKindOther, err)
+}
+
+// -2010 NEW_ORDER_REJECTED、-2011 CANCEL_REJECTED 的具体原因只在消息中
+func classifyBinanceMessage(message string) OrderErrorKind {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "immediately match"):
+		return ErrorKindPostOnlyRejected
+	case strings.Contains(message, "insufficient balance"):
+		return ErrorKindInsufficientMargin
+	case strings.Contains(message, "duplicate order"):
+		return ErrorKindDuplicateOrder
+	case strings.Contains(message, "unknown order"):
+		return ErrorKindOrderNotFound
+	case strings.Contains(message, "market is closed"):
+		return ErrorKindUnknownSymbol
+	}
+	return ErrorKindOther
+}
+
+// 错误的分类，不是 OrderError 时返回 ErrorKindOther
+func GetOrderErrorKind(err error) OrderErrorKind {
+	var orderErr *OrderError
+	if errors.As(err, &orderErr) {
+		return orderErr.Kind
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return ErrorKindRateLimited
+	}
+	return ErrorKindOther
+}
+
+// 判断错误是否属于某个分类
+func IsOrderErrorKind(err error, kind OrderErrorKind) bool {
+	return err != nil && GetOrderErrorKind(err) == kind
+}
+
+var (
+	errOrderRateLimited = NewOrderError(ErrorKindRateLimited, ErrRateLimited)
+	errOrderInvalidSide = NewOrderError(ErrorKindInvalidOrder, ErrInvalidSide)
+)
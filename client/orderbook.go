@@ -0,0 +1,361 @@
+package client
+
+import (
+	"cex/common/logger"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/shopspring/decimal"
+)
+
+// 同步订单簿时获取快照的档位数量
+const depthSnapshotLimit = 1000
+
+// 推送depth消息时带上的档位数量
+const depthPushLevels = 20
+
+// 缓存的增量消息最大数量，超过之后丢弃最早的，同步时会因为不连续而重新获取快照
+const depthBufferSize = 1000
+
+// 订阅之后等待一段时间再获取快照，确保快照之后的增量消息都已经缓存；两次获取快照的最小间隔
+const depthSyncInterval = 500 * time.Millisecond
+
+var ErrDepthGap = errors.New("depth update id is not continuous")
+
+// 增量深度消息，对应币安diff depth中的U、u、pu
+type DepthUpdate struct {
+	FirstUpdateID    int64 // U
+	LastUpdateID     int64 // u
+	PrevLastUpdateID int64 // pu，只有合约有
+	Bids             []DepthPriceItem
+	Asks             []DepthPriceItem
+}
+
+// 本地L2订单簿，按照币安文档维护：
+// 1. 先订阅增量消息，在快照返回之前缓存增量消息
+// 2. 获取快照，丢弃快照之前的增量消息，第一条增量消息需要包含快照的lastUpdateId
+// 3. 之后的增量消息需要连续：合约pu等于上一条的u，现货U等于上一条的u+1
+// 4. 发现不连续时清空订单簿，重新缓存增量消息，等待重新获取快照
+type OrderBook struct {
+	Symbol string
+
+	mutex        sync.RWMutex
+	withPrevID   bool             // 合约使用pu校验连续性，现货使用U
+	bids         []DepthPriceItem // 价格从高到低
+	asks         []DepthPriceItem // 价格从低到高
+	lastUpdateID int64
+	synced       bool
+	waitFirst    bool // 已经应用了快照，还没有应用第一条增量消息
+	syncing      bool
+	lastSyncTime time.Time
+	buffer       []*DepthUpdate
+}
+
+// withPrevID: 合约（币本位、U本位）为true，现货为false
+func NewOrderBook(symbol string, withPrevID bool) *OrderBook {
+	return &OrderBook{Symbol: symbol, withPrevID: withPrevID}
+}
+
+// 清空订单簿，重新开始缓存增量消息
+func (book *OrderBook) Reset() {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.reset()
+}
+
+func (book *OrderBook) reset() {
+	book.bids = nil
+	book.asks = nil
+	book.lastUpdateID = 0
+	book.synced = false
+	book.waitFirst = false
+	book.buffer = nil
+}
+
+// 开始同步，已经在同步中或者距离上次同步太近则返回false，避免频繁获取快照
+func (book *OrderBook) BeginSync() bool {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	if book.syncing || book.synced || time.Since(book.lastSyncTime) < depthSyncInterval {
+		return false
+	}
+	book.syncing = true
+	book.lastSyncTime = time.Now()
+	return true
+}
+
+func (book *OrderBook) EndSync() {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.syncing = false
+}
+
+func (book *OrderBook) Synced() bool {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	return book.synced
+}
+
+func (book *OrderBook) LastUpdateID() int64 {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	return book.lastUpdateID
+}
+
+// 处理增量消息，未同步时缓存起来，返回是否更新了订单簿
+// 返回ErrDepthGap时订单簿已经清空，需要重新获取快照
+func (book *OrderBook) Update(update *DepthUpdate) (bool, error) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	if !book.synced {
+		book.buffer = append(book.buffer, update)
+		if len(book.buffer) > depthBufferSize {
+			book.buffer = book.buffer[1:]
+		}
+		return false, nil
+	}
+	if update.LastUpdateID <= book.lastUpdateID {
+		// 重复的消息
+		return false, nil
+	}
+	if !book.accept(update) {
+		book.reset()
+		book.buffer = append(book.buffer, update)
+		return false, ErrDepthGap
+	}
+	book.apply(update)
+	return true, nil
+}
+
+// 用快照初始化订单簿，并应用缓存的增量消息
+// 返回ErrDepthGap说明快照和缓存的增量消息接不上，需要重新获取快照
+func (book *OrderBook) ApplySnapshot(depth *Depth) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	buffer := book.buffer
+	book.bids = nil
+	book.asks = nil
+	for _, item := range depth.Bids {
+		book.bids = updateLevel(book.bids, item, "bid")
+	}
+	for _, item := range depth.Asks {
+		book.asks = updateLevel(book.asks, item, "ask")
+	}
+	book.lastUpdateID = depth.LastUpdateID
+	book.buffer = nil
+	book.waitFirst = true
+
+	for i, update := range buffer {
+		if update.LastUpdateID <= depth.LastUpdateID {
+			continue
+		}
+		if !book.accept(update) {
+			book.reset()
+			book.buffer = append(book.buffer, buffer[i:]...)
+			return ErrDepthGap
+		}
+		book.apply(update)
+	}
+	book.synced = true
+	return nil
+}
+
+// 校验增量消息是否能接上当前订单簿
+func (book *OrderBook) accept(update *DepthUpdate) bool {
+	last := book.lastUpdateID
+	if book.waitFirst {
+		// 快照之后的第一条增量消息需要包含快照的lastUpdateId
+		if book.withPrevID {
+			return update.FirstUpdateID <= last && update.LastUpdateID >= last
+		}
+		return update.FirstUpdateID <= last+1 && update.LastUpdateID >= last+1
+	}
+	if book.withPrevID {
+		return update.PrevLastUpdateID == last
+	}
+	return update.FirstUpdateID == last+1
+}
+
+func (book *OrderBook) apply(update *DepthUpdate) {
+	for _, item := range update.Bids {
+		book.bids = updateLevel(book.bids, item, "bid")
+	}
+	for _, item := range update.Asks {
+		book.asks = updateLevel(book.asks, item, "ask")
+	}
+	book.lastUpdateID = update.LastUpdateID
+	book.waitFirst = false
+}
+
+// 最优买价，订单簿未同步或者没有深度时ok为false
+func (book *OrderBook) BestBid() (item DepthPriceItem, ok bool) {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	if !book.synced || len(book.bids) == 0 {
+		return item, false
+	}
+	return book.bids[0], true
+}
+
+// 最优卖价，订单簿未同步或者没有深度时ok为false
+func (book *OrderBook) BestAsk() (item DepthPriceItem, ok bool) {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	if !book.synced || len(book.asks) == 0 {
+		return item, false
+	}
+	return book.asks[0], true
+}
+
+// 前n档买单，n小于等于0返回全部
+func (book *OrderBook) Bids(n int) []DepthPriceItem {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	return copyDepthItems(book.bids, n)
+}
+
+// 前n档卖单，n小于等于0返回全部
+func (book *OrderBook) Asks(n int) []DepthPriceItem {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	return copyDepthItems(book.asks, n)
+}
+
+// 前n档的累计数量，priceType: bid | ask
+func (book *OrderBook) CumulativeVolume(priceType string, n int) decimal.Decimal {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	levels := book.levels(priceType)
+	if n <= 0 || n > len(levels) {
+		n = len(levels)
+	}
+	volume := decimal.Zero
+	for _, item := range levels[:n] {
+		volume = volume.Add(item.Volume)
+	}
+	return volume
+}
+
+// 价格不差于price的累计数量，即以price为限价吃单最多能成交的数量
+func (book *OrderBook) VolumeToPrice(priceType string, price decimal.Decimal) decimal.Decimal {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	volume := decimal.Zero
+	for _, item := range book.levels(priceType) {
+		if (priceType == "bid" && item.Price.LessThan(price)) || (priceType == "ask" && item.Price.GreaterThan(price)) {
+			break
+		}
+		volume = volume.Add(item.Volume)
+	}
+	return volume
+}
+
+func (book *OrderBook) levels(priceType string) []DepthPriceItem {
+	if priceType == "bid" {
+		return book.bids
+	}
+	return book.asks
+}
+
+// 获取快照同步订单簿，name用于日志，e.g. Binance delivery
+// 同步失败时订单簿继续缓存增量消息，下一条增量消息到达时会再次触发同步
+func syncOrderBook(book *OrderBook, httpClient OrderClient, name string) {
+	if httpClient == nil || !book.BeginSync() {
+		return
+	}
+	defer book.EndSync()
+
+	time.Sleep(depthSyncInterval)
+	depth, err := httpClient.GetDepth(book.Symbol)
+	if err != nil {
+		logger.Error("%s get depth snapshot failed, symbol=%s, message is %s", name, book.Symbol, err.Error())
+		return
+	}
+	err = book.ApplySnapshot(depth)
+	if err != nil {
+		logger.Warn("%s depth snapshot is not continuous with updates, symbol=%s, lastUpdateID=%d, resync later",
+			name, book.Symbol, depth.LastUpdateID)
+		return
+	}
+	logger.Info("%s order book is synced, symbol=%s, lastUpdateID=%d", name, book.Symbol, book.LastUpdateID())
+}
+
+// 处理增量深度消息，返回订单簿是否有更新，未同步时触发同步
+func processDepthUpdate(book *OrderBook, update *DepthUpdate, httpClient OrderClient, name string) bool {
+	applied, err := book.Update(update)
+	if err != nil {
+		logger.Warn("%s depth update is not continuous, symbol=%s, U=%d, u=%d, pu=%d, resync order book",
+			name, book.Symbol, update.FirstUpdateID, update.LastUpdateID, update.PrevLastUpdateID)
+	}
+	if !applied && !book.Synced() {
+		go syncOrderBook(book, httpClient, name)
+	}
+	return applied
+}
+
+// 把币安的增量深度消息转换成DepthUpdate，现货没有pu，传0
+func newDepthUpdate(firstUpdateID, lastUpdateID, prevLastUpdateID int64, bids []futures.Bid, asks []futures.Ask) (*DepthUpdate, error) {
+	update := &DepthUpdate{FirstUpdateID: firstUpdateID, LastUpdateID: lastUpdateID, PrevLastUpdateID: prevLastUpdateID}
+	var err error
+	update.Bids, err = parsePriceLevels(bids, lastUpdateID)
+	if err != nil {
+		return nil, err
+	}
+	update.Asks, err = parsePriceLevels(asks, lastUpdateID)
+	if err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// 生成depth消息，带上前depthPushLevels档
+func newDepthResponse(book *OrderBook, msgType string, timeStamp int64) *PriceWSResponse {
+	var priceResp PriceWSResponse
+	priceResp.Exchange = "Binance"
+	priceResp.MsgType = msgType
+	priceResp.TimeStamp = timeStamp
+	priceResp.UpdateID = book.LastUpdateID()
+	priceResp.Symbol = book.Symbol
+	priceResp.Bids = book.Bids(depthPushLevels)
+	priceResp.Asks = book.Asks(depthPushLevels)
+	return &priceResp
+}
+
+func copyDepthItems(items []DepthPriceItem, n int) []DepthPriceItem {
+	if n <= 0 || n > len(items) {
+		n = len(items)
+	}
+	return append([]DepthPriceItem{}, items[:n]...)
+}
+
+// 更新一档价格，数量为0时删除该档
+// bid按价格从高到低排序，ask按价格从低到高排序
+func updateLevel(levels []DepthPriceItem, item DepthPriceItem, priceType string) []DepthPriceItem {
+	if !item.Price.IsPositive() || item.Volume.IsNegative() {
+		return levels
+	}
+	i := sort.Search(len(levels), func(i int) bool {
+		if priceType == "bid" {
+			return levels[i].Price.LessThanOrEqual(item.Price)
+		}
+		return levels[i].Price.GreaterThanOrEqual(item.Price)
+	})
+	if i < len(levels) && levels[i].Price.Equal(item.Price) {
+		if item.Volume.IsZero() {
+			return append(levels[:i], levels[i+1:]...)
+		}
+		levels[i] = item
+		return levels
+	}
+	if item.Volume.IsZero() {
+		return levels
+	}
+	levels = append(levels, DepthPriceItem{})
+	copy(levels[i+1:], levels[i:])
+	levels[i] = item
+	return levels
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func depthItem(price float64, volume float64) DepthPriceItem {
+	return DepthPriceItem{Price: decimal.NewFromFloat(price), Volume: decimal.NewFromFloat(volume)}
+}
+
+type depthStep struct {
+	update      DepthUpdate
+	wantApplied bool
+	wantErr     error
+}
+
+func TestOrderBookSequence(t *testing.T) {
+	tests := []struct {
+		name            string
+		withPrevID      bool
+		buffered        []DepthUpdate // 快照之前缓存的增量消息
+		snapshotID      int64
+		wantSnapshotErr error
+		steps           []depthStep // 同步之后的增量消息
+		wantLastID      int64
+		wantSynced      bool
+	}{
+		{
+			name:       "futures first update contains snapshot id",
+			withPrevID: true,
+			buffered:   []DepthUpdate{{FirstUpdateID: 90, LastUpdateID: 95, PrevLastUpdateID: 89}, {FirstUpdateID: 96, LastUpdateID: 102, PrevLastUpdateID: 95}},
+			snapshotID: 100,
+			steps:      []depthStep{{DepthUpdate{FirstUpdateID: 103, LastUpdateID: 105, PrevLastUpdateID: 102}, true, nil}},
+			wantLastID: 105,
+			wantSynced: true,
+		},
+		{
+			name:            "futures snapshot older than buffered updates",
+			withPrevID:      true,
+			buffered:        []DepthUpdate{{FirstUpdateID: 105, LastUpdateID: 110, PrevLastUpdateID: 104}},
+			snapshotID:      100,
+			wantSnapshotErr: ErrDepthGap,
+			wantLastID:      0,
+			wantSynced:      false,
+		},
+		{
+			name:       "futures gap after sync",
+			withPrevID: true,
+			buffered:   []DepthUpdate{{FirstUpdateID: 98, LastUpdateID: 102, PrevLastUpdateID: 97}},
+			snapshotID: 100,
+			steps: []depthStep{
+				{DepthUpdate{FirstUpdateID: 103, LastUpdateID: 104, PrevLastUpdateID: 102}, true, nil},
+				{DepthUpdate{FirstUpdateID: 106, LastUpdateID: 108, PrevLastUpdateID: 105}, false, ErrDepthGap},
+			},
+			wantLastID: 0,
+			wantSynced: false,
+		},
+		{
+			name:       "futures duplicate update",
+			withPrevID: true,
+			snapshotID: 100,
+			steps: []depthStep{
+				{DepthUpdate{FirstUpdateID: 99, LastUpdateID: 101, PrevLastUpdateID: 98}, true, nil},
+				{DepthUpdate{FirstUpdateID: 99, LastUpdateID: 101, PrevLastUpdateID: 98}, false, nil},
+				{DepthUpdate{FirstUpdateID: 102, LastUpdateID: 103, PrevLastUpdateID: 101}, true, nil},
+			},
+			wantLastID: 103,
+			wantSynced: true,
+		},
+		{
+			name:       "spot first update contains snapshot id + 1",
+			buffered:   []DepthUpdate{{FirstUpdateID: 95, LastUpdateID: 100}, {FirstUpdateID: 101, LastUpdateID: 103}},
+			snapshotID: 100,
+			steps:      []depthStep{{DepthUpdate{FirstUpdateID: 104, LastUpdateID: 106}, true, nil}},
+			wantLastID: 106,
+			wantSynced: true,
+		},
+		{
+			name:       "spot gap after sync",
+			snapshotID: 100,
+			steps: []depthStep{
+				{DepthUpdate{FirstUpdateID: 101, LastUpdateID: 102}, true, nil},
+				{DepthUpdate{FirstUpdateID: 104, LastUpdateID: 105}, false, ErrDepthGap},
+			},
+			wantLastID: 0,
+			wantSynced: false,
+		},
+		{
+			name:       "spot first update does not contain snapshot id + 1",
+			snapshotID: 100,
+			steps:      []depthStep{{DepthUpdate{FirstUpdateID: 102, LastUpdateID: 104}, false, ErrDepthGap}},
+			wantLastID: 0,
+			wantSynced: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			book := NewOrderBook("BTCUSD_PERP", test.withPrevID)
+			for i := range test.buffered {
+				if applied, err := book.Update(&test.buffered[i]); applied || err != nil {
+					t.Fatalf("buffered update %d applied=%t, err=%v", i, applied, err)
+				}
+			}
+			err := book.ApplySnapshot(&Depth{LastUpdateID: test.snapshotID})
+			if !errors.Is(err, test.wantSnapshotErr) {
+				t.Fatalf("snapshot err = %v, want %v", err, test.wantSnapshotErr)
+			}
+			for i, step := range test.steps {
+				applied, err := book.Update(&step.update)
+				if applied != step.wantApplied || !errors.Is(err, step.wantErr) {
+					t.Errorf("step %d applied=%t, err=%v, want %t, %v", i, applied, err, step.wantApplied, step.wantErr)
+				}
+			}
+			if lastID := book.LastUpdateID(); lastID != test.wantLastID {
+				t.Errorf("lastUpdateID = %d, want %d", lastID, test.wantLastID)
+			}
+			if synced := book.Synced(); synced != test.wantSynced {
+				t.Errorf("synced = %t, want %t", synced, test.wantSynced)
+			}
+		})
+	}
+}
+
+// 不连续之后缓存新的增量消息，重新获取快照可以接上
+func TestOrderBookResync(t *testing.T) {
+	book := NewOrderBook("BTCUSD_PERP", true)
+	if err := book.ApplySnapshot(&Depth{LastUpdateID: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := book.Update(&DepthUpdate{FirstUpdateID: 105, LastUpdateID: 110, PrevLastUpdateID: 104}); !errors.Is(err, ErrDepthGap) {
+		t.Fatalf("err = %v, want ErrDepthGap", err)
+	}
+	if _, err := book.Update(&DepthUpdate{FirstUpdateID: 111, LastUpdateID: 112, PrevLastUpdateID: 110}); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.ApplySnapshot(&Depth{LastUpdateID: 108}); err != nil {
+		t.Fatal(err)
+	}
+	if lastID := book.LastUpdateID(); lastID != 112 || !book.Synced() {
+		t.Errorf("lastUpdateID = %d, synced = %t, want 112, true", lastID, book.Synced())
+	}
+}
+
+func TestOrderBookLevels(t *testing.T) {
+	book := NewOrderBook("BTCUSDT", false)
+	err := book.ApplySnapshot(&Depth{
+		LastUpdateID: 100,
+		Bids:         []DepthPriceItem{depthItem(99, 1), depthItem(98, 2)},
+		Asks:         []DepthPriceItem{depthItem(101, 1), depthItem(102, 2)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := &DepthUpdate{
+		FirstUpdateID: 101,
+		LastUpdateID:  101,
+		Bids:          []DepthPriceItem{depthItem(99, 0), depthItem(98.5, 3), depthItem(98, 4)},
+		Asks:          []DepthPriceItem{depthItem(100.5, 1), depthItem(102, 0)},
+	}
+	if _, err := book.Update(update); err != nil {
+		t.Fatal(err)
+	}
+	wantBids := []DepthPriceItem{depthItem(98.5, 3), depthItem(98, 4)}
+	wantAsks := []DepthPriceItem{depthItem(100.5, 1), depthItem(101, 1)}
+	for name, pair := range map[string][2][]DepthPriceItem{"bids": {book.Bids(0), wantBids}, "asks": {book.Asks(0), wantAsks}} {
+		got, want := pair[0], pair[1]
+		if len(got) != len(want) {
+			t.Fatalf("%s = %v, want %v", name, got, want)
+		}
+		for i := range want {
+			if !got[i].Price.Equal(want[i].Price) || !got[i].Volume.Equal(want[i].Volume) {
+				t.Errorf("%s[%d] = %s@%s, want %s@%s", name, i, got[i].Volume, got[i].Price, want[i].Volume, want[i].Price)
+			}
+		}
+	}
+}
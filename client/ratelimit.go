@@ -0,0 +1,339 @@
+package client
+
+import (
+	"cex/common/logger"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 产品线，币安不同产品线的频率限制是分开计算的
+const (
+	ProductDelivery = "delivery"
+	ProductFutures  = "futures"
+	ProductSpot     = "spot"
+)
+
+// 请求的优先级，额度紧张时先保证撤单
+const (
+	PriorityCancel = iota // 撤单、对冲的市价单，可以用满全部额度
+	PriorityOrder         // 下单
+	PriorityQuery         // 查询
+)
+
+// 不同优先级可以使用的额度比例，剩下的留给优先级更高的请求
+var priorityUsableRatio = map[int]float64{
+	PriorityCancel: 1,
+	PriorityOrder:  0.9,
+	PriorityQuery:  0.8,
+}
+
+var ErrRateLimited = errors.New("rate limited")
+
+// 一个接口的权重
+type RateRequest struct {
+	Name     string
+	Weight   int // 计入 REQUEST_WEIGHT 的权重
+	Orders   int // 计入 ORDERS 的下单数量，只有下单接口才有，批量接口是每个订单的数量
+	Priority int
+}
+
+// 币安的接口权重，参考币安 API 文档
+var (
+	deliveryRequests = map[string]RateRequest{
+		"exchangeInfo": {Weight: 1, Priority: PriorityQuery},
+		"leverage":     {Weight: 1, Priority: PriorityQuery},
+		"listenKey":    {Weight: 1, Priority: PriorityQuery},
+		"account":      {Weight: 5, Priority: PriorityQuery},
+		"depth":        {Weight: 20, Priority: PriorityQuery}, // limit=1000
+		"klines":       {Weight: 1, Priority: PriorityQuery},  // limit<100
+		"openOrders":   {Weight: 1, Priority: PriorityQuery},
+		"order":        {Weight: 1, Orders: 1, Priority: PriorityOrder},
+		"batchOrders":  {Weight: 5, Orders: 1, Priority: PriorityOrder}, // 下单数量按实际提交的订单数计算
+		"modifyOrder":  {Weight: 1, Orders: 1, Priority: PriorityOrder},
+		"marketOrder":  {Weight: 1, Orders: 1, Priority: PriorityCancel},
+		"cancelAll":    {Weight: 1, Priority: PriorityCancel},
+		"batchCancel":  {Weight: 1, Priority: PriorityCancel},
+	}
+	futuresRequests = map[string]RateRequest{
+		"listenKey":   {Weight: 1, Priority: PriorityQuery},
+		"account":     {Weight: 5, Priority: PriorityQuery},
+		"depth":       {Weight: 20, Priority: PriorityQuery}, // limit=1000
+		"openOrders":  {Weight: 1, Priority: PriorityQuery},
+		"order":       {Weight: 1, Orders: 1, Priority: PriorityOrder},
+		"marketOrder": {Weight: 1, Orders: 1, Priority: PriorityCancel},
+		"limitOrder":  {Weight: 1, Orders: 1, Priority: PriorityCancel}, // 对冲的限价单
+		"cancel":      {Weight: 1, Priority: PriorityCancel},
+		"cancelAll":   {Weight: 1, Priority: PriorityCancel},
+		"batchCancel": {Weight: 1, Priority: PriorityCancel},
+	}
+	spotRequests = map[string]RateRequest{
+		"listenKey":   {Weight: 2, Priority: PriorityQuery},
+		"account":     {Weight: 10, Priority: PriorityQuery},
+		"depth":       {Weight: 10, Priority: PriorityQuery}, // limit=1000
+		"openOrders":  {Weight: 3, Priority: PriorityQuery},
+		"order":       {Weight: 1, Orders: 1, Priority: PriorityOrder},
+		"marketOrder": {Weight: 1, Orders: 1, Priority: PriorityCancel},
+		"limitOrder":  {Weight: 1, Orders: 1, Priority: PriorityCancel}, // 对冲的限价单
+		"cancelAll":   {Weight: 1, Priority: PriorityCancel},
+		"cancel":      {Weight: 1, Priority: PriorityCancel},
+	}
+)
+
+// 获取接口的权重，没有配置的接口按权重1的查询处理
+func getRateRequest(product string, name string) RateRequest {
+	var requests map[string]RateRequest
+	switch product {
+	case ProductDelivery:
+		requests = deliveryRequests
+	case ProductFutures:
+		requests = futuresRequests
+	case ProductSpot:
+		requests = spotRequests
+	}
+	request, ok := requests[name]
+	if !ok {
+		request = RateRequest{Weight: 1, Priority: PriorityQuery}
+	}
+	request.Name = name
+	return request
+}
+
+// 一个频率限制的窗口，窗口按时间对齐，和币安一致
+type rateCounter struct {
+	name     string // e.g. weight_1m
+	header   string // 币安返回的使用量，e.g. X-Mbx-Used-Weight-1m
+	interval time.Duration
+	limit    int
+	orders   bool // 是否是下单数量的限制，否则是权重的限制
+
+	used        int
+	windowStart time.Time
+}
+
+// 窗口过期时清零，调用时需要持有锁
+func (counter *rateCounter) roll(now time.Time) {
+	windowStart := now.Truncate(counter.interval)
+	if windowStart.After(counter.windowStart) {
+		counter.windowStart = windowStart
+		counter.used = 0
+	}
+}
+
+func (counter *rateCounter) cost(request RateRequest) int {
+	if counter.orders {
+		return request.Orders
+	}
+	return request.Weight
+}
+
+// 币安各产品线的频率限制
+func newRateCounters(product string) []*rateCounter {
+	switch product {
+	case ProductDelivery:
+		return []*rateCounter{
+			{name: "weight_1m", header: "X-Mbx-Used-Weight-1m", interval: time.Minute, limit: 2400},
+			{name: "orders_1m", header: "X-Mbx-Order-Count-1m", interval: time.Minute, limit: 1200, orders: true},
+		}
+	case ProductFutures:
+		return []*rateCounter{
+			{name: "weight_1m", header: "X-Mbx-Used-Weight-1m", interval: time.Minute, limit: 2400},
+			{name: "orders_10s", header: "X-Mbx-Order-Count-10s", interval: 10 * time.Second, limit: 300, orders: true},
+			{name: "orders_1m", header: "X-Mbx-Order-Count-1m", interval: time.Minute, limit: 1200, orders: true},
+		}
+	default:
+		return []*rateCounter{
+			{name: "weight_1m", header: "X-Mbx-Used-Weight-1m", interval: time.Minute, limit: 1200},
+			{name: "orders_10s", header: "X-Mbx-Order-Count-10s", interval: 10 * time.Second, limit: 50, orders: true},
+			{name: "orders_1d", header: "X-Mbx-Order-Count-1d", interval: 24 * time.Hour, limit: 160000, orders: true},
+		}
+	}
+}
+
+// 一个频率限制窗口的使用情况
+type RateLimitStatus struct {
+	Name  string
+	Used  int
+	Limit int
+}
+
+// 币安的频率限制，同一个 API key 的同一个产品线共用一个
+// 请求前按接口权重预扣额度，返回后用响应头中的实际使用量校正
+type RateLimiter struct {
+	product     string
+	mutex       sync.Mutex
+	counters    []*rateCounter
+	bannedUntil time.Time // 收到429、418之后，在这之前不再发送请求
+}
+
+var (
+	rateLimitersMutex sync.Mutex
+	rateLimiters      = map[string]*RateLimiter{}
+)
+
+// 获取 API key 对应产品线的频率限制，同一个 API key 的多个 client 共用
+func GetRateLimiter(apiKey string, product string) *RateLimiter {
+	rateLimitersMutex.Lock()
+	defer rateLimitersMutex.Unlock()
+	key := product + "@" + apiKey
+	limiter, ok := rateLimiters[key]
+	if !ok {
+		limiter = &RateLimiter{product: product, counters: newRateCounters(product)}
+		rateLimiters[key] = limiter
+	}
+	return limiter
+}
+
+// 申请接口调用的额度，wait为true时等到有额度为止，否则额度不够直接返回ErrRateLimited
+func (limiter *RateLimiter) Acquire(name string, wait bool) error {
+	return limiter.AcquireN(name, 1, wait)
+}
+
+// 申请批量接口的额度，权重按一次请求计算，下单数量按 n 个订单计算
+func (limiter *RateLimiter) AcquireN(name string, n int, wait bool) error {
+	request := getRateRequest(limiter.product, name)
+	request.Orders *= n
+	for {
+		delay := limiter.tryAcquire(request, time.Now())
+		if delay == 0 {
+			return nil
+		}
+		if !wait {
+			return ErrRateLimited
+		}
+		logger.Warn("Binance %s rate limit, request=%s, wait %s", limiter.product, name, delay)
+		time.Sleep(delay)
+	}
+}
+
+// 额度足够时扣减额度并返回0，否则返回需要等待的时间
+func (limiter *RateLimiter) tryAcquire(request RateRequest, now time.Time) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if now.Before(limiter.bannedUntil) {
+		return limiter.bannedUntil.Sub(now)
+	}
+
+	ratio := priorityUsableRatio[request.Priority]
+	var delay time.Duration
+	for _, counter := range limiter.counters {
+		counter.roll(now)
+		cost := counter.cost(request)
+		if cost == 0 {
+			continue
+		}
+		if float64(counter.used+cost) > float64(counter.limit)*ratio {
+			if wait := counter.windowStart.Add(counter.interval).Sub(now); wait > delay {
+				delay = wait
+			}
+		}
+	}
+	if delay > 0 {
+		return delay
+	}
+	for _, counter := range limiter.counters {
+		counter.used += counter.cost(request)
+	}
+	return 0
+}
+
+// 用响应头校正使用量，响应头的使用量包括同一个IP、账户的其他程序
+func (limiter *RateLimiter) Update(response *http.Response) {
+	now := time.Now()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for _, counter := range limiter.counters {
+		value := response.Header.Get(counter.header)
+		if value == "" {
+			continue
+		}
+		used, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		counter.roll(now)
+		if used > counter.used {
+			counter.used = used
+		}
+	}
+
+	// 429 超过频率限制，418 IP 被封禁，Retry-After 是需要等待的秒数
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusTeapot {
+		retryAfter, err := strconv.Atoi(response.Header.Get("Retry-After"))
+		if err != nil || retryAfter <= 0 {
+			retryAfter = 60
+		}
+		limiter.bannedUntil = now.Add(time.Duration(retryAfter) * time.Second)
+		logger.Error("Binance %s rate limit exceeded, status=%d, retry after %ds", limiter.product, response.StatusCode, retryAfter)
+	}
+}
+
+// 剩余额度的比例，取所有窗口中最小的，被封禁时为0
+func (limiter *RateLimiter) Headroom() float64 {
+	now := time.Now()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if now.Before(limiter.bannedUntil) {
+		return 0
+	}
+	headroom := 1.0
+	for _, counter := range limiter.counters {
+		counter.roll(now)
+		if ratio := 1 - float64(counter.used)/float64(counter.limit); ratio < headroom {
+			headroom = ratio
+		}
+	}
+	if headroom < 0 {
+		headroom = 0
+	}
+	return headroom
+}
+
+// 各窗口的使用情况
+func (limiter *RateLimiter) Status() []RateLimitStatus {
+	now := time.Now()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	var status []RateLimitStatus
+	for _, counter := range limiter.counters {
+		counter.roll(now)
+		status = append(status, RateLimitStatus{Name: counter.name, Used: counter.used, Limit: counter.limit})
+	}
+	return status
+}
+
+func (limiter *RateLimiter) String() string {
+	var items []string
+	for _, status := range limiter.Status() {
+		items = append(items, fmt.Sprintf("%s=%d/%d", status.Name, status.Used, status.Limit))
+	}
+	return limiter.product + " " + strings.Join(items, ", ")
+}
+
+// 读取币安响应头的 http.RoundTripper
+type rateLimitTransport struct {
+	limiter *RateLimiter
+	base    http.RoundTripper
+}
+
+func (transport *rateLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := transport.base.RoundTrip(request)
+	if err == nil {
+		transport.limiter.Update(response)
+	}
+	return response, err
+}
+
+// 使用频率限制的 http.Client，响应头中的使用量会更新到limiter
+func newRateLimitHTTPClient(limiter *RateLimiter) *http.Client {
+	return &http.Client{Transport: &rateLimitTransport{limiter: limiter, base: http.DefaultTransport}}
+}
+
+// 有频率限制的交易接口，策略可以查询剩余的额度
+type RateLimitedClient interface {
+	GetRateLimiter() *RateLimiter
+}
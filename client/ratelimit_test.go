@@ -0,0 +1,66 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTryAcquire(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 10, 0, time.UTC)
+	tests := []struct {
+		name       string
+		request    string
+		orders     int // 批量下单的订单数
+		weightUsed int
+		ordersUsed int
+		banned     time.Duration
+		now        time.Time
+		wantDelay  time.Duration
+	}{
+		{"order within ratio", "order", 1, 2159, 0, 0, start, 0},
+		{"order over ratio", "order", 1, 2160, 0, 0, start, 50 * time.Second},
+		{"cancel uses full limit", "cancelAll", 1, 2399, 0, 0, start, 0},
+		{"cancel over limit", "cancelAll", 1, 2400, 0, 0, start, 50 * time.Second},
+		{"query over ratio", "openOrders", 1, 1920, 0, 0, start, 50 * time.Second},
+		{"batch orders counted per order", "batchOrders", 4, 0, 1076, 0, start, 0},
+		{"batch orders over order limit", "batchOrders", 5, 0, 1076, 0, start, 50 * time.Second},
+		{"next window", "order", 1, 2400, 1200, 0, start.Add(time.Minute), 0},
+		{"banned", "cancelAll", 1, 0, 0, 30 * time.Second, start, 30 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := &RateLimiter{product: ProductDelivery, counters: newRateCounters(ProductDelivery)}
+			for _, counter := range limiter.counters {
+				counter.roll(start)
+				counter.used = test.weightUsed
+				if counter.orders {
+					counter.used = test.ordersUsed
+				}
+			}
+			limiter.bannedUntil = start.Add(test.banned)
+
+			request := getRateRequest(ProductDelivery, test.request)
+			request.Orders *= test.orders
+			if delay := limiter.tryAcquire(request, test.now); delay != test.wantDelay {
+				t.Fatalf("delay = %s, want %s", delay, test.wantDelay)
+			}
+			if test.wantDelay > 0 {
+				return
+			}
+			// 申请成功后扣减额度
+			for _, counter := range limiter.counters {
+				cost, used := request.Weight, test.weightUsed
+				if counter.orders {
+					cost, used = request.Orders, test.ordersUsed
+				}
+				want := cost
+				if test.now.Equal(start) {
+					want += used
+				}
+				if counter.used != want {
+					t.Errorf("%s used = %d, want %d", counter.name, counter.used, want)
+				}
+			}
+		})
+	}
+}
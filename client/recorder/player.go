@@ -0,0 +1,176 @@
+package recorder
+
+import (
+	"bufio"
+	"cex/client"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrPlayerStopped = errors.New("player stopped")
+
+// 回放录制的消息，按录制顺序同步回调handler
+type Player struct {
+	path  string
+	Speed float64 // 回放速度，1是真实速度，2是两倍速，小于等于0表示尽可能快
+
+	mutex         sync.Mutex
+	priceHandlers map[string]client.PriceProcessHandler
+	orderHandlers map[string]client.OrderProcessHandler
+	timeHandler   func(timestamp int64)
+	stopC         chan struct{}
+	stopOnce      sync.Once
+}
+
+func NewPlayer(path string, speed float64) *Player {
+	return &Player{
+		path:          path,
+		Speed:         speed,
+		priceHandlers: map[string]client.PriceProcessHandler{},
+		orderHandlers: map[string]client.OrderProcessHandler{},
+		stopC:         make(chan struct{}),
+	}
+}
+
+func (player *Player) SetPriceHandler(stream string, handler client.PriceProcessHandler) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	if handler == nil {
+		delete(player.priceHandlers, stream)
+		return
+	}
+	player.priceHandlers[stream] = handler
+}
+
+func (player *Player) SetOrderHandler(stream string, handler client.OrderProcessHandler) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	if handler == nil {
+		delete(player.orderHandlers, stream)
+		return
+	}
+	player.orderHandlers[stream] = handler
+}
+
+// 每条消息回调之前先回调消息的接收时间，回测时用来推进模拟时钟
+func (player *Player) SetTimeHandler(handler func(timestamp int64)) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.timeHandler = handler
+}
+
+// 回放整个文件，阻塞到文件读完或者调用了Stop
+func (player *Player) Play() error {
+	file, err := os.Open(player.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	decoder := json.NewDecoder(gzipReader)
+	var firstTime int64
+	var startTime time.Time
+	for {
+		record := new(Record)
+		err := decoder.Decode(record)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if firstTime == 0 {
+			firstTime = record.Time
+			startTime = time.Now()
+		}
+		if player.Speed > 0 {
+			offset := time.Duration(float64(record.Time-firstTime)/player.Speed) * time.Millisecond
+			if wait := time.Until(startTime.Add(offset)); wait > 0 {
+				select {
+				case <-player.stopC:
+					return ErrPlayerStopped
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		select {
+		case <-player.stopC:
+			return ErrPlayerStopped
+		default:
+		}
+		player.dispatch(record)
+	}
+}
+
+func (player *Player) dispatch(record *Record) {
+	player.mutex.Lock()
+	priceHandler := player.priceHandlers[record.Stream]
+	orderHandler := player.orderHandlers[record.Stream]
+	timeHandler := player.timeHandler
+	player.mutex.Unlock()
+
+	if timeHandler != nil {
+		timeHandler(record.Time)
+	}
+	if record.Price != nil && priceHandler != nil {
+		priceHandler(record.Price)
+	}
+	if record.Order != nil && orderHandler != nil {
+		orderHandler(record.Order)
+	}
+}
+
+// 停止回放
+func (player *Player) Stop() {
+	player.stopOnce.Do(func() {
+		close(player.stopC)
+	})
+}
+
+// 回放的websocket客户端，实现client.WSClient，StartWS时把handler注册到Player上
+type WSClient struct {
+	player         *Player
+	stream         string
+	priceWSHandler client.PriceProcessHandler
+	orderWSHandler client.OrderProcessHandler
+}
+
+func NewWSClient(player *Player, stream string) *WSClient {
+	return &WSClient{player: player, stream: stream}
+}
+
+func (cli *WSClient) Init(config client.Config) bool {
+	return true
+}
+
+func (cli *WSClient) SetPriceHandler(handler client.PriceProcessHandler, errHandler client.ErrorHandler) {
+	cli.priceWSHandler = handler
+}
+
+func (cli *WSClient) SetOrderHandler(handler client.OrderProcessHandler) {
+	cli.orderWSHandler = handler
+}
+
+func (cli *WSClient) StartWS() bool {
+	cli.player.SetPriceHandler(cli.stream, cli.priceWSHandler)
+	cli.player.SetOrderHandler(cli.stream, cli.orderWSHandler)
+	return true
+}
+
+func (cli *WSClient) StopWS() bool {
+	cli.player.SetPriceHandler(cli.stream, nil)
+	cli.player.SetOrderHandler(cli.stream, nil)
+	return true
+}
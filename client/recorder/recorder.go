@@ -0,0 +1,113 @@
+package recorder
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// 消息来源
+const (
+	StreamDelivery = "delivery"
+	StreamFutures  = "futures"
+	StreamSpot     = "spot"
+)
+
+// 录制的一条消息，Price和Order只有一个不为空
+type Record struct {
+	Time   int64                   // 接收时间，单位ms
+	Stream string                  // 消息来源，e.g. delivery、futures、spot
+	Price  *client.PriceWSResponse `json:",omitempty"`
+	Order  *client.OrderWSResponse `json:",omitempty"`
+}
+
+// 把websocket消息按接收顺序写入 gzip 压缩的 JSONL 文件
+type Recorder struct {
+	mutex      sync.Mutex
+	file       *os.File
+	gzipWriter *gzip.Writer
+	encoder    *json.Encoder
+	errorCount int64
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	gzipWriter := gzip.NewWriter(file)
+	return &Recorder{
+		file:       file,
+		gzipWriter: gzipWriter,
+		encoder:    json.NewEncoder(gzipWriter),
+	}, nil
+}
+
+// 写入一条消息
+func (rec *Recorder) Write(record *Record) error {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if rec.encoder == nil {
+		return os.ErrClosed
+	}
+	return rec.encoder.Encode(record)
+}
+
+func (rec *Recorder) write(record *Record) {
+	err := rec.Write(record)
+	if err != nil {
+		// 只打印第一次的错误，避免刷屏
+		if atomic.AddInt64(&rec.errorCount, 1) == 1 {
+			logger.Error("record message error, message is %s", err.Error())
+		}
+	}
+}
+
+// 包装价格回调，先录制再交给handler处理
+func (rec *Recorder) WrapPriceHandler(stream string, handler client.PriceProcessHandler) client.PriceProcessHandler {
+	return func(resp *client.PriceWSResponse) {
+		rec.write(&Record{Time: common.GetTimestampInMS(), Stream: stream, Price: resp})
+		handler(resp)
+	}
+}
+
+// 包装订单回调，先录制再交给handler处理
+func (rec *Recorder) WrapOrderHandler(stream string, handler client.OrderProcessHandler) client.OrderProcessHandler {
+	return func(resp *client.OrderWSResponse) {
+		rec.write(&Record{Time: common.GetTimestampInMS(), Stream: stream, Order: resp})
+		handler(resp)
+	}
+}
+
+// 把缓存中的数据写入文件，进程异常退出时最多丢失一个刷新周期的数据
+func (rec *Recorder) Flush() {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if rec.gzipWriter == nil {
+		return
+	}
+	err := rec.gzipWriter.Flush()
+	if err != nil {
+		logger.Error("flush recorder error, message is %s", err.Error())
+	}
+}
+
+func (rec *Recorder) Close() error {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if rec.gzipWriter == nil {
+		return nil
+	}
+	err := rec.gzipWriter.Close()
+	if closeErr := rec.file.Close(); err == nil {
+		err = closeErr
+	}
+	rec.gzipWriter = nil
+	rec.encoder = nil
+	return err
+}
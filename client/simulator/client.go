@@ -0,0 +1,237 @@
+package simulator
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+
+	"github.com/shopspring/decimal"
+)
+
+// 模拟交易所的下单客户端，实现client.OrderClient
+type OrderClient struct {
+	Name     string
+	exchange *Exchange
+}
+
+func NewOrderClient(exchange *Exchange) *OrderClient {
+	return &OrderClient{exchange: exchange}
+}
+
+func (cli *OrderClient) Init(config client.Config) bool {
+	cli.Name = "Simulated" + cli.exchange.Product
+	return true
+}
+
+func (cli *OrderClient) PlaceOrderGTX(order *common.Order) (string, error) {
+	orderID, err := cli.exchange.PlaceLimitOrder(order, true)
+	if err != nil {
+		err = toOrderError(err)
+		logger.Error("%s place order error, side=%s, price=%f, amount=%f, symbol=%s, message is %s",
+			cli.Name, order.OrderType, order.OrderPrice, order.OrderVolume, order.Symbol, err.Error())
+		return "", err
+	}
+	return orderID, nil
+}
+
+// 和币安币本位一致，每次最多5个订单
+func (cli *OrderClient) MaxBatchOrders() int {
+	return 5
+}
+
+func (cli *OrderClient) PlaceBatchOrdersGTX(orders []*common.Order) []client.BatchOrderResult {
+	results := make([]client.BatchOrderResult, len(orders))
+	for i, order := range orders {
+		results[i].OrderID, results[i].Err = cli.PlaceOrderGTX(order)
+	}
+	return results
+}
+
+func (cli *OrderClient) AmendOrder(order *common.Order, price float64, volume float64) (float64, float64, error) {
+	amendedPrice, err := cli.exchange.AmendOrder(order.Symbol, order.ClientOrderID, price, volume)
+	if err != nil {
+		err = toOrderError(err)
+		logger.Error("%s amend order error, side=%s, price=%f, amount=%f, symbol=%s, message is %s",
+			cli.Name, order.OrderType, price, volume, order.Symbol, err.Error())
+		return 0, 0, err
+	}
+	return amendedPrice, volume, nil
+}
+
+func (cli *OrderClient) PlaceMarketOrder(order *common.Order) (string, error) {
+	orderID, err := cli.exchange.PlaceMarketOrder(order)
+	if err != nil {
+		err = toOrderError(err)
+		logger.Error("%s place market order error, side=%s, amount=%f, symbol=%s, message is %s",
+			cli.Name, order.OrderType, order.OrderVolume, order.Symbol, err.Error())
+		return "", err
+	}
+	return orderID, nil
+}
+
+func (cli *OrderClient) PlaceLimitOrder(order *common.Order) (string, error) {
+	orderID, err := cli.exchange.PlaceLimitOrder(order, false)
+	if err != nil {
+		err = toOrderError(err)
+		logger.Error("%s place limit order error, side=%s, price=%f, amount=%f, symbol=%s, message is %s",
+			cli.Name, order.OrderType, order.OrderPrice, order.OrderVolume, order.Symbol, err.Error())
+		return "", err
+	}
+	return orderID, nil
+}
+
+func (cli *OrderClient) CancelOrder(order *common.Order) (float64, error) {
+	filled, err := cli.exchange.CancelOrder(order.Symbol, order.ClientOrderID)
+	return filled, toOrderError(err)
+}
+
+func (cli *OrderClient) CancelOrdersByClientID(clientOrderIDs *[]string, symbol string) ([]string, error) {
+	var canceledIds []string
+	var lastErr error
+	for _, clientOrderID := range *clientOrderIDs {
+		_, err := cli.exchange.CancelOrder(symbol, clientOrderID)
+		if err != nil {
+			lastErr = toOrderError(err)
+			continue
+		}
+		canceledIds = append(canceledIds, clientOrderID)
+	}
+	return canceledIds, lastErr
+}
+
+func (cli *OrderClient) CancelAllOrders(symbol string) error {
+	err := cli.exchange.CancelAllOrders(symbol)
+	if err != nil {
+		err = toOrderError(err)
+		logger.Error("%s cancel all orders error, symbol=%s, message is %s", cli.Name, symbol, err.Error())
+		return err
+	}
+	return nil
+}
+
+func (cli *OrderClient) GetOpenOrders(symbol string) ([]*common.Order, error) {
+	orders, err := cli.exchange.OpenOrders(symbol)
+	return orders, toOrderError(err)
+}
+
+func (cli *OrderClient) GetAccount() (*client.Account, error) {
+	account := new(client.Account)
+	for asset, amount := range cli.exchange.Balances() {
+		balance := client.AssetBalance{Asset: asset, Free: amount}
+		if cli.exchange.Product != "spot" {
+			balance.MarginBalance = amount
+		}
+		account.Assets = append(account.Assets, balance)
+	}
+	account.Positions, _ = cli.GetPositions()
+	return account, nil
+}
+
+func (cli *OrderClient) GetPositions() ([]client.Position, error) {
+	var positions []client.Position
+	for symbol, amount := range cli.exchange.Positions() {
+		positions = append(positions, client.Position{Symbol: symbol, PositionAmt: amount})
+	}
+	return positions, nil
+}
+
+func (cli *OrderClient) GetDepth(symbol string) (*client.Depth, error) {
+	bids, asks, updateID, err := cli.exchange.Depth(symbol, 20)
+	if err != nil {
+		return nil, toOrderError(err)
+	}
+	return &client.Depth{
+		Symbol:       symbol,
+		LastUpdateID: updateID,
+		Bids:         toDepthItems(bids, 0, updateID),
+		Asks:         toDepthItems(asks, 0, updateID),
+	}, nil
+}
+
+// 模拟交易所的websocket客户端，实现client.WSClient
+// 价格和订单消息由模拟交易所直接回调，不需要真正建立连接
+type WSClient struct {
+	exchange       *Exchange
+	symbols        []string
+	priceWSHandler client.PriceProcessHandler
+	orderWSHandler client.OrderProcessHandler
+	errorHandler   client.ErrorHandler
+}
+
+func NewWSClient(exchange *Exchange) *WSClient {
+	return &WSClient{exchange: exchange}
+}
+
+func (cli *WSClient) Init(config client.Config) bool {
+	cli.symbols = config.Symbols
+	return true
+}
+
+func (cli *WSClient) SetPriceHandler(handler client.PriceProcessHandler, errHandler client.ErrorHandler) {
+	cli.priceWSHandler = handler
+	cli.errorHandler = errHandler
+}
+
+func (cli *WSClient) SetOrderHandler(handler client.OrderProcessHandler) {
+	cli.orderWSHandler = handler
+}
+
+func (cli *WSClient) StartWS() bool {
+	if cli.priceWSHandler != nil {
+		cli.exchange.SetPriceHandler(cli.priceMsgHandler)
+	}
+	if cli.orderWSHandler != nil {
+		cli.exchange.SetOrderHandler(cli.orderWSHandler)
+	}
+	return true
+}
+
+func (cli *WSClient) StopWS() bool {
+	cli.exchange.SetPriceHandler(nil)
+	cli.exchange.SetOrderHandler(nil)
+	return true
+}
+
+// 只推送订阅了的交易对
+func (cli *WSClient) priceMsgHandler(resp *client.PriceWSResponse) {
+	if resp.Symbol == "" || !common.InArray(resp.Symbol, cli.symbols) {
+		return
+	}
+	cli.priceWSHandler(resp)
+}
+
+// 模拟交易所的错误转换成和币安一致的 OrderError
+func toOrderError(err error) error {
+	if err == nil {
+		return nil
+	}
+	kind := client.ErrorKindOther
+	switch err {
+	case ErrUnknownSymbol:
+		kind = client.ErrorKindUnknownSymbol
+	case ErrWouldMatch:
+		kind = client.ErrorKindPostOnlyRejected
+	case ErrUnknownOrderType, ErrInvalidVolume, ErrNoLiquidity:
+		kind = client.ErrorKindInvalidOrder
+	case ErrInsufficientBalance:
+		kind = client.ErrorKindInsufficientMargin
+	case ErrOrderNotFound:
+		kind = client.ErrorKindOrderNotFound
+	}
+	return client.NewOrderError(kind, err)
+}
+
+func toDepthItems(levels []Level, limit int, updateID int64) []client.DepthPriceItem {
+	if limit <= 0 || limit > len(levels) {
+		limit = len(levels)
+	}
+	items := make([]client.DepthPriceItem, 0, limit)
+	for _, level := range levels[:limit] {
+		items = append(items, client.DepthPriceItem{
+			Price:        decimal.NewFromFloat(level.Price),
+			Volume:       decimal.NewFromFloat(level.Volume),
+			LastUpdateID: updateID,
+		})
+	}
+	return items
+}
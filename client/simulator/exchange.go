@@ -0,0 +1,753 @@
+// 模拟交易所，用于离线运行和测试
+package simulator
+
+import (
+	"cex/client"
+	"cex/common"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownSymbol       = errors.New("unknown symbol")
+	ErrUnknownOrderType    = errors.New("unknown order type")
+	ErrInvalidVolume       = errors.New("invalid volume")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrNoLiquidity         = errors.New("no liquidity")
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrWouldMatch          = errors.New("order would immediately match")
+)
+
+// 模拟交易所的深度档位
+type Level struct {
+	Price  float64
+	Volume float64
+}
+
+// 单个交易对的订单簿
+type book struct {
+	bids       []Level         // 市场深度，价格从高到低
+	asks       []Level         // 市场深度，价格从低到高
+	buyOrders  []*simOrder     // 本地挂单，按照下单时间排序
+	sellOrders []*simOrder     // 本地挂单，按照下单时间排序
+	updateID   int64           // 深度更新id
+	depthLevel int             // 推送depth时的档位数量
+	lastTicker [4]float64      // 上一次推送的bookTicker，只有变化才推送
+	orders     map[string]bool // 当前挂单的ClientOrderID
+	tickSize   float64         // 价格精度，限价单价格按此取整，0表示不取整
+}
+
+type simOrder struct {
+	order     common.Order
+	filled    float64 // 已成交数量
+	updatedAt int64
+
+	// 排队位置，只有开启QueuePosition时使用
+	queueKnown  bool    // 是否已经知道排队位置，挂单价格不在可见深度内时未知
+	queueAhead  float64 // 排在前面的数量
+	levelVolume float64 // 上一次看到的挂单价格上的深度
+}
+
+func (o *simOrder) remain() float64 {
+	return o.order.OrderVolume - o.filled
+}
+
+// 模拟交易所回调的事件，在释放锁之后再分发，避免回调中再调用交易所导致死锁
+type event struct {
+	price *client.PriceWSResponse
+	order *client.OrderWSResponse
+}
+
+// 模拟交易所，一个实例对应一个产品（币本位、U本位或者现货）
+// 挂单按照价格穿越成交：盘口的卖价小于等于买单价格时买单成交，反之亦然
+// 开启QueuePosition后，挂单价格上的深度减少时，按照排队位置成交
+type Exchange struct {
+	Name          string       // 交易所名称，回调消息中的Exchange字段，需要和配置中的Exchange一致
+	Product       string       // delivery | futures | spot，回调消息MsgType的前缀
+	QuoteAsset    string       // 计价资产，现货下单时用来转换交易对，e.g. BUSD
+	Clock         func() int64 // 时间戳（ms），回放或回测时可以替换成模拟时钟
+	QueuePosition bool         // 是否按照排队位置撮合，回测时使用
+
+	mutex     sync.Mutex
+	books     map[string]*book
+	orders    map[string]*simOrder // ClientOrderID => order
+	positions map[string]float64   // 合约持仓，单位：张，多正空负
+	balances  map[string]float64   // 资产余额
+	orderID   int64
+
+	priceHandler client.PriceProcessHandler
+	orderHandler client.OrderProcessHandler
+}
+
+func NewExchange(name string, product string, quoteAsset string) *Exchange {
+	return &Exchange{
+		Name:       name,
+		Product:    product,
+		QuoteAsset: quoteAsset,
+		Clock:      common.GetTimestampInMS,
+		books:      map[string]*book{},
+		orders:     map[string]*simOrder{},
+		positions:  map[string]float64{},
+		balances:   map[string]float64{},
+	}
+}
+
+func (ex *Exchange) SetPriceHandler(handler client.PriceProcessHandler) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	ex.priceHandler = handler
+}
+
+func (ex *Exchange) SetOrderHandler(handler client.OrderProcessHandler) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	ex.orderHandler = handler
+}
+
+// 设置资产余额
+func (ex *Exchange) SetBalance(asset string, amount float64) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	ex.balances[asset] = amount
+}
+
+func (ex *Exchange) Balance(asset string) float64 {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	return ex.balances[asset]
+}
+
+func (ex *Exchange) Balances() map[string]float64 {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	balances := make(map[string]float64, len(ex.balances))
+	for asset, amount := range ex.balances {
+		balances[asset] = amount
+	}
+	return balances
+}
+
+// 设置合约持仓，单位：张
+func (ex *Exchange) SetPosition(symbol string, position float64) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	ex.positions[symbol] = position
+}
+
+func (ex *Exchange) Position(symbol string) float64 {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	return ex.positions[symbol]
+}
+
+func (ex *Exchange) Positions() map[string]float64 {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	positions := make(map[string]float64, len(ex.positions))
+	for symbol, position := range ex.positions {
+		positions[symbol] = position
+	}
+	return positions
+}
+
+// 将下单用的交易对转换成本交易所的交易对
+// 对冲时传入的是币本位的交易对（如BTCUSD_PERP），需要转换成现货或U本位的交易对（如BTCBUSD）
+func (ex *Exchange) formatSymbol(symbol string, quoteAsset string) string {
+	if !strings.Contains(symbol, "_") || ex.Product == "delivery" {
+		return symbol
+	}
+	if quoteAsset == "" {
+		quoteAsset = ex.QuoteAsset
+	}
+	if ex.Product == "futures" {
+		return common.FormatFuturesSymbol(symbol, quoteAsset)
+	}
+	return common.FormatSpotSymbol(symbol, quoteAsset)
+}
+
+// 设置交易对的价格精度，e.g. BTCUSD_PERP => 0.1
+func (ex *Exchange) SetTickSize(symbol string, tickSize float64) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	ex.getBook(symbol).tickSize = tickSize
+}
+
+func (ex *Exchange) getBook(symbol string) *book {
+	b, ok := ex.books[symbol]
+	if !ok {
+		b = &book{depthLevel: 20, orders: map[string]bool{}}
+		ex.books[symbol] = b
+	}
+	return b
+}
+
+// 更新市场深度，并撮合被价格穿越的挂单
+// bids 价格从高到低，asks 价格从低到高，不符合的会重新排序
+func (ex *Exchange) UpdateBook(symbol string, bids []Level, asks []Level) {
+	ex.mutex.Lock()
+	b := ex.getBook(symbol)
+	b.bids = append(b.bids[:0], bids...)
+	b.asks = append(b.asks[:0], asks...)
+	sort.Slice(b.bids, func(i, j int) bool { return b.bids[i].Price > b.bids[j].Price })
+	sort.Slice(b.asks, func(i, j int) bool { return b.asks[i].Price < b.asks[j].Price })
+	b.updateID++
+
+	var events []event
+	events = append(events, ex.matchRestingOrders(symbol, b)...)
+	if ex.QueuePosition {
+		events = append(events, ex.matchQueue(b)...)
+	}
+	events = append(events, ex.priceEvents(symbol, b)...)
+	ex.mutex.Unlock()
+
+	ex.dispatch(events)
+}
+
+// 获取盘口价格
+func (ex *Exchange) BookTicker(symbol string) (bid Level, ask Level, ok bool) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	b, exist := ex.books[symbol]
+	if !exist || len(b.bids) == 0 || len(b.asks) == 0 {
+		return bid, ask, false
+	}
+	return b.bids[0], b.asks[0], true
+}
+
+// 获取深度
+func (ex *Exchange) Depth(symbol string, limit int) (bids []Level, asks []Level, updateID int64, err error) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	b, ok := ex.books[symbol]
+	if !ok {
+		return nil, nil, 0, ErrUnknownSymbol
+	}
+	bids = copyLevels(b.bids, limit)
+	asks = copyLevels(b.asks, limit)
+	return bids, asks, b.updateID, nil
+}
+
+// 限价单，postOnly为true时，如果会立即成交则直接过期（和币安GTX一致）
+func (ex *Exchange) PlaceLimitOrder(order *common.Order, postOnly bool) (string, error) {
+	if order.OrderVolume <= 0 {
+		return "", ErrInvalidVolume
+	}
+	if order.OrderType != "buy" && order.OrderType != "sell" {
+		return "", ErrUnknownOrderType
+	}
+
+	ex.mutex.Lock()
+	symbol := ex.formatSymbol(order.Symbol, order.QuoteAsset)
+	b, ok := ex.books[symbol]
+	if !ok {
+		ex.mutex.Unlock()
+		return "", ErrUnknownSymbol
+	}
+	if err := ex.checkBalance(order, order.OrderPrice); err != nil {
+		ex.mutex.Unlock()
+		return "", err
+	}
+
+	o := ex.newOrder(order, symbol)
+	if b.tickSize > 0 {
+		o.order.OrderPrice = math.Round(o.order.OrderPrice/b.tickSize) * b.tickSize
+	}
+	events := []event{ex.orderEvent(o, "NEW", o.order.OrderPrice, o.order.OrderVolume)}
+
+	crossed := (order.OrderType == "buy" && len(b.asks) > 0 && order.OrderPrice >= b.asks[0].Price) ||
+		(order.OrderType == "sell" && len(b.bids) > 0 && order.OrderPrice <= b.bids[0].Price)
+	if crossed && postOnly {
+		// post only会立即成交，直接过期
+		events = append(events, ex.orderEvent(o, "EXPIRED", o.order.OrderPrice, o.order.OrderVolume))
+		ex.mutex.Unlock()
+		ex.dispatch(events)
+		return o.order.OrderID, nil
+	}
+
+	if crossed {
+		// 吃单部分按照对手盘成交
+		events = append(events, ex.takeLiquidity(o, b, o.order.OrderPrice)...)
+	}
+	if o.remain() > 0 {
+		if order.OrderType == "buy" {
+			b.buyOrders = append(b.buyOrders, o)
+			ex.updateQueue(b, o, b.bids)
+		} else {
+			b.sellOrders = append(b.sellOrders, o)
+			ex.updateQueue(b, o, b.asks)
+		}
+		b.orders[o.order.ClientOrderID] = true
+	}
+	ex.mutex.Unlock()
+
+	ex.dispatch(events)
+	return o.order.OrderID, nil
+}
+
+// 市价单，按照对手盘深度逐档成交，深度不够的部分过期
+func (ex *Exchange) PlaceMarketOrder(order *common.Order) (string, error) {
+	if order.OrderVolume <= 0 {
+		return "", ErrInvalidVolume
+	}
+	if order.OrderType != "buy" && order.OrderType != "sell" {
+		return "", ErrUnknownOrderType
+	}
+
+	ex.mutex.Lock()
+	symbol := ex.formatSymbol(order.Symbol, order.QuoteAsset)
+	b, ok := ex.books[symbol]
+	if !ok {
+		ex.mutex.Unlock()
+		return "", ErrUnknownSymbol
+	}
+	levels := b.asks
+	if order.OrderType == "sell" {
+		levels = b.bids
+	}
+	if len(levels) == 0 {
+		ex.mutex.Unlock()
+		return "", ErrNoLiquidity
+	}
+	if err := ex.checkBalance(order, levels[0].Price); err != nil {
+		ex.mutex.Unlock()
+		return "", err
+	}
+
+	o := ex.newOrder(order, symbol)
+	events := []event{ex.orderEvent(o, "NEW", 0, o.order.OrderVolume)}
+	limitPrice := math.Inf(1)
+	if order.OrderType == "sell" {
+		limitPrice = 0
+	}
+	events = append(events, ex.takeLiquidity(o, b, limitPrice)...)
+	if o.remain() > 0 {
+		events = append(events, ex.orderEvent(o, "EXPIRED", 0, o.remain()))
+	}
+	delete(ex.orders, o.order.ClientOrderID)
+	ex.mutex.Unlock()
+
+	ex.dispatch(events)
+	return o.order.OrderID, nil
+}
+
+// 根据ClientOrderID取消订单，返回撤单前已经成交的数量
+func (ex *Exchange) CancelOrder(symbol string, clientOrderID string) (float64, error) {
+	ex.mutex.Lock()
+	symbol = ex.formatSymbol(symbol, "")
+	b, ok := ex.books[symbol]
+	if !ok || !b.orders[clientOrderID] {
+		ex.mutex.Unlock()
+		return 0, ErrOrderNotFound
+	}
+	o := ex.orders[clientOrderID]
+	ex.removeOrder(b, o)
+	events := []event{ex.orderEvent(o, "CANCELED", o.order.OrderPrice, o.remain())}
+	filled := o.filled
+	ex.mutex.Unlock()
+
+	ex.dispatch(events)
+	return filled, nil
+}
+
+// 修改挂单的价格和数量，和币安一致：新价格会立即成交时拒绝修改，价格变化后重新排队
+// 返回按 tickSize 取整后的价格
+func (ex *Exchange) AmendOrder(symbol string, clientOrderID string, price float64, volume float64) (float64, error) {
+	ex.mutex.Lock()
+	symbol = ex.formatSymbol(symbol, "")
+	b, ok := ex.books[symbol]
+	if !ok || !b.orders[clientOrderID] {
+		ex.mutex.Unlock()
+		return 0, ErrOrderNotFound
+	}
+	o := ex.orders[clientOrderID]
+	if volume <= o.filled {
+		ex.mutex.Unlock()
+		return 0, ErrInvalidVolume
+	}
+	if b.tickSize > 0 {
+		price = math.Round(price/b.tickSize) * b.tickSize
+	}
+	if (o.order.OrderType == "buy" && len(b.asks) > 0 && price >= b.asks[0].Price) ||
+		(o.order.OrderType == "sell" && len(b.bids) > 0 && price <= b.bids[0].Price) {
+		ex.mutex.Unlock()
+		return 0, ErrWouldMatch
+	}
+
+	if price != o.order.OrderPrice {
+		o.order.OrderPrice = price
+		o.queueKnown, o.queueAhead, o.levelVolume = false, 0, 0
+	}
+	o.order.OrderVolume = volume
+	o.updatedAt = ex.Clock()
+	events := []event{ex.orderEvent(o, "NEW", o.order.OrderPrice, o.order.OrderVolume)}
+	if o.order.OrderType == "buy" {
+		events = append(events, ex.updateQueue(b, o, b.bids)...)
+	} else {
+		events = append(events, ex.updateQueue(b, o, b.asks)...)
+	}
+	ex.mutex.Unlock()
+
+	ex.dispatch(events)
+	return price, nil
+}
+
+// 取消交易对的所有挂单
+func (ex *Exchange) CancelAllOrders(symbol string) error {
+	ex.mutex.Lock()
+	symbol = ex.formatSymbol(symbol, "")
+	b, ok := ex.books[symbol]
+	if !ok {
+		ex.mutex.Unlock()
+		return ErrUnknownSymbol
+	}
+	var events []event
+	for _, orders := range [][]*simOrder{b.buyOrders, b.sellOrders} {
+		for _, o := range orders {
+			delete(ex.orders, o.order.ClientOrderID)
+			events = append(events, ex.orderEvent(o, "CANCELED", o.order.OrderPrice, o.remain()))
+		}
+	}
+	b.buyOrders = nil
+	b.sellOrders = nil
+	b.orders = map[string]bool{}
+	ex.mutex.Unlock()
+
+	ex.dispatch(events)
+	return nil
+}
+
+// 查询当前挂单
+func (ex *Exchange) OpenOrders(symbol string) ([]*common.Order, error) {
+	ex.mutex.Lock()
+	defer ex.mutex.Unlock()
+	symbol = ex.formatSymbol(symbol, "")
+	b, ok := ex.books[symbol]
+	if !ok {
+		return nil, ErrUnknownSymbol
+	}
+	var orders []*common.Order
+	for _, lst := range [][]*simOrder{b.buyOrders, b.sellOrders} {
+		for _, o := range lst {
+			order := o.order
+			order.OrderVolume = o.remain()
+			order.Status = common.CREATED
+			if o.filled > 0 {
+				order.Status = common.PARTIALLYFILLED
+			}
+			orders = append(orders, &order)
+		}
+	}
+	return orders, nil
+}
+
+func (ex *Exchange) newOrder(order *common.Order, symbol string) *simOrder {
+	ex.orderID++
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = common.GetClientOrderID()
+	}
+	o := &simOrder{order: *order, updatedAt: ex.Clock()}
+	o.order.Exchange = ex.Name
+	o.order.Symbol = symbol
+	o.order.OrderID = strconv.FormatInt(ex.orderID, 10)
+	o.order.CreateAt = ex.Clock() / 1000
+	ex.orders[o.order.ClientOrderID] = o
+	return o
+}
+
+func (ex *Exchange) removeOrder(b *book, o *simOrder) {
+	delete(ex.orders, o.order.ClientOrderID)
+	delete(b.orders, o.order.ClientOrderID)
+	lst := &b.buyOrders
+	if o.order.OrderType == "sell" {
+		lst = &b.sellOrders
+	}
+	for i, item := range *lst {
+		if item == o {
+			*lst = append((*lst)[:i], (*lst)[i+1:]...)
+			break
+		}
+	}
+}
+
+// 现货需要检查余额是否足够，合约不检查保证金
+func (ex *Exchange) checkBalance(order *common.Order, price float64) error {
+	if ex.Product != "spot" || order.BaseAsset == "" {
+		return nil
+	}
+	quoteAsset := order.QuoteAsset
+	if quoteAsset == "" {
+		quoteAsset = ex.QuoteAsset
+	}
+	if order.OrderType == "sell" && ex.balances[order.BaseAsset] < order.OrderVolume {
+		return ErrInsufficientBalance
+	}
+	if order.OrderType == "buy" && ex.balances[quoteAsset] < order.OrderVolume*price {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// 吃掉对手盘的深度，limitPrice是能接受的最差价格
+func (ex *Exchange) takeLiquidity(o *simOrder, b *book, limitPrice float64) []event {
+	var events []event
+	levels := &b.asks
+	if o.order.OrderType == "sell" {
+		levels = &b.bids
+	}
+	for len(*levels) > 0 && o.remain() > 0 {
+		level := &(*levels)[0]
+		if (o.order.OrderType == "buy" && level.Price > limitPrice) ||
+			(o.order.OrderType == "sell" && level.Price < limitPrice) {
+			break
+		}
+		volume := math.Min(level.Volume, o.remain())
+		level.Volume -= volume
+		if level.Volume <= 0 {
+			*levels = (*levels)[1:]
+		}
+		events = append(events, ex.fill(o, level.Price, volume)...)
+	}
+	return events
+}
+
+// 挂单被价格穿越时成交，成交价格为挂单价格
+func (ex *Exchange) matchRestingOrders(symbol string, b *book) []event {
+	var events []event
+	if len(b.asks) > 0 {
+		available := 0.0
+		for _, level := range b.asks {
+			available += level.Volume
+		}
+		for _, o := range append([]*simOrder{}, b.buyOrders...) {
+			if o.order.OrderPrice < b.asks[0].Price || available <= 0 {
+				continue
+			}
+			volume := math.Min(o.remain(), availableVolume(b.asks, o.order.OrderPrice, "buy"))
+			volume = math.Min(volume, available)
+			if volume <= 0 {
+				continue
+			}
+			available -= volume
+			events = append(events, ex.fill(o, o.order.OrderPrice, volume)...)
+			if o.remain() <= 0 {
+				ex.removeOrder(b, o)
+			}
+		}
+	}
+	if len(b.bids) > 0 {
+		available := 0.0
+		for _, level := range b.bids {
+			available += level.Volume
+		}
+		for _, o := range append([]*simOrder{}, b.sellOrders...) {
+			if o.order.OrderPrice > b.bids[0].Price || available <= 0 {
+				continue
+			}
+			volume := math.Min(o.remain(), availableVolume(b.bids, o.order.OrderPrice, "sell"))
+			volume = math.Min(volume, available)
+			if volume <= 0 {
+				continue
+			}
+			available -= volume
+			events = append(events, ex.fill(o, o.order.OrderPrice, volume)...)
+			if o.remain() <= 0 {
+				ex.removeOrder(b, o)
+			}
+		}
+	}
+	return events
+}
+
+// 按照排队位置成交，被价格穿越的挂单已经在matchRestingOrders中处理
+func (ex *Exchange) matchQueue(b *book) []event {
+	var events []event
+	for _, o := range append([]*simOrder{}, b.buyOrders...) {
+		if len(b.asks) > 0 && o.order.OrderPrice >= b.asks[0].Price {
+			continue
+		}
+		events = append(events, ex.updateQueue(b, o, b.bids)...)
+	}
+	for _, o := range append([]*simOrder{}, b.sellOrders...) {
+		if len(b.bids) > 0 && o.order.OrderPrice <= b.bids[0].Price {
+			continue
+		}
+		events = append(events, ex.updateQueue(b, o, b.asks)...)
+	}
+	return events
+}
+
+// 根据同方向的深度更新排队位置，levels是挂单同方向的深度
+// 挂单价格上的深度减少时，认为是排在前面的订单成交了，排到自己之后开始成交；深度增加的部分排在自己后面
+func (ex *Exchange) updateQueue(b *book, o *simOrder, levels []Level) []event {
+	if !ex.QueuePosition || len(levels) == 0 {
+		return nil
+	}
+	price := o.order.OrderPrice
+	eps := b.tickSize / 2
+	if eps <= 0 {
+		eps = price * 1e-9
+	}
+	volume, visible := 0.0, false
+	for _, level := range levels {
+		if math.Abs(level.Price-price) < eps {
+			volume, visible = level.Volume, true
+			break
+		}
+	}
+	last := levels[len(levels)-1].Price
+	if !visible {
+		// 挂单价格在可见深度内但没有深度，说明这个价格上没有其他挂单，排在第一个
+		if (o.order.OrderType == "buy" && price > last) || (o.order.OrderType == "sell" && price < last) {
+			o.queueKnown, o.queueAhead, o.levelVolume = true, 0, 0
+		}
+		return nil
+	}
+	if !o.queueKnown {
+		// 第一次看到挂单价格上的深度，保守地认为这些深度都排在前面
+		o.queueKnown, o.queueAhead, o.levelVolume = true, volume, volume
+		return nil
+	}
+
+	consumed := o.levelVolume - volume
+	o.levelVolume = volume
+	if consumed <= 0 {
+		return nil
+	}
+	o.queueAhead -= consumed
+	if o.queueAhead >= 0 {
+		return nil
+	}
+	fillVolume := math.Min(-o.queueAhead, o.remain())
+	o.queueAhead = 0
+	events := ex.fill(o, price, fillVolume)
+	if o.remain() <= 0 {
+		ex.removeOrder(b, o)
+	}
+	return events
+}
+
+// 对手盘中价格优于挂单价格的总量
+func availableVolume(levels []Level, price float64, orderType string) float64 {
+	volume := 0.0
+	for _, level := range levels {
+		if (orderType == "buy" && level.Price > price) || (orderType == "sell" && level.Price < price) {
+			break
+		}
+		volume += level.Volume
+	}
+	return volume
+}
+
+// 成交，更新持仓和余额，并生成订单和账户变动消息
+func (ex *Exchange) fill(o *simOrder, price float64, volume float64) []event {
+	o.filled += volume
+	o.updatedAt = ex.Clock()
+	status := "PARTIALLY_FILLED"
+	if o.remain() <= 1e-12 {
+		o.filled = o.order.OrderVolume
+		status = "FILLED"
+	}
+
+	signedVolume := volume
+	if o.order.OrderType == "sell" {
+		signedVolume = -volume
+	}
+	events := []event{ex.orderEvent(o, status, price, volume)}
+	if ex.Product == "spot" {
+		if o.order.BaseAsset != "" {
+			quoteAsset := o.order.QuoteAsset
+			if quoteAsset == "" {
+				quoteAsset = ex.QuoteAsset
+			}
+			ex.balances[o.order.BaseAsset] += signedVolume
+			ex.balances[quoteAsset] -= signedVolume * price
+		}
+	} else {
+		symbol := o.order.Symbol
+		ex.positions[symbol] += signedVolume
+		var resp client.OrderWSResponse
+		resp.Exchange = ex.Name
+		resp.MsgType = "ACCOUNT_UPDATE"
+		resp.TimeStamp = ex.Clock()
+		resp.Status = "ORDER_UPDATE"
+		resp.Symbol = symbol
+		resp.Position = ex.positions[symbol]
+		resp.PositionAbs = math.Abs(resp.Position)
+		events = append(events, event{order: &resp})
+	}
+	return events
+}
+
+// 生成订单变动消息，和币安的ORDER_TRADE_UPDATE一致：成交时价格和数量是本次成交的价格和数量
+func (ex *Exchange) orderEvent(o *simOrder, status string, price float64, volume float64) event {
+	var resp client.OrderWSResponse
+	resp.Exchange = ex.Name
+	resp.MsgType = "ORDER_TRADE_UPDATE"
+	resp.TimeStamp = ex.Clock()
+	resp.Order = o.order
+	resp.Order.OrderPrice = price
+	resp.Order.OrderVolume = volume
+	resp.Status = status
+	resp.Symbol = o.order.Symbol
+	return event{order: &resp}
+}
+
+// 生成bookTicker和depth消息
+func (ex *Exchange) priceEvents(symbol string, b *book) []event {
+	var events []event
+	timeStamp := ex.Clock()
+	if len(b.bids) > 0 && len(b.asks) > 0 {
+		ticker := [4]float64{b.bids[0].Price, b.bids[0].Volume, b.asks[0].Price, b.asks[0].Volume}
+		if ticker != b.lastTicker {
+			b.lastTicker = ticker
+			var resp client.PriceWSResponse
+			resp.Exchange = ex.Name
+			resp.MsgType = ex.Product + "BookTicker"
+			resp.TimeStamp = timeStamp
+			resp.UpdateID = b.updateID
+			resp.Symbol = symbol
+			resp.Items = []client.PriceItem{
+				{Price: b.bids[0].Price, Volume: b.bids[0].Volume, Direction: "buy"},
+				{Price: b.asks[0].Price, Volume: b.asks[0].Volume, Direction: "sell"},
+			}
+			events = append(events, event{price: &resp})
+		}
+	}
+
+	var resp client.PriceWSResponse
+	resp.Exchange = ex.Name
+	resp.MsgType = ex.Product + "Depth"
+	resp.TimeStamp = timeStamp
+	resp.UpdateID = b.updateID
+	resp.Symbol = symbol
+	resp.Bids = toDepthItems(b.bids, b.depthLevel, b.updateID)
+	resp.Asks = toDepthItems(b.asks, b.depthLevel, b.updateID)
+	events = append(events, event{price: &resp})
+	return events
+}
+
+func (ex *Exchange) dispatch(events []event) {
+	ex.mutex.Lock()
+	priceHandler, orderHandler := ex.priceHandler, ex.orderHandler
+	ex.mutex.Unlock()
+
+	for _, e := range events {
+		if e.price != nil && priceHandler != nil {
+			priceHandler(e.price)
+		}
+		if e.order != nil && orderHandler != nil {
+			orderHandler(e.order)
+		}
+	}
+}
+
+func copyLevels(levels []Level, limit int) []Level {
+	if limit <= 0 || limit > len(levels) {
+		limit = len(levels)
+	}
+	return append([]Level{}, levels[:limit]...)
+}
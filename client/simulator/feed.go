@@ -0,0 +1,128 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type feedItem struct {
+	exchange *Exchange
+	symbol   string
+	basis    float64 // 相对中间价的溢价比例，e.g. 0.001 表示比中间价高千分之一
+}
+
+// 随机游走行情，同一个标的的多个产品（现货、U本位、币本位）共用一个中间价，保证价格联动
+type RandomWalkFeed struct {
+	Interval   time.Duration // 行情更新间隔
+	Volatility float64       // 每次更新对数收益率的标准差
+	Spread     float64       // 买卖价差比例
+	Levels     int           // 深度档位数量
+	Volume     float64       // 每档的数量
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	mids  map[string]float64 // 标的 => 中间价
+	items map[string][]feedItem
+	stopC chan struct{}
+}
+
+func NewRandomWalkFeed(seed int64) *RandomWalkFeed {
+	return &RandomWalkFeed{
+		Interval:   100 * time.Millisecond,
+		Volatility: 0.0002,
+		Spread:     0.0002,
+		Levels:     5,
+		Volume:     100,
+		rand:       rand.New(rand.NewSource(seed)),
+		mids:       map[string]float64{},
+		items:      map[string][]feedItem{},
+	}
+}
+
+// 添加一个标的的行情，underlying是标的名称（如BTCBUSD），price是初始中间价
+func (feed *RandomWalkFeed) AddUnderlying(underlying string, price float64) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	feed.mids[underlying] = price
+}
+
+// 将交易所的交易对挂到标的上，每次更新中间价都会同步更新该交易对的深度
+func (feed *RandomWalkFeed) AddSymbol(underlying string, exchange *Exchange, symbol string, basis float64) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	feed.items[underlying] = append(feed.items[underlying], feedItem{exchange: exchange, symbol: symbol, basis: basis})
+}
+
+// 走一步，更新所有交易对的深度
+func (feed *RandomWalkFeed) Step() {
+	type update struct {
+		item feedItem
+		bids []Level
+		asks []Level
+	}
+	var updates []update
+
+	feed.mutex.Lock()
+	for underlying, mid := range feed.mids {
+		mid *= math.Exp(feed.Volatility * feed.rand.NormFloat64())
+		feed.mids[underlying] = mid
+		for _, item := range feed.items[underlying] {
+			bids, asks := feed.levels(mid * (1 + item.basis))
+			updates = append(updates, update{item: item, bids: bids, asks: asks})
+		}
+	}
+	feed.mutex.Unlock()
+
+	for _, u := range updates {
+		u.item.exchange.UpdateBook(u.item.symbol, u.bids, u.asks)
+	}
+}
+
+func (feed *RandomWalkFeed) levels(mid float64) (bids []Level, asks []Level) {
+	halfSpread := mid * feed.Spread / 2
+	for i := 0; i < feed.Levels; i++ {
+		offset := halfSpread + float64(i)*2*halfSpread
+		volume := feed.Volume * (0.5 + feed.rand.Float64())
+		bids = append(bids, Level{Price: mid - offset, Volume: volume})
+		volume = feed.Volume * (0.5 + feed.rand.Float64())
+		asks = append(asks, Level{Price: mid + offset, Volume: volume})
+	}
+	return bids, asks
+}
+
+// 启动行情
+func (feed *RandomWalkFeed) Start() {
+	feed.mutex.Lock()
+	if feed.stopC != nil {
+		feed.mutex.Unlock()
+		return
+	}
+	stopC := make(chan struct{})
+	feed.stopC = stopC
+	feed.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(feed.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-ticker.C:
+				feed.Step()
+			}
+		}
+	}()
+}
+
+// 停止行情
+func (feed *RandomWalkFeed) Stop() {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if feed.stopC != nil {
+		close(feed.stopC)
+		feed.stopC = nil
+	}
+}
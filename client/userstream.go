@@ -0,0 +1,166 @@
+package client
+
+import (
+	"cex/common/logger"
+	"sync"
+	"time"
+)
+
+// listenKey 60分钟过期，每30分钟续期一次
+const listenKeyKeepAliveInterval = 30 * time.Minute
+
+// 用户数据流在 ConnectionManager 中的名称
+const userDataStream = "order"
+
+// 用 listenKey 建立用户数据流连接
+type UserStreamServeFunc func(listenKey string, errHandler func(err error)) (doneC, stopC chan struct{}, err error)
+
+// 用户数据流的 listenKey 生命周期管理
+// 定时续期，续期失败或者收到 listenKeyExpired 消息时，重新创建 listenKey 并重连
+// 断线重连之后回调 reconnectHandler，断线期间的订单消息已经丢失，需要通过 REST 核对订单和持仓
+type UserStreamManager struct {
+	name        string // 用于日志，e.g. Binance delivery
+	client      UserStreamClient
+	connections *ConnectionManager
+	serve       UserStreamServeFunc
+
+	mutex            sync.Mutex
+	listenKey        string
+	expired          bool // listenKey 已经失效，下次连接前重新创建
+	connected        bool // 是否连接成功过，之后的连接都是重连
+	reconnectHandler func()
+	stopC            chan struct{}
+}
+
+func NewUserStreamManager(name string, client UserStreamClient, connections *ConnectionManager, serve UserStreamServeFunc) *UserStreamManager {
+	return &UserStreamManager{
+		name:        name,
+		client:      client,
+		connections: connections,
+		serve:       serve,
+	}
+}
+
+// 设置重连之后的回调，需要在 Start 之前设置
+func (manager *UserStreamManager) SetReconnectHandler(handler func()) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.reconnectHandler = handler
+}
+
+// 创建 listenKey 并建立连接，开始定时续期
+func (manager *UserStreamManager) Start() bool {
+	listenKey, err := manager.client.GetListenKey()
+	if err != nil {
+		logger.Error("%s get listen key failed, message is %s", manager.name, err.Error())
+		return false
+	}
+	manager.mutex.Lock()
+	manager.listenKey = listenKey
+	manager.stopC = make(chan struct{})
+	stopC := manager.stopC
+	manager.mutex.Unlock()
+
+	manager.connections.Add(userDataStream, "", manager.connect)
+	go manager.keepAlive(stopC)
+	return true
+}
+
+// 停止续期，连接由 ConnectionManager 关闭
+func (manager *UserStreamManager) Stop() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	if manager.stopC != nil {
+		close(manager.stopC)
+		manager.stopC = nil
+	}
+}
+
+// listenKey 已经失效，重新创建并重连
+func (manager *UserStreamManager) Expire(reason string) {
+	manager.mutex.Lock()
+	if manager.expired {
+		manager.mutex.Unlock()
+		return
+	}
+	manager.expired = true
+	manager.mutex.Unlock()
+
+	logger.Warn("%s listen key expired, reason: %s, recreate and reconnect", manager.name, reason)
+	manager.connections.Reconnect(userDataStream, "")
+}
+
+// ConnectionManager 的连接函数，每次连接前确认 listenKey 有效
+func (manager *UserStreamManager) connect(errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	listenKey, err := manager.validListenKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	doneC, stopC, err = manager.serve(listenKey, errHandler)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manager.mutex.Lock()
+	reconnected := manager.connected
+	manager.connected = true
+	handler := manager.reconnectHandler
+	manager.mutex.Unlock()
+	if reconnected && handler != nil {
+		go handler()
+	}
+	return doneC, stopC, nil
+}
+
+// 重连时先续期，续期失败或者已经失效时重新创建 listenKey
+func (manager *UserStreamManager) validListenKey() (string, error) {
+	manager.mutex.Lock()
+	listenKey, expired, connected := manager.listenKey, manager.expired, manager.connected
+	manager.mutex.Unlock()
+
+	if !expired && connected {
+		err := manager.client.KeepAliveListenKey(listenKey)
+		if err != nil {
+			logger.Warn("%s keep alive listen key failed before reconnect, message is %s", manager.name, err.Error())
+			expired = true
+		}
+	}
+	if !expired {
+		return listenKey, nil
+	}
+
+	listenKey, err := manager.client.GetListenKey()
+	if err != nil {
+		logger.Error("%s recreate listen key failed, message is %s", manager.name, err.Error())
+		return "", err
+	}
+	logger.Warn("%s listen key recreated", manager.name)
+	manager.mutex.Lock()
+	manager.listenKey = listenKey
+	manager.expired = false
+	manager.mutex.Unlock()
+	return listenKey, nil
+}
+
+// 定时续期，失败时重新创建 listenKey
+func (manager *UserStreamManager) keepAlive(stopC chan struct{}) {
+	ticker := time.NewTicker(listenKeyKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopC:
+			return
+		case <-ticker.C:
+		}
+		manager.mutex.Lock()
+		listenKey, expired := manager.listenKey, manager.expired
+		manager.mutex.Unlock()
+		if expired {
+			continue
+		}
+		err := manager.client.KeepAliveListenKey(listenKey)
+		if err != nil {
+			manager.Expire("keep alive failed, " + err.Error())
+		}
+	}
+}
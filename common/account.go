@@ -27,6 +27,7 @@ type AccountInfo struct {
 	SwapType          string                       // swap 逐仓，swap_cross 全仓
 	Margin            float64                      // 总的金额
 	DeliveryPositions map[string]*DeliveryPosition // 持仓合约的具体数量
+	Equities          map[string]float64           // 币种 => 保证金余额，每次更新时整体替换
 	Tokens            []TokenInfo
 }
 
@@ -50,11 +50,13 @@ func (lst OrderList) Swap(i, j int)      { lst[i], lst[j] = lst[j], lst[i] }
 type OrderBook struct {
 	Data           OrderList
 	canceledOrders map[string]*Order // 已经取消的订单
+	amending       map[string]bool   // 正在修改的订单的ClientOrderID，修改结束前不再修改
 	Mutex          sync.RWMutex
 }
 
 func (orderBook *OrderBook) Init() {
 	orderBook.canceledOrders = make(map[string]*Order)
+	orderBook.amending = make(map[string]bool)
 }
 
 // 通过ClientOrderID删除对应的Order
@@ -98,6 +100,38 @@ func (orderBook *OrderBook) UpdateStatus(clientOrderID string, status int) *Orde
 	return nil
 }
 
+// 订单是否正在修改，调用时需要持有锁
+func (orderBook *OrderBook) IsAmending(clientOrderID string) bool {
+	return orderBook.amending[clientOrderID]
+}
+
+// 标记订单正在修改，调用时需要持有写锁
+func (orderBook *OrderBook) SetAmending(clientOrderID string) {
+	orderBook.amending[clientOrderID] = true
+}
+
+// 修改失败，清除正在修改的标记
+func (orderBook *OrderBook) FinishAmend(clientOrderID string) {
+	orderBook.Mutex.Lock()
+	defer orderBook.Mutex.Unlock()
+	delete(orderBook.amending, clientOrderID)
+}
+
+// 修改成功后更新order的价格和数量并清除正在修改的标记，订单已经不在orderbook中时返回nil
+func (orderBook *OrderBook) Amend(clientOrderID string, price float64, volume float64) *Order {
+	orderBook.Mutex.Lock()
+	defer orderBook.Mutex.Unlock()
+	delete(orderBook.amending, clientOrderID)
+	for i := 0; i < len(orderBook.Data); i++ {
+		if orderBook.Data[i].ClientOrderID == clientOrderID {
+			orderBook.Data[i].OrderPrice = price
+			orderBook.Data[i].OrderVolume = volume
+			return orderBook.Data[i]
+		}
+	}
+	return nil
+}
+
 func (orderBook *OrderBook) Size() int {
 	return len(orderBook.Data)
 }
@@ -59,11 +59,20 @@ func FormatSpotSymbol(deliverySymbol string, quoteAsset string) string {
 	return deliverySymbol
 }
 
-// 获取ms格式的时间戳
-func GetTimestampInMS() int64 {
+// 获取ms格式的时间戳，默认是系统时间，回测时替换成回放的时间
+var timestampFunc = func() int64 {
 	return time.Now().UnixNano() / 1e6
 }
 
+func GetTimestampInMS() int64 {
+	return timestampFunc()
+}
+
+// 替换获取时间戳的函数，回测时使用
+func SetTimestampFunc(f func() int64) {
+	timestampFunc = f
+}
+
 // 获取对冲OrderType
 func GetHedgeOrderType(orderType string) (result string) {
 	if strings.ToLower(orderType) == "sell" {
@@ -82,6 +91,23 @@ func GetClientOrderID() string {
 	return strconv.FormatInt(atomic.LoadInt64(&gClientOrderID), 10)
 }
 
+// 对冲订单的ClientOrderID，带上触发对冲的订单的ClientOrderID，e.g. 1666250000001_h1666250000002
+// 币安的ClientOrderID最长36位
+const hedgeClientOrderIDSep = "_h"
+
+func GetHedgeClientOrderID(originClientOrderID string) string {
+	return originClientOrderID + hedgeClientOrderIDSep + GetClientOrderID()
+}
+
+// 从对冲订单的ClientOrderID中解析触发对冲的订单的ClientOrderID，不是对冲订单时ok为false
+func ParseHedgeClientOrderID(clientOrderID string) (originClientOrderID string, ok bool) {
+	index := strings.LastIndex(clientOrderID, hedgeClientOrderIDSep)
+	if index < 0 {
+		return "", false
+	}
+	return clientOrderID[:index], true
+}
+
 func InArray(target string, strArray []string) bool {
 	for _, element := range strArray {
 		if target == element {
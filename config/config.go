@@ -15,9 +15,49 @@ type SymbolConfig struct {
 	Cont           int     // 每张多少 u，因为币本位是按照张算的，BTC一张100u，其他一张10u
 	Leverage       int     // 杠杆倍数，初始化时给交易对设置好
 	MaxContractNum int     // 最大可以开的张数， 也是用来限制单方向最大持仓数量的
-	MinHedgeSize   float64 // 最小对冲数量, 需要对冲时，如果不够这个量就不对冲。e.g. 币安限制BTC最小交易额度是0.001
+	MinHedgeSize   float64 // 最小对冲数量（Base Asset）, 累计需要对冲的数量不够这个量就先不对冲。e.g. 币安限制BTC最小交易额度是0.001
 	Precision      [2]int  // BTCBUSD => [4, 2] BTC的精度是4，USD的精度是2
 	EffectiveNum   float64 // 获取交易对报价时，quantity 需要大于这个值才认为有效（特别是从depth消息中获取价格时）
+	MaxDelta       float64 // 允许的净敞口（Base Asset），超过时报警，0表示不检查
+	RehedgeBand    float64 // 价格变化后持仓需要的对冲数量和已经对冲的数量相差超过这个值时调整对冲（Base Asset），0表示不调整
+	RiskAversion   float64 // QuoteModel 为 avellaneda_stoikov 时的风险厌恶系数，越大持仓对挂单价格的影响越大
+	OrderIntensity float64 // QuoteModel 为 avellaneda_stoikov 时成交概率随挂单距离（占价格的比例）衰减的系数，越大挂单越靠近盘口
+
+	// 挂单数量和持仓限制，USD 按 Cont 换算成张数
+	SizeMode            string  // 每单数量的计算方式：contracts 每单 ContractNum 张（默认），notional 每单 OrderNotional USD，equity 每单是账户权益的 OrderEquityPercent
+	OrderNotional       float64 // SizeMode 为 notional 时每单的价值（USD）
+	OrderEquityPercent  float64 // SizeMode 为 equity 时每单占币本位账户权益（BaseAsset 的保证金余额）的比例，e.g. 0.01
+	LevelSizeFactor     float64 // 每往外一档数量乘以这个系数，大于1越远越大，小于1越远越小，0或1每档一样
+	MaxPositionNotional float64 // 单方向最大持仓的价值（USD），0表示使用 MaxContractNum
+
+	HedgeVenue       string // 对冲的市场：spot 现货，futures U本位永续，auto 按盘口价格和手续费选择成本低的，默认spot
+	FuturesPrecision [2]int // U本位对冲时的精度，没有配置时使用Precision
+
+	// 挂单参数，含义和 Config 中的同名配置一样，没有配置时使用全局的值
+	MaxOrderNum     *int
+	MaxOrderOneStep *int
+	GapSizePercent  *float64
+	GapSizeK        *float64
+	SpreadTimes     *float64
+	ForgivePercent  *float64
+	TickerShift     *float64
+	Commission      *float64
+	Loss            *float64
+	CancelShift     *float64
+}
+
+// 交易对实际使用的挂单参数
+type QuoteParams struct {
+	MaxOrderNum     int
+	MaxOrderOneStep int
+	GapSizePercent  float64
+	GapSizeK        float64
+	SpreadTimes     float64
+	ForgivePercent  float64
+	TickerShift     float64
+	Commission      float64
+	Loss            float64
+	CancelShift     float64
 }
 
 type Config struct {
@@ -36,6 +76,16 @@ type Config struct {
 	// 频率控制
 	APILimit     int // API次数限制（1s）
 	LimitProcess int // 超过限制请求的处理方法，1等待，0是丢弃该次请求
+	// 币本位频率限制剩余额度的比例低于这个值时不再挂新单，把额度留给撤单和对冲，0表示不限制
+	MinRateLimitHeadroom float64
+
+	// 策略名称：delivery_maker 币本位做市（默认），calendar_spread 跨期价差，probe 测试下单和行情延迟
+	Strategy string
+
+	// 跨期价差配置，两个合约都需要在 Symbols 中
+	CalendarPairs      map[string]string // 挂单的合约 => 对冲的合约，e.g. BTCUSD_0930 => BTCUSD_PERP
+	CalendarSpread     float64           // 挂单价格相对公允价格的距离比例，公允价格 = 对冲合约的价格 * (1 + 基差)
+	CalendarBasisAlpha float64           // 基差指数移动平均的系数，0到1，越大越接近最新的基差
 
 	// 套利配置
 	Exchange      string                  // 交易所，在哪个交易所挂单， e.g. Binance
@@ -43,11 +93,18 @@ type Config struct {
 	Symbols       []string                // 要套利的交易对
 	SymbolConfigs map[string]SymbolConfig // 交易对的详细配置
 
+	// 挂单参数，以下5项和 ForgivePercent、TickerShift、Commission、Loss、CancelShift 可以在 SymbolConfig 中按交易对覆盖
 	MaxOrderNum     int     // 每个方向上最多挂单的数量
 	MaxOrderOneStep int     // 一次挂单，每个方向最多挂几单
 	GapSizePercent  float64 // 每单之间的默认间隔，e.g. 0.0002 就是间隔万分之二
 	GapSizeK        float64 // 订单之间的滑动系数，确保间隔越来越大
-	SpreadTimes     float64 // AdjustedGapSize = GapSizePercent * (1 + spread * SpreadTimes), 其中 spread = (max - min)/bidPrice
+	SpreadTimes     float64 // AdjustedGapSize = GapSizePercent * (1 + spread * SpreadTimes), 其中 spread 是 VolatilityEstimator 估计的波动
+
+	// 波动估计配置，spread 同时用于 AdjustedGapSize 和 AdjustedForgivePercent
+	VolatilityEstimator      string // range 窗口内 (max - min)/bidPrice（默认），ewma 对数收益率的指数移动平均，realized 窗口内的已实现波动
+	VolatilityWindow         int64  // 估计波动的窗口长度，单位：ms，默认300000，数据积累到窗口的1/10之后才开始调整
+	VolatilitySampleInterval int64  // 价格采样间隔，单位：ms，默认100
+	VolatilityStatePath      string // 窗口内采样价格的保存文件（JSON），重启后恢复，为空不保存，启动时还会用K线补齐窗口
 
 	// 通过一段时间价格的波动，使用以下公式来对forgive进行调整，因为 套利价差比 > forgive 才会下单，所以可以间接调整下单的难以程度
 	ForgivePercent          float64 // 套利价差比 > forgive才下单，forgive会随着spread的变化而变动
@@ -55,17 +112,82 @@ type Config struct {
 	ExponentPower           float64 // (math.Pow((spread/100), 0.75))/4 公式中的0.75
 	Denominator             float64 // (math.Pow((spread/100), 0.75))/4 公式中的中的4
 
-	TickerShift         float64 // 根据仓位修正现货和U本位合约买卖价格时的系数
+	TickerShift         float64 // 根据仓位修正现货和U本位合约买卖价格时的系数，QuoteModel 为 linear 时使用
 	QuoteAsset          string  // Quote Asset: BUSD
 	InitQuoteAssetValue float64 // 初始 BUSD/USDT 数量， 统计利润时会用到
 
+	// 挂单模型：linear 按 TickerShift 线性修正（默认），avellaneda_stoikov 按持仓、波动和风险厌恶计算保留价格和最优价差
+	QuoteModel   string
+	QuoteHorizon int64 // avellaneda_stoikov 持仓的时间范围，单位：ms，默认等于 VolatilityWindow
+
 	FunctionHedge      int     // 是否启动对冲功能
+	HedgeSlippage      float64 // 对冲限价单相对盘口价格的最大滑点，e.g. 0.001，0表示直接使用市价单
+	HedgeLimitTimeout  int64   // 对冲限价单等待成交的时间，单位：ms，超时后撤单，剩余部分用市价单
+	HedgeLedgerPath    string  // 未对冲数量的保存文件（JSON），重启后继续累计，为空不保存
+	DeltaAutoHedge     bool    // 净敞口超过 MaxDelta 时是否自动下单补齐对冲，按持仓重新对冲（RehedgeBand）的币种只报警
 	MaxErrorsPerMinute int64   // 每分钟允许出现的 Error 日志数量（超出数量之后退出程序）
 	MinDeltaRate       float64 // 最小差比例， 价格变动超过这个才进行处理
 	MinAccuracy        float64 // 价格最小精度
 	Commission         float64 // 手续费返点
+	SpotTakerFee       float64 // 现货吃单手续费率，HedgeVenue为auto时用来选择对冲的市场
+	FuturesTakerFee    float64 // U本位吃单手续费率
 	Loss               float64 // 让利亏损
 	CancelShift        float64 // 取消订单的价格系数
+
+	// 模拟交易所配置，离线运行时不连接币安
+	Simulated         bool                          // 是否使用模拟交易所
+	SimulatedPrices   map[string]float64            // 模拟行情的初始价格，key是币本位交易对，e.g. BTCUSD_PERP => 20000
+	SimulatedBasis    map[string]float64            // 模拟行情中币本位相对现货的溢价比例，key是币本位交易对
+	SimulatedBalances map[string]map[string]float64 // 模拟账户的初始资产，product => asset => amount，e.g. spot => BUSD => 10000
+	SimulatedTickSize map[string]float64            // 币本位交易对的价格精度，挂单价格按此取整，e.g. BTCUSD_PERP => 0.1
+
+	// 录制和回放配置，用来在本地复现线上问题
+	RecordPath  string  // 录制websocket消息的文件路径（gzip压缩的JSONL），为空不录制
+	ReplayPath  string  // 回放的录制文件路径，配置后不连接币安，下单使用模拟交易所
+	ReplaySpeed float64 // 回放速度，1是真实速度，小于等于0表示尽可能快
+
+	// 回测配置，回测的行情来自ReplayPath
+	BacktestReportPath string // 库存和盈亏曲线的输出文件（CSV），为空不输出
+}
+
+// 交易对的挂单参数，SymbolConfig 中配置的优先，没有配置的使用全局的值
+func (config *Config) GetQuoteParams(symbol string) QuoteParams {
+	params := QuoteParams{
+		MaxOrderNum:     config.MaxOrderNum,
+		MaxOrderOneStep: config.MaxOrderOneStep,
+		GapSizePercent:  config.GapSizePercent,
+		GapSizeK:        config.GapSizeK,
+		SpreadTimes:     config.SpreadTimes,
+		ForgivePercent:  config.ForgivePercent,
+		TickerShift:     config.TickerShift,
+		Commission:      config.Commission,
+		Loss:            config.Loss,
+		CancelShift:     config.CancelShift,
+	}
+	symbolCfg := config.SymbolConfigs[symbol]
+	overrideInt(&params.MaxOrderNum, symbolCfg.MaxOrderNum)
+	overrideInt(&params.MaxOrderOneStep, symbolCfg.MaxOrderOneStep)
+	overrideFloat64(&params.GapSizePercent, symbolCfg.GapSizePercent)
+	overrideFloat64(&params.GapSizeK, symbolCfg.GapSizeK)
+	overrideFloat64(&params.SpreadTimes, symbolCfg.SpreadTimes)
+	overrideFloat64(&params.ForgivePercent, symbolCfg.ForgivePercent)
+	overrideFloat64(&params.TickerShift, symbolCfg.TickerShift)
+	overrideFloat64(&params.Commission, symbolCfg.Commission)
+	overrideFloat64(&params.Loss, symbolCfg.Loss)
+	overrideFloat64(&params.CancelShift, symbolCfg.CancelShift)
+	return params
+}
+
+func overrideInt(value *int, override *int) {
+	if override != nil {
+		*value = *override
+	}
+}
+
+func overrideFloat64(value *float64, override *float64) {
+	if override != nil {
+		*value = *override
+	}
 }
 
 func LoadConfig(filename string) *Config {
@@ -0,0 +1,54 @@
+package config
+
+import "testing"
+
+func TestGetQuoteParams(t *testing.T) {
+	maxOrderNum, gapSizeK, zero := 3, 2.5, 0.0
+	config := &Config{
+		MaxOrderNum:     5,
+		MaxOrderOneStep: 2,
+		GapSizePercent:  0.001,
+		GapSizeK:        1,
+		SpreadTimes:     10,
+		ForgivePercent:  0.99,
+		TickerShift:     0.1,
+		Commission:      0.0001,
+		Loss:            0.002,
+		CancelShift:     0.5,
+		SymbolConfigs: map[string]SymbolConfig{
+			"BTCUSD_PERP": {},
+			"ETHUSD_PERP": {MaxOrderNum: &maxOrderNum, GapSizeK: &gapSizeK, TickerShift: &zero},
+		},
+	}
+	global := QuoteParams{
+		MaxOrderNum:     5,
+		MaxOrderOneStep: 2,
+		GapSizePercent:  0.001,
+		GapSizeK:        1,
+		SpreadTimes:     10,
+		ForgivePercent:  0.99,
+		TickerShift:     0.1,
+		Commission:      0.0001,
+		Loss:            0.002,
+		CancelShift:     0.5,
+	}
+	overridden := global
+	overridden.MaxOrderNum, overridden.GapSizeK, overridden.TickerShift = 3, 2.5, 0
+
+	tests := []struct {
+		name   string
+		symbol string
+		want   QuoteParams
+	}{
+		{"no override", "BTCUSD_PERP", global},
+		{"override, zero is a value", "ETHUSD_PERP", overridden},
+		{"unknown symbol", "SOLUSD_PERP", global},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if params := config.GetQuoteParams(test.symbol); params != test.want {
+				t.Errorf("params = %+v, want %+v", params, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// 策略引擎，把行情、订单、定时器和账户事件分发给策略
+// 连接、下单、对冲等基础设施不属于策略，策略通过 Engine 获取配置、Context 和 OrderHandler
+package engine
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"cex/trading"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 事件来自的产品线
+const (
+	ProductDelivery = "delivery" // 币本位
+	ProductFutures  = "futures"  // U本位
+	ProductSpot     = "spot"     // 现货
+)
+
+// 策略，所有方法都由 Engine 调用
+type Strategy interface {
+	Name() string
+	// 建立 websocket 连接之前调用，策略在这里注册定时器和需要的账户信息
+	Init(engine *Engine)
+	OnPrice(product string, resp *client.PriceWSResponse)
+	OnOrderUpdate(product string, resp *client.OrderWSResponse)
+	// name 是 AddTimer 注册的名称
+	OnTimer(name string)
+	// accounts 是 product => 账户信息，获取失败的产品线没有
+	OnAccount(accounts map[string]*client.Account)
+}
+
+// 可选接口，用户数据流断线期间漏掉的成交由对账发现，交给策略按自己的方式对冲
+// volume 是币本位持仓变化的张数，orderType 是成交的方向，没有实现的策略不处理
+type FillReconciler interface {
+	OnReconcileFill(symbol string, orderType string, volume float64)
+}
+
+// 可选接口，进程退出时调用，用来保存策略的状态
+type Stopper interface {
+	OnStop()
+}
+
+// 创建策略，每个进程只创建一次
+type Factory func() Strategy
+
+var (
+	factoriesMutex sync.Mutex
+	factories      = map[string]Factory{}
+)
+
+// 注册策略，一般在策略所在文件的 init 中调用
+func Register(name string, factory Factory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	factories[name] = factory
+}
+
+// 已经注册的策略名称
+func Strategies() []string {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 定时器，间隔是上一次执行结束到下一次执行开始的时间
+type Timer struct {
+	Name     string
+	Interval time.Duration
+}
+
+// 账户信息的定时获取
+type accountWatch struct {
+	interval time.Duration
+	products []string
+}
+
+type Engine struct {
+	strategy     Strategy
+	cfg          *config.Config
+	context      *trading.Context
+	orders       *trading.OrderHandler
+	timers       []Timer
+	orderClients map[string]client.OrderClient
+	accountWatch *accountWatch
+}
+
+// 按名称创建策略，没有注册时返回错误
+// orders 需要先初始化，产品线的交易接口用来获取账户信息
+func New(name string, cfg *config.Config, context *trading.Context, orders *trading.OrderHandler) (*Engine, error) {
+	factoriesMutex.Lock()
+	factory, ok := factories[name]
+	factoriesMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %s, available: %v", name, Strategies())
+	}
+	return &Engine{
+		strategy: factory(),
+		cfg:      cfg,
+		context:  context,
+		orders:   orders,
+		orderClients: map[string]client.OrderClient{
+			ProductDelivery: orders.DeliveryOrderClient,
+			ProductFutures:  orders.FuturesOrderClient,
+			ProductSpot:     orders.SpotOrderClient,
+		},
+	}, nil
+}
+
+func (engine *Engine) Strategy() Strategy {
+	return engine.strategy
+}
+
+// 配置
+func (engine *Engine) Config() *config.Config {
+	return engine.cfg
+}
+
+// 价格、仓位和账户信息
+func (engine *Engine) Context() *trading.Context {
+	return engine.context
+}
+
+// 下单、撤单和对冲
+func (engine *Engine) Orders() *trading.OrderHandler {
+	return engine.orders
+}
+
+// 调用策略的 Init
+func (engine *Engine) Init() {
+	engine.strategy.Init(engine)
+	logger.Info("strategy %s initialized, timers=%v", engine.strategy.Name(), engine.timers)
+}
+
+// 注册定时器，在策略的 Init 中调用
+func (engine *Engine) AddTimer(name string, interval time.Duration) {
+	engine.timers = append(engine.timers, Timer{Name: name, Interval: interval})
+}
+
+// 定时获取账户信息并调用 OnAccount，在策略的 Init 中调用
+func (engine *Engine) WatchAccounts(interval time.Duration, products ...string) {
+	engine.accountWatch = &accountWatch{interval: interval, products: products}
+}
+
+// 注册的定时器，回测时由回测的时钟驱动
+func (engine *Engine) Timers() []Timer {
+	return engine.timers
+}
+
+// 执行定时器的函数
+func (engine *Engine) TimerFunc(name string) func() {
+	return func() { engine.strategy.OnTimer(name) }
+}
+
+// 获取账户信息并调用 OnAccount，没有调用 WatchAccounts 时不处理
+func (engine *Engine) RefreshAccounts() {
+	if engine.accountWatch == nil {
+		return
+	}
+	accounts := map[string]*client.Account{}
+	for _, product := range engine.accountWatch.products {
+		orderClient, ok := engine.orderClients[product]
+		if !ok {
+			continue
+		}
+		account, err := orderClient.GetAccount()
+		if err != nil {
+			logger.Error("strategy %s get %s account failed, message is %s", engine.strategy.Name(), product, err.Error())
+			continue
+		}
+		accounts[product] = account
+	}
+	engine.strategy.OnAccount(accounts)
+}
+
+// 获取一次账户信息，然后启动定时器
+func (engine *Engine) Start() {
+	if engine.accountWatch != nil {
+		engine.RefreshAccounts()
+		go common.Timer(engine.accountWatch.interval, engine.RefreshAccounts)
+	}
+	for _, timer := range engine.timers {
+		go common.Timer(timer.Interval, engine.TimerFunc(timer.Name))
+	}
+}
+
+// 对账发现的成交交给策略，策略没有实现 FillReconciler 时返回 false
+func (engine *Engine) ReconcileFill(symbol string, orderType string, volume float64) bool {
+	reconciler, ok := engine.strategy.(FillReconciler)
+	if !ok {
+		return false
+	}
+	reconciler.OnReconcileFill(symbol, orderType, volume)
+	return true
+}
+
+// 进程退出时调用策略的 OnStop，策略没有实现 Stopper 时不处理
+func (engine *Engine) Stop() {
+	if stopper, ok := engine.strategy.(Stopper); ok {
+		stopper.OnStop()
+	}
+}
+
+// 产品线的行情消息交给策略
+func (engine *Engine) PriceHandler(product string) client.PriceProcessHandler {
+	return func(resp *client.PriceWSResponse) {
+		engine.strategy.OnPrice(product, resp)
+	}
+}
+
+// 产品线的订单消息交给策略
+func (engine *Engine) OrderHandler(product string) client.OrderProcessHandler {
+	return func(resp *client.OrderWSResponse) {
+		engine.strategy.OnOrderUpdate(product, resp)
+	}
+}
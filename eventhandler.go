@@ -2,17 +2,19 @@ package main
 
 import (
 	"cex/client"
+	"cex/client/recorder"
+	"cex/client/simulator"
 	"cex/common"
-	"cex/common/logger"
 	"cex/config"
-	"math"
+	"cex/engine"
+	"cex/trading"
 )
 
 type EventHandler struct {
 	wsClient []client.WSClient
 }
 
-func (handler *EventHandler) Init(cfg *config.Config, orderHandler OrderHandler) {
+func (handler *EventHandler) Init(cfg *config.Config, orderHandler *trading.OrderHandler) {
 	context := &ctxt
 	binanceConfig := client.Config{
 		AccessKey: cfg.BinanceAPIKey,
@@ -20,34 +22,97 @@ func (handler *EventHandler) Init(cfg *config.Config, orderHandler OrderHandler)
 		Symbols:   cfg.Symbols,
 	}
 
+	var futuresSymbols []string
+	for futuresSymbol := range context.SymbolMap {
+		futuresSymbols = append(futuresSymbols, futuresSymbol)
+	}
+
+	if cfg.ReplayPath != "" {
+		handler.initReplay(binanceConfig, futuresSymbols)
+		return
+	}
+
+	if cfg.Simulated {
+		handler.initSimulated(binanceConfig, futuresSymbols)
+		return
+	}
+
 	// 初始化币安的币本位 WS client
 	binanceDeliveryWSClient := new(client.BinanceDeliveryWSClient)
 	binanceDeliveryWSClient.Init(binanceConfig)
-	binanceDeliveryWSClient.SetHttpClient(orderHandler.BinanceDeliveryOrderClient)
-	binanceDeliveryWSClient.SetPriceHandler(DeliveryPriceWSHandler, common.CommonErrorHandler)
-	binanceDeliveryWSClient.SetOrderHandler(DeliveryOrderWSHandler)
+	binanceDeliveryWSClient.SetHttpClient(orderHandler.DeliveryOrderClient)
+	binanceDeliveryWSClient.SetPriceHandler(recordPriceHandler(recorder.StreamDelivery, strategyEngine.PriceHandler(engine.ProductDelivery)), common.CommonErrorHandler)
+	binanceDeliveryWSClient.SetOrderHandler(recordOrderHandler(recorder.StreamDelivery, strategyEngine.OrderHandler(engine.ProductDelivery)))
+	binanceDeliveryWSClient.SetUserStreamReconnectHandler(ReconcileUserData)
 	handler.wsClient = append(handler.wsClient, binanceDeliveryWSClient)
 
 	// 初始化币安的U本位 WS client
-	var futuresSymbols []string
-	for futuresSymbol := range context.SymbolMap {
-		futuresSymbols = append(futuresSymbols, futuresSymbol)
-	}
 	binanceConfig.Symbols = futuresSymbols
 	binanceFuturesWSClient := new(client.BinanceFuturesWSClient)
 	binanceFuturesWSClient.Init(binanceConfig)
-	binanceFuturesWSClient.SetPriceHandler(FuturesPriceHandler, common.CommonErrorHandler)
-	binanceFuturesWSClient.SetHttpClient(orderHandler.BinanceFuturesOrderClient)
+	binanceFuturesWSClient.SetPriceHandler(recordPriceHandler(recorder.StreamFutures, strategyEngine.PriceHandler(engine.ProductFutures)), common.CommonErrorHandler)
+	// 用U本位对冲时才需要订单消息
+	if orderHandler.UsesFuturesHedge() {
+		binanceFuturesWSClient.SetOrderHandler(recordOrderHandler(recorder.StreamFutures, strategyEngine.OrderHandler(engine.ProductFutures)))
+	}
+	binanceFuturesWSClient.SetHttpClient(orderHandler.FuturesOrderClient)
 	handler.wsClient = append(handler.wsClient, binanceFuturesWSClient)
 
 	// 初始化币安现货的 WS client
 	binanceSpotWSClient := new(client.BinanceSpotWSClient)
 	binanceSpotWSClient.Init(binanceConfig)
-	binanceSpotWSClient.SetPriceHandler(SpotPriceHandler, common.CommonErrorHandler)
-	binanceSpotWSClient.SetHttpClient(orderHandler.BinanceSpotOrderClient)
+	binanceSpotWSClient.SetPriceHandler(recordPriceHandler(recorder.StreamSpot, strategyEngine.PriceHandler(engine.ProductSpot)), common.CommonErrorHandler)
+	binanceSpotWSClient.SetOrderHandler(recordOrderHandler(recorder.StreamSpot, strategyEngine.OrderHandler(engine.ProductSpot)))
+	binanceSpotWSClient.SetHttpClient(orderHandler.SpotOrderClient)
 	handler.wsClient = append(handler.wsClient, binanceSpotWSClient)
 }
 
+// 使用模拟交易所的 WS client，价格和订单消息由模拟交易所回调
+func (handler *EventHandler) initSimulated(wsConfig client.Config, futuresSymbols []string) {
+	deliveryWSClient := simulator.NewWSClient(simulatedMarket.Delivery)
+	deliveryWSClient.Init(wsConfig)
+	deliveryWSClient.SetPriceHandler(recordPriceHandler(recorder.StreamDelivery, strategyEngine.PriceHandler(engine.ProductDelivery)), common.CommonErrorHandler)
+	deliveryWSClient.SetOrderHandler(recordOrderHandler(recorder.StreamDelivery, strategyEngine.OrderHandler(engine.ProductDelivery)))
+	handler.wsClient = append(handler.wsClient, deliveryWSClient)
+
+	wsConfig.Symbols = futuresSymbols
+	futuresWSClient := simulator.NewWSClient(simulatedMarket.Futures)
+	futuresWSClient.Init(wsConfig)
+	futuresWSClient.SetPriceHandler(recordPriceHandler(recorder.StreamFutures, strategyEngine.PriceHandler(engine.ProductFutures)), common.CommonErrorHandler)
+	futuresWSClient.SetOrderHandler(recordOrderHandler(recorder.StreamFutures, strategyEngine.OrderHandler(engine.ProductFutures)))
+	handler.wsClient = append(handler.wsClient, futuresWSClient)
+
+	spotWSClient := simulator.NewWSClient(simulatedMarket.Spot)
+	spotWSClient.Init(wsConfig)
+	spotWSClient.SetPriceHandler(recordPriceHandler(recorder.StreamSpot, strategyEngine.PriceHandler(engine.ProductSpot)), common.CommonErrorHandler)
+	spotWSClient.SetOrderHandler(recordOrderHandler(recorder.StreamSpot, strategyEngine.OrderHandler(engine.ProductSpot)))
+	handler.wsClient = append(handler.wsClient, spotWSClient)
+}
+
+// 回放录制的消息，价格消息同时驱动模拟交易所的深度；订单消息只使用录制的，模拟交易所的订单消息不处理
+func (handler *EventHandler) initReplay(wsConfig client.Config, futuresSymbols []string) {
+	deliveryWSClient := recorder.NewWSClient(replayPlayer, recorder.StreamDelivery)
+	deliveryWSClient.Init(wsConfig)
+	deliveryWSClient.SetPriceHandler(replayPriceHandler(simulatedMarket.Delivery, strategyEngine.PriceHandler(engine.ProductDelivery)), common.CommonErrorHandler)
+	deliveryWSClient.SetOrderHandler(strategyEngine.OrderHandler(engine.ProductDelivery))
+	handler.wsClient = append(handler.wsClient, deliveryWSClient)
+
+	wsConfig.Symbols = futuresSymbols
+	futuresWSClient := recorder.NewWSClient(replayPlayer, recorder.StreamFutures)
+	futuresWSClient.Init(wsConfig)
+	futuresWSClient.SetPriceHandler(replayPriceHandler(simulatedMarket.Futures, strategyEngine.PriceHandler(engine.ProductFutures)), common.CommonErrorHandler)
+	handler.wsClient = append(handler.wsClient, futuresWSClient)
+
+	spotWSClient := recorder.NewWSClient(replayPlayer, recorder.StreamSpot)
+	spotWSClient.Init(wsConfig)
+	spotWSClient.SetPriceHandler(replayPriceHandler(simulatedMarket.Spot, strategyEngine.PriceHandler(engine.ProductSpot)), common.CommonErrorHandler)
+	handler.wsClient = append(handler.wsClient, spotWSClient)
+
+	// 对冲单在模拟交易所成交，确认成交使用模拟交易所的订单消息
+	simulatedMarket.Futures.SetOrderHandler(strategyEngine.OrderHandler(engine.ProductFutures))
+	simulatedMarket.Spot.SetOrderHandler(strategyEngine.OrderHandler(engine.ProductSpot))
+}
+
 func (handler *EventHandler) Start() {
 	for _, wsClient := range handler.wsClient {
 		wsClient.StartWS()
@@ -60,209 +125,26 @@ func (handler *EventHandler) Stop() {
 	}
 }
 
-func DeliveryPriceWSHandler(resp *client.PriceWSResponse) {
-	context := &ctxt
-	config := &cfg
-	symbol := resp.Symbol
-	symbolCfg := config.SymbolConfigs[symbol]
-	symbolContext := context.GetSymbolContext(symbol)
-
-	timeStamp := common.GetTimestampInMS()
-	if resp.MsgType == "deliveryBookTicker" {
-		// 处理期货bookTicker
-		bidPrice, askPrice := 0.0, 0.0
-		bidVolume, askVolume := 0.0, 0.0
-		for _, item := range resp.Items {
-			if item.Volume < symbolCfg.EffectiveNum {
-				continue
-			}
-			if item.Direction == "buy" {
-				bidPrice = item.Price
-				bidVolume = item.Volume
-			} else if item.Direction == "sell" {
-				askPrice = item.Price
-				askVolume = item.Volume
-			}
-		}
-
-		buyDelta, sellDelta := 0.0, 0.0
-		if bidPrice > config.MinAccuracy {
-			buyDelta = math.Abs(bidPrice-symbolContext.BidPrice) / bidPrice
-			symbolContext.BidPrice = bidPrice
-			symbolContext.BidVolume = bidVolume
-			logger.Debug("binance delivery %s buy price is %f, quantity is %f at %d",
-				symbol, bidPrice, bidVolume, resp.TimeStamp)
-		}
-
-		if askPrice > config.MinAccuracy {
-			sellDelta = math.Abs(askPrice-symbolContext.AskPrice) / askPrice
-			symbolContext.AskPrice = askPrice
-			symbolContext.AskVolume = askVolume
-			logger.Debug("binance delivery %s sell price is %f, quantity is %f at %d",
-				symbol, askPrice, askVolume, resp.TimeStamp)
-		}
-		if bidPrice > config.MinAccuracy || askPrice > config.MinAccuracy {
-			symbolContext.LastUpdateTime = timeStamp
-		}
-
-		logger.Debug("binance delivery bookTicker symbol: %s, bidPrice:%f, bidVolume:%f, askPrice:%f, askVolume:%f, updateTime:%d", resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, resp.TimeStamp)
-		// 价格变化大于一定比例才触发更新orders
-		if buyDelta > config.MinDeltaRate || sellDelta > config.MinDeltaRate {
-			go orderHandler.CancelOrders(symbol)
-		}
+// 和币本位symbol相关的连接中没有连上的，用来判断价格不更新的原因
+// 组合流、订单流的symbol为空，和所有symbol相关；模拟交易所和回放的 WS client 不报告连接状态
+func (handler *EventHandler) GetBrokenStreams(deliverySymbol string) []client.StreamState {
+	symbols := []string{
+		"",
+		deliverySymbol,
+		common.FormatFuturesSymbol(deliverySymbol, cfg.QuoteAsset),
+		common.FormatSpotSymbol(deliverySymbol, cfg.QuoteAsset),
 	}
-}
-
-func DeliveryOrderWSHandler(resp *client.OrderWSResponse) {
-	context := &ctxt
-	config := &cfg
-	symbol := resp.Order.Symbol
-	symbolCfg := config.SymbolConfigs[symbol]
-
-	logger.Info("binance delivery order resp is: %+v", resp)
-	if resp.MsgType == "ORDER_TRADE_UPDATE" {
-		orderType := resp.Order.OrderType
-		clientOrderID := resp.Order.ClientOrderID
-		// 订单成交
-		if resp.Status == "PARTIALLY_FILLED" || resp.Status == "FILLED" {
-			deliveryContext := context.GetSymbolContext(resp.Order.Symbol)
-			spotPriceItem := ctxt.GetPriceItem(config.Exchange, symbol, "spot")
-			logger.Info("Op=Fill, Exchange=Binance, Direction=%s, filled price=%f, amount=%f, OrderID=%d, ClientOrderID=%s, BuyPrice=%.2f, SellPrice=%.2f, Symbol=%s, sBidPrice=%.4f, sAskPrice=%.4f",
-				resp.Order.OrderType, resp.Order.OrderPrice, resp.Order.OrderVolume, resp.Order.OrderID,
-				resp.Order.ClientOrderID, deliveryContext.BidPrice, deliveryContext.AskPrice,
-				symbol, spotPriceItem.BidPrice, spotPriceItem.AskPrice)
-
-			// 下单对冲
-			if config.FunctionHedge == 1 {
-				hedgeOrderType := common.GetHedgeOrderType(orderType)
-				// 合约张数
-				volume := resp.Order.OrderVolume
-				cont := float64(symbolCfg.Cont)
-				price := 0.0
-				if hedgeOrderType == "buy" {
-					price = spotPriceItem.BidPrice
-				} else {
-					price = spotPriceItem.AskPrice
-				}
-				amount := volume * cont / price
-				logger.Info("===volume:%s, price:%s, amount:%s, minHedgeSize:%s, hedgeOrderType: %s", volume, price, amount, symbolCfg.MinHedgeSize, hedgeOrderType)
-
-				if volume >= symbolCfg.MinHedgeSize {
-					var order common.Order
-					order.Exchange = "Binance"
-					order.OrderType = hedgeOrderType
-					order.OrderPrice = price
-					order.OrderVolume = amount
-					order.Symbol = resp.Order.Symbol
-					order.ClientOrderID = resp.Order.ClientOrderID
-					order.BaseAsset = symbolCfg.BaseAsset
-					order.QuoteAsset = config.QuoteAsset
-					order.Precision = symbolCfg.Precision
-					orderHandler.PlaceHedgeOrder(&order)
-				}
-			}
-
-			if resp.Status == "FILLED" {
-				orderHandler.DeleteByClientOrderID(symbol, orderType, clientOrderID)
-			}
-		} else if resp.Status == "EXPIRED" {
-			logger.Info("EXPIRED, order=%s", resp.Order.FormatString())
-			orderHandler.DeleteByClientOrderID(symbol, orderType, clientOrderID)
-		} else if resp.Status == "CANCELED" {
-			logger.Info("CANCELED, order=%s", resp.Order.FormatString())
-			orderHandler.DeleteByClientOrderID(symbol, orderType, clientOrderID)
-		} else if resp.Status == "NEW" {
-			logger.Info("NEW, Exchange=Binance, Direction=%s, original price=%f, original amount=%f, OrderID=%s, ClientOrderID=%s",
-				orderType, resp.Order.OrderPrice, resp.Order.OrderVolume, resp.Order.OrderID, clientOrderID)
-			orderHandler.UpdateStatus(symbol, orderType, resp.Order.ClientOrderID, common.CREATED)
-		}
-	} else if resp.MsgType == "ACCOUNT_UPDATE" {
-		// ACCOUNT_UPDATE 返回的仓位是全量信息
-		if resp.Status == "ORDER_UPDATE" {
-			symbol := resp.Symbol
-			account := ctxt.Accounts.GetAccount(resp.Exchange, "swap_cross")
-
-			account.UpdatePosition(symbol, resp.Position)
-			logger.Warn("Binance position update, Symbol: %s, PositionMargin=%f",
-				symbol, resp.Position)
-		}
-	}
-}
-
-func FuturesPriceHandler(resp *client.PriceWSResponse) {
-	timeStamp := common.GetTimestampInMS()
-	if resp.MsgType == "futuresBookTicker" {
-		// 处理期货bookTicker
-		bidPrice, askPrice := 0.0, 0.0
-		bidVolume, askVolume := 0.0, 0.0
-		for _, item := range resp.Items {
-			if item.Direction == "buy" {
-				bidPrice = item.Price
-				bidVolume = item.Volume
-			} else if item.Direction == "sell" {
-				askPrice = item.Price
-				askVolume = item.Volume
-			}
-		}
-		logger.Debug("binance futures bookTicker symbol: %s, bidPrice:%f, bidVolume:%f, askPrice:%f, askVolume:%f, updateTime:%d", resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, resp.TimeStamp)
-		go UpdatePrice(resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, timeStamp, "futures")
-
-	}
-}
-
-// 更新参照组最新买卖价格
-func UpdatePrice(symbol string, bidPrice float64, bidVolume float64,
-	askPrice float64, askVolume float64, timestamp int64, ptype string) {
-	context := &ctxt
-	config := &cfg
-
-	deliverySymbols := context.GetDeliverySymbol(symbol)
-	for _, deliverySymbol := range deliverySymbols {
-		name := common.FormatPriceName(cfg.Exchange, deliverySymbol, ptype)
-		priceDataItem, ok := ctxt.Prices.Items[name]
+	var states []client.StreamState
+	for _, wsClient := range handler.wsClient {
+		reporter, ok := wsClient.(client.StreamStateReporter)
 		if !ok {
 			continue
 		}
-		buyDelta, sellDelta := 0.0, 0.0
-
-		if bidPrice > config.MinAccuracy || askPrice > config.MinAccuracy {
-			priceDataItem.LastUpdateTime = timestamp
-		}
-
-		if bidPrice > config.MinAccuracy {
-			buyDelta = math.Abs(bidPrice-priceDataItem.AskPrice) / bidPrice
-			priceDataItem.BidPrice = bidPrice
-			priceDataItem.BidVolume = bidVolume
-		}
-
-		if askPrice > config.MinAccuracy {
-			sellDelta = math.Abs(askPrice-priceDataItem.AskPrice) / askPrice
-			priceDataItem.AskPrice = askPrice
-			priceDataItem.AskVolume = askVolume
-		}
-
-		// 价格变化大于一定比例才触发更新orders
-		if buyDelta > config.MinDeltaRate || sellDelta > config.MinDeltaRate {
-			orderHandler.CancelOrders(deliverySymbol)
-		}
-	}
-}
-
-func SpotPriceHandler(resp *client.PriceWSResponse) {
-	timeStamp := common.GetTimestampInMS()
-	// 处理期货bookTicker
-	bidPrice, askPrice := 0.0, 0.0
-	bidVolume, askVolume := 0.0, 0.0
-	for _, item := range resp.Items {
-		if item.Direction == "buy" {
-			bidPrice = item.Price
-			bidVolume = item.Volume
-		} else if item.Direction == "sell" {
-			askPrice = item.Price
-			askVolume = item.Volume
+		for _, state := range reporter.StreamStates() {
+			if state.State != client.StreamConnected && common.InArray(state.Symbol, symbols) {
+				states = append(states, state)
+			}
 		}
 	}
-
-	go UpdatePrice(resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, timeStamp, "spot")
-	logger.Debug("binance spot bookTicker symbol: %s, bidPrice:%f, bidVolume:%f, askPrice:%f, askVolume:%f, updateTime:%d", resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, resp.TimeStamp)
+	return states
 }
@@ -4,6 +4,7 @@ import (
 	"cex/common"
 	"cex/common/logger"
 	"cex/config"
+	"cex/trading"
 	"fmt"
 	"os"
 	"time"
@@ -11,46 +12,53 @@ import (
 
 // 全局变量
 var cfg config.Config
-var ctxt Context
-var orderHandler OrderHandler
+var ctxt trading.Context
+var orderHandler trading.OrderHandler
 var eventHandler EventHandler
+var simulatedMarket *trading.SimulatedMarket
 
 func Init(conf *config.Config) {
+	// 回放录制的消息，需要在初始化上下文之前，回放时会打开Simulated
+	if conf.ReplayPath != "" {
+		InitReplay(conf)
+	}
+
 	// 初始化上下文
 	ctxt.Init(conf)
 
+	// 初始化模拟交易所
+	if conf.Simulated {
+		simulatedMarket = trading.NewSimulatedMarket(conf)
+	}
+
+	// 初始化websocket消息录制
+	if conf.RecordPath != "" {
+		InitRecorder(conf)
+	}
+
 	// 初始化order handlers, 通过HTTPS API 处理订单相关信息
-	orderHandler.Init(conf)
+	orderHandler.Init(conf, &ctxt, simulatedMarket)
+	// 检查挂单数量的配置
+	trading.InitOrderSizing(conf)
+	// 初始化策略，行情、订单消息和定时器由策略处理
+	InitStrategy(conf)
 	// 初始化 event handlers， 通过WSS event处理价格、订单相关消息
-	eventHandler.Init(conf, orderHandler)
-
-	// 初始化 动态配置
-	InitDynamicConfig(conf)
+	eventHandler.Init(conf, &orderHandler)
 }
 func Start() {
 	// 启动websockets
 	eventHandler.Start()
 
+	// 启动模拟行情，回放时行情来自录制文件
+	if simulatedMarket != nil && replayPlayer == nil {
+		simulatedMarket.Feed.Start()
+	}
+
 	// 确保 ws 正常启动和监听
 	time.Sleep(5 * time.Second)
 
-	// 获取账户初始状态
-	UpdateAccount()
-
-	// 每100ms计算一遍波动参数
-	go common.Timer(100*time.Millisecond, UpdateDynamicConfigs)
-
-	// 每1s更新一遍订单
-	go common.Timer(1*time.Second, UpdateOrders)
-
-	// 每3秒钟取消距离较远的订单
-	go common.Timer(3*time.Second, CancelFarOrders)
-
-	// 每3s取消一次间距较近的订单
-	go common.Timer(3*time.Second, CancelCloseDistanceOrders)
-
-	// 每分钟更新一次账户状态
-	go common.Timer(60*time.Second, UpdateAccount)
+	// 获取账户初始状态，启动策略的定时器
+	strategyEngine.Start()
 
 	// 每100ms 检查一下价格，如果指定时间价格没有更新取消挂单或者停掉服务，避免造成亏损
 	go common.Timer(100*time.Millisecond, CheckStatus)
@@ -58,26 +66,46 @@ func Start() {
 	// 每分钟执行一次，统计除了币安下单 ERROR 之外的 ERROR 信息，超过配置次数就报警
 	go common.Timer(1*time.Minute, CheckErrors)
 
+	// 开始回放录制的消息
+	if replayPlayer != nil {
+		StartReplay()
+	}
 }
 func ExitProcess() {
 	// 取消所有订单, 不判断本地orders
 	logger.Info("DelContext cancel all orders")
-	for _, symbolContext := range ctxt.symbolContexts {
-		symbolContext.Risk = 1
+	for _, symbol := range ctxt.Symbols {
+		ctxt.GetSymbolContext(symbol).Risk = 1
 	}
 	orderHandler.CancelAllOrdersWithoutCheckOrderBook()
 
 	// 停止webscoket
 	eventHandler.Stop()
+
+	// 停止录制，确保缓存中的数据写入文件
+	StopRecorder()
+
+	// 策略保存自己的状态，重启后继续使用
+	if strategyEngine != nil {
+		strategyEngine.Stop()
+	}
 	os.Exit(1)
 }
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %s config_file\n", os.Args[0])
+		fmt.Printf("Usage: %s config_file\n       %s backtest config_file\n", os.Args[0], os.Args[0])
 		os.Exit(1)
 	}
 
+	// 回测，用录制的行情跑一遍策略，输出成交和盈亏
+	if os.Args[1] == "backtest" && len(os.Args) >= 3 {
+		cfg = *config.LoadConfig(os.Args[2])
+		logger.InitLogger(cfg.LogPath, cfg.LogLevel)
+		RunBacktest(&cfg)
+		return
+	}
+
 	// 监听退出消息，并调用ExitProcess进行处理
 	common.RegisterExitSignal(ExitProcess)
 
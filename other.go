@@ -18,6 +18,11 @@ func CheckStatus() {
 	// 超过1秒没有更新，停止挂单
 	for _, symbol := range ctxt.Symbols {
 		symbolContext := ctxt.GetSymbolContext(symbol)
+		// 下单被拒绝的暂停时间到了，恢复挂单
+		if symbolContext.Risk == 5 && timeStamp >= symbolContext.PauseUntil {
+			symbolContext.Risk = 0
+			logger.Warn("%s pause expired, set risk to 0.", symbol)
+		}
 		timeDiff := timeStamp - symbolContext.LastUpdateTime
 		logger.Debug("timediff:%d", timeStamp-symbolContext.LastUpdateTime)
 		if timeDiff > 1000 {
@@ -27,10 +32,18 @@ func CheckStatus() {
 				logger.Warn("%s Price not update in 10s. CancelAllOrders: %d", symbol, symbolContext.LastUpdateTime)
 				orderHandler.CancelAllOrdersWithSymbol(symbol)
 			}
-			if symbolContext.Risk == 3 {
+			if symbolContext.Risk == 3 || symbolContext.Risk == 5 {
 				continue
 			}
 			symbolContext.Risk = 3
+			// 打印价格不更新的原因
+			brokenStreams := eventHandler.GetBrokenStreams(symbol)
+			if len(brokenStreams) == 0 {
+				logger.Warn("%s Price not update in 1s, no disconnected stream", symbol)
+			}
+			for _, state := range brokenStreams {
+				logger.Warn("%s Price not update in 1s, %s", symbol, state.String())
+			}
 			// common.SendMessge(ctxt.TelegramBot, cfg.TgChatID, "停止挂单，原因:价格超过1s没有更新")
 		} else {
 			if symbolContext.Risk == 3 {
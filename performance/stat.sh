@@ -1,80 +1,32 @@
 #!/bin/bash
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresBookTicker' | wc -l
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresBookTicker' |awk -F '|' '{if($NF<=3 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresBookTicker' |awk -F '|' '{if($NF>3 && $NF <= 10 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresBookTicker' |awk -F '|' '{if($NF>10 && $NF <= 100 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresBookTicker' |awk -F '|' '{if($NF>100 && $NF <= 500 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresBookTicker' |awk -F '|' '{if($NF>500 && $NF <= 1000 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresBookTicker' |awk -F '|' '{if($NF>1000 ){sum+=1}} END {print sum}';
-
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryBookTicker' | wc -l
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryBookTicker' |awk -F '|' '{if($NF<=3 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryBookTicker' |awk -F '|' '{if($NF>3 && $NF <= 10 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryBookTicker' |awk -F '|' '{if($NF>10 && $NF <= 100 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryBookTicker' |awk -F '|' '{if($NF>100 && $NF <= 500 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryBookTicker' |awk -F '|' '{if($NF>500 && $NF <= 1000 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryBookTicker' |awk -F '|' '{if($NF>1000 ){sum+=1}} END {print sum}';
-
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotBookTicker' | wc -l
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotBookTicker' |awk -F '|' '{if($NF<=3 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotBookTicker' |awk -F '|' '{if($NF>3 && $NF <= 10 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotBookTicker' |awk -F '|' '{if($NF>10 && $NF <= 100 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotBookTicker' |awk -F '|' '{if($NF>100 && $NF <= 500 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotBookTicker' |awk -F '|' '{if($NF>500 && $NF <= 1000 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotBookTicker' |awk -F '|' '{if($NF>1000 ){sum+=1}} END {print sum}';
-
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresDepth' | wc -l
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresDepth' |awk -F '|' '{if($NF<=3 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresDepth' |awk -F '|' '{if($NF>3 && $NF <= 10 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresDepth' |awk -F '|' '{if($NF>10 && $NF <= 100 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresDepth' |awk -F '|' '{if($NF>100 && $NF <= 500 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresDepth' |awk -F '|' '{if($NF>500 && $NF <= 1000 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'futuresDepth' |awk -F '|' '{if($NF>1000 ){sum+=1}} END {print sum}';
-
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryDepth' | wc -l
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryDepth' |awk -F '|' '{if($NF<=3 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryDepth' |awk -F '|' '{if($NF>3 && $NF <= 10 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryDepth' |awk -F '|' '{if($NF>10 && $NF <= 100 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryDepth' |awk -F '|' '{if($NF>100 && $NF <= 500 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryDepth' |awk -F '|' '{if($NF>500 && $NF <= 1000 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'deliveryDepth' |awk -F '|' '{if($NF>1000 ){sum+=1}} END {print sum}';
-
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotDepth' | wc -l
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotDepth' |awk -F '|' '{if($NF<=3 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotDepth' |awk -F '|' '{if($NF>3 && $NF <= 10 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotDepth' |awk -F '|' '{if($NF>10 && $NF <= 100 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotDepth' |awk -F '|' '{if($NF>100 && $NF <= 500 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotDepth' |awk -F '|' '{if($NF>500 && $NF <= 1000 ){sum+=1}} END {print sum}';
-awk -F "\t" '{print $3}' dmmspot.log|grep 'spotDepth' |awk -F '|' '{if($NF>1000 ){sum+=1}} END {print sum}';
-
-grep 'placeLimitOrder' * | wc -l
-grep 'placeLimitOrder' * | awk -F '|' '{if($NF/10**6 <= 8){sum+=1}} END {print sum}'
-grep 'placeLimitOrder' * | awk -F '|' '{if($NF/10**6 > 8 && $NF/10**6 <= 10 ){sum+=1}} END {print sum}';
-grep 'placeLimitOrder' * | awk -F '|' '{if($NF/10**6 > 10 && $NF/10**6 <= 20 ){sum+=1}} END {print sum}';
-grep 'placeLimitOrder' * | awk -F '|' '{if($NF/10**6 > 20 && $NF/10**6 <= 50 ){sum+=1}} END {print sum}';
-grep 'placeLimitOrder' * | awk -F '|' '{if($NF/10**6 > 50 && $NF/10**6 <= 100 ){sum+=1}} END {print sum}';
-grep 'placeLimitOrder' * | awk -F '|' '{if($NF/10**6 > 100 ){sum+=1}} END {print sum}';
-
-grep 'cancelByClientId' * | wc -l
-grep 'cancelByClientId' * | awk -F '|' '{if($NF/10**6 <= 8){sum+=1}} END {print sum}'
-grep 'cancelByClientId' * | awk -F '|' '{if($NF/10**6 > 8 && $NF/10**6 <= 10 ){sum+=1}} END {print sum}';
-grep 'cancelByClientId' * | awk -F '|' '{if($NF/10**6 > 10 && $NF/10**6 <= 20 ){sum+=1}} END {print sum}';
-grep 'cancelByClientId' * | awk -F '|' '{if($NF/10**6 > 20 && $NF/10**6 <= 50 ){sum+=1}} END {print sum}';
-grep 'cancelByClientId' * | awk -F '|' '{if($NF/10**6 > 50 && $NF/10**6 <= 100 ){sum+=1}} END {print sum}';
-grep 'cancelByClientId' * | awk -F '|' '{if($NF/10**6 > 100 ){sum+=1}} END {print sum}';
-
-grep 'cancelByOrderId' * | wc -l
-grep 'cancelByOrderId' * | awk -F '|' '{if($NF/10**6 <= 8){sum+=1}} END {print sum}'
-grep 'cancelByOrderId' * | awk -F '|' '{if($NF/10**6 > 8 && $NF/10**6 <= 10 ){sum+=1}} END {print sum}';
-grep 'cancelByOrderId' * | awk -F '|' '{if($NF/10**6 > 10 && $NF/10**6 <= 20 ){sum+=1}} END {print sum}';
-grep 'cancelByOrderId' * | awk -F '|' '{if($NF/10**6 > 20 && $NF/10**6 <= 50 ){sum+=1}} END {print sum}';
-grep 'cancelByOrderId' * | awk -F '|' '{if($NF/10**6 > 50 && $NF/10**6 <= 100 ){sum+=1}} END {print sum}';
-grep 'cancelByOrderId' * | awk -F '|' '{if($NF/10**6 > 100 ){sum+=1}} END {print sum}';
-
-grep 'cancelByAll' * | wc -l
-grep 'cancelByAll' * | awk -F '|' '{if($NF/10**6 <= 8){sum+=1}} END {print sum}'
-grep 'cancelByAll' * | awk -F '|' '{if($NF/10**6 > 8 && $NF/10**6 <= 10 ){sum+=1}} END {print sum}';
-grep 'cancelByAll' * | awk -F '|' '{if($NF/10**6 > 10 && $NF/10**6 <= 20 ){sum+=1}} END {print sum}';
-grep 'cancelByAll' * | awk -F '|' '{if($NF/10**6 > 20 && $NF/10**6 <= 50 ){sum+=1}} END {print sum}';
-grep 'cancelByAll' * | awk -F '|' '{if($NF/10**6 > 50 && $NF/10**6 <= 100 ){sum+=1}} END {print sum}';
-grep 'cancelByAll' * | awk -F '|' '{if($NF/10**6 > 100 ){sum+=1}} END {print sum}';
\ No newline at end of file
+# 统计 probe 策略日志中的行情延迟（ms）和下单、撤单耗时（ns）的分布
+# 用法: stat.sh <日志文件>
+log=${1:?usage: stat.sh <log file>}
+
+# 行情延迟，日志格式是 消息类型|本地时间|消息时间|延迟
+for msgType in futuresBookTicker deliveryBookTicker spotBookTicker futuresDepth deliveryDepth spotDepth; do
+	echo "$msgType"
+	awk -F "\t" '{print $3}' "$log" | grep "^$msgType|" | awk -F '|' '
+		{total+=1}
+		$NF<=3 {a+=1}
+		$NF>3 && $NF<=10 {b+=1}
+		$NF>10 && $NF<=100 {c+=1}
+		$NF>100 && $NF<=500 {d+=1}
+		$NF>500 && $NF<=1000 {e+=1}
+		$NF>1000 {f+=1}
+		END {printf "total=%d <=3:%d <=10:%d <=100:%d <=500:%d <=1000:%d >1000:%d\n", total, a, b, c, d, e, f}'
+done
+
+# 接口耗时，日志格式是 接口|耗时，见 common.TimeCost
+for remark in placeBatchOrders placeLimitOrder cancelByAll; do
+	echo "$remark"
+	awk -F "\t" '{print $3}' "$log" | grep "^$remark|" | awk -F '|' '
+		{total+=1; ms=$NF/1000000}
+		ms<=8 {a+=1}
+		ms>8 && ms<=10 {b+=1}
+		ms>10 && ms<=20 {c+=1}
+		ms>20 && ms<=50 {d+=1}
+		ms>50 && ms<=100 {e+=1}
+		ms>100 {f+=1}
+		END {printf "total=%d <=8ms:%d <=10ms:%d <=20ms:%d <=50ms:%d <=100ms:%d >100ms:%d\n", total, a, b, c, d, e, f}'
+done
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cex/client"
+	"cex/client/recorder"
+	"cex/client/simulator"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"time"
+)
+
+var marketRecorder *recorder.Recorder
+var replayPlayer *recorder.Player
+
+// 录制所有websocket消息，每秒刷一次盘
+func InitRecorder(cfg *config.Config) {
+	rec, err := recorder.NewRecorder(cfg.RecordPath)
+	if err != nil {
+		logger.Fatal("create recorder error, path=%s, message is %s", cfg.RecordPath, err.Error())
+	}
+	marketRecorder = rec
+	go common.Timer(1*time.Second, rec.Flush)
+}
+
+// 回放时不连接币安，下单使用模拟交易所，模拟交易所的深度由回放的bookTicker驱动
+func InitReplay(cfg *config.Config) {
+	cfg.Simulated = true
+	replayPlayer = recorder.NewPlayer(cfg.ReplayPath, cfg.ReplaySpeed)
+}
+
+// 开始回放，回放结束后退出进程
+func StartReplay() {
+	go func() {
+		logger.Info("start replay %s, speed=%f", cfg.ReplayPath, cfg.ReplaySpeed)
+		err := replayPlayer.Play()
+		if err != nil && err != recorder.ErrPlayerStopped {
+			logger.Error("replay %s error, message is %s", cfg.ReplayPath, err.Error())
+		}
+		logger.Info("replay %s finished", cfg.ReplayPath)
+		ExitProcess()
+	}()
+}
+
+func StopRecorder() {
+	if marketRecorder == nil {
+		return
+	}
+	err := marketRecorder.Close()
+	if err != nil {
+		logger.Error("close recorder error, message is %s", err.Error())
+	}
+}
+
+// 配置了录制时，先录制再交给handler处理
+func recordPriceHandler(stream string, handler client.PriceProcessHandler) client.PriceProcessHandler {
+	if marketRecorder == nil {
+		return handler
+	}
+	return marketRecorder.WrapPriceHandler(stream, handler)
+}
+
+func recordOrderHandler(stream string, handler client.OrderProcessHandler) client.OrderProcessHandler {
+	if marketRecorder == nil {
+		return handler
+	}
+	return marketRecorder.WrapOrderHandler(stream, handler)
+}
+
+// 回放的bookTicker同步到模拟交易所的深度，保证挂单和对冲单能按回放时的价格撮合
+func replayPriceHandler(exchange *simulator.Exchange, handler client.PriceProcessHandler) client.PriceProcessHandler {
+	return func(resp *client.PriceWSResponse) {
+		var bids, asks []simulator.Level
+		for _, item := range resp.Items {
+			if item.Direction == "buy" {
+				bids = append(bids, simulator.Level{Price: item.Price, Volume: item.Volume})
+			} else if item.Direction == "sell" {
+				asks = append(asks, simulator.Level{Price: item.Price, Volume: item.Volume})
+			}
+		}
+		if len(bids) > 0 && len(asks) > 0 {
+			exchange.UpdateBook(resp.Symbol, bids, asks)
+		}
+		handler(resp)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"cex/config"
+	"cex/engine"
+	"cex/strategy/maker"
+
+	// 注册其它策略
+	_ "cex/strategy/calendar"
+	_ "cex/strategy/probe"
+)
+
+// 默认的策略
+const defaultStrategy = maker.Name
+
+var strategyEngine *engine.Engine
+
+// 按配置创建策略，交易接口需要先初始化
+func InitStrategy(cfg *config.Config) {
+	name := cfg.Strategy
+	if name == "" {
+		name = defaultStrategy
+	}
+	var err error
+	strategyEngine, err = engine.New(name, cfg, &ctxt, &orderHandler)
+	if err != nil {
+		panic(err)
+	}
+	strategyEngine.Init()
+}
+
+// 用户数据流重连之后核对订单和持仓，漏掉的成交交给策略
+func ReconcileUserData() {
+	orderHandler.Reconcile(strategyEngine.ReconcileFill)
+}
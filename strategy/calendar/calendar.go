@@ -0,0 +1,251 @@
+// 跨期价差策略
+package calendar
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"cex/engine"
+	"cex/trading"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+func init() {
+	engine.Register("calendar_spread", func() engine.Strategy { return &calendarSpread{basis: map[string]float64{}} })
+}
+
+// 跨期价差，季度合约按永续合约的价格加上基差挂单，或者反过来
+// 挂单成交后用对冲合约的市价单对冲，不使用现货
+type calendarSpread struct {
+	cfg    *config.Config
+	ctxt   *trading.Context
+	orders *trading.OrderHandler
+
+	mutex sync.Mutex
+	basis map[string]float64 // 挂单的合约 => 基差的指数移动平均，挂单合约的中间价 / 对冲合约的中间价 - 1
+}
+
+func (strategy *calendarSpread) Name() string {
+	return "calendar_spread"
+}
+
+func (strategy *calendarSpread) Init(e *engine.Engine) {
+	strategy.cfg = e.Config()
+	strategy.ctxt = e.Context()
+	strategy.orders = e.Orders()
+	cfg, ctxt := strategy.cfg, strategy.ctxt
+	if len(cfg.CalendarPairs) == 0 {
+		panic("strategy calendar_spread needs CalendarPairs")
+	}
+	for symbol, hedgeSymbol := range cfg.CalendarPairs {
+		if !common.InArray(symbol, ctxt.Symbols) || !common.InArray(hedgeSymbol, ctxt.Symbols) {
+			panic(fmt.Sprintf("calendar pair %s => %s is not in Symbols", symbol, hedgeSymbol))
+		}
+		if cfg.SymbolConfigs[symbol].BaseAsset != cfg.SymbolConfigs[hedgeSymbol].BaseAsset {
+			panic(fmt.Sprintf("calendar pair %s => %s has different base assets", symbol, hedgeSymbol))
+		}
+	}
+	// 每100ms更新一次基差
+	e.AddTimer("updateBasis", 100*time.Millisecond)
+	// 每1s更新一遍挂单
+	e.AddTimer("updateQuotes", 1*time.Second)
+	if !strategy.orders.Backtesting {
+		// 每5s检查一次对冲订单是否已经成交
+		e.AddTimer("checkHedges", 5*time.Second)
+	}
+}
+
+// 只更新价格，挂单由 updateQuotes 调整
+func (strategy *calendarSpread) OnPrice(product string, resp *client.PriceWSResponse) {
+	if product == engine.ProductDelivery {
+		strategy.ctxt.UpdateDeliveryPrice(resp)
+		return
+	}
+	bidPrice, bidVolume, askPrice, askVolume := trading.GetBookTicker(resp)
+	strategy.ctxt.UpdatePrice(resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, common.GetTimestampInMS(), product)
+}
+
+// 对冲单和挂单都在币本位，通过 ClientOrderID 区分
+func (strategy *calendarSpread) OnOrderUpdate(product string, resp *client.OrderWSResponse) {
+	if product != engine.ProductDelivery {
+		return
+	}
+	// 对冲单不在挂单的 orderbook 中，持仓变化通过 ACCOUNT_UPDATE 更新
+	if _, ok := common.ParseHedgeClientOrderID(resp.Order.ClientOrderID); ok {
+		strategy.orders.HandleHedgeOrder(resp)
+		return
+	}
+	strategy.orders.HandleDeliveryOrder(resp, strategy.hedgeFill)
+}
+
+// 挂单合约漏掉的成交和成交消息一样对冲
+// 对冲合约的持仓变化来自断线期间成交的对冲单，已经对冲过，不处理
+func (strategy *calendarSpread) OnReconcileFill(symbol string, orderType string, volume float64) {
+	if _, ok := strategy.cfg.CalendarPairs[symbol]; !ok {
+		logger.Info("calendar %s %s %f is filled by hedge orders, skip it", symbol, orderType, volume)
+		return
+	}
+	strategy.hedgeFill(symbol, orderType, volume, "reconcile")
+}
+
+func (strategy *calendarSpread) OnTimer(name string) {
+	switch name {
+	case "updateBasis":
+		strategy.updateBasis()
+	case "updateQuotes":
+		for symbol, hedgeSymbol := range strategy.cfg.CalendarPairs {
+			strategy.updateQuotes(symbol, hedgeSymbol)
+		}
+	case "checkHedges":
+		strategy.orders.CheckHedges()
+	}
+}
+
+func (strategy *calendarSpread) OnAccount(accounts map[string]*client.Account) {
+}
+
+// 盘口的中间价，价格没有准备好时返回0
+func (strategy *calendarSpread) getDeliveryMidPrice(symbol string) float64 {
+	symbolContext := strategy.ctxt.GetSymbolContext(symbol)
+	if symbolContext.BidPrice < strategy.cfg.MinAccuracy || symbolContext.AskPrice < strategy.cfg.MinAccuracy {
+		return 0
+	}
+	return (symbolContext.BidPrice + symbolContext.AskPrice) / 2
+}
+
+func (strategy *calendarSpread) updateBasis() {
+	strategy.mutex.Lock()
+	defer strategy.mutex.Unlock()
+	cfg, ctxt := strategy.cfg, strategy.ctxt
+	for symbol, hedgeSymbol := range cfg.CalendarPairs {
+		if ctxt.GetSymbolContext(symbol).Risk != 0 || ctxt.GetSymbolContext(hedgeSymbol).Risk != 0 {
+			continue
+		}
+		mid, hedgeMid := strategy.getDeliveryMidPrice(symbol), strategy.getDeliveryMidPrice(hedgeSymbol)
+		if mid == 0 || hedgeMid == 0 {
+			continue
+		}
+		latest := mid/hedgeMid - 1
+		basis, ok := strategy.basis[symbol]
+		if !ok {
+			basis = latest
+		} else {
+			basis = cfg.CalendarBasisAlpha*latest + (1-cfg.CalendarBasisAlpha)*basis
+		}
+		strategy.basis[symbol] = basis
+	}
+}
+
+func (strategy *calendarSpread) getBasis(symbol string) (float64, bool) {
+	strategy.mutex.Lock()
+	defer strategy.mutex.Unlock()
+	basis, ok := strategy.basis[symbol]
+	return basis, ok
+}
+
+// 每边保持一个挂单，价格偏离目标价格超过 MinDeltaRate 时撤单，下一轮重新挂
+// 买单成交后在对冲合约上按买一价卖出，所以买单的公允价格用对冲合约的买一价，卖单反过来
+func (strategy *calendarSpread) updateQuotes(symbol string, hedgeSymbol string) {
+	cfg, ctxt, orderHandler := strategy.cfg, strategy.ctxt, strategy.orders
+	symbolContext, hedgeContext := ctxt.GetSymbolContext(symbol), ctxt.GetSymbolContext(hedgeSymbol)
+	if symbolContext.Risk != 0 || hedgeContext.Risk != 0 {
+		return
+	}
+	basis, ok := strategy.getBasis(symbol)
+	if !ok || strategy.getDeliveryMidPrice(symbol) == 0 || strategy.getDeliveryMidPrice(hedgeSymbol) == 0 {
+		return
+	}
+	position := ctxt.Accounts.GetAccount(cfg.Exchange, cfg.SwapType).GetPositionsInfo(symbol).Position
+	maxPosition := orderHandler.GetMaxPosition(symbol)
+
+	// post only 的挂单不能穿过盘口
+	buyPrice := math.Min(hedgeContext.BidPrice*(1+basis)*(1-cfg.CalendarSpread), symbolContext.BidPrice)
+	sellPrice := math.Max(hedgeContext.AskPrice*(1+basis)*(1+cfg.CalendarSpread), symbolContext.AskPrice)
+	logger.Debug("calendar %s => %s, basis=%f, buyPrice=%f, sellPrice=%f, position=%f",
+		symbol, hedgeSymbol, basis, buyPrice, sellPrice, position)
+
+	var orders []*common.Order
+	buySize, sellSize := orderHandler.GetOrderSize(symbol, 1, buyPrice), orderHandler.GetOrderSize(symbol, 1, sellPrice)
+	if order := strategy.updateQuote(orderHandler.BuyOrders[symbol], buyPrice, position+buySize <= maxPosition); order != nil {
+		order.Symbol, order.OrderType, order.OrderVolume = symbol, "buy", buySize
+		orders = append(orders, order)
+	}
+	if order := strategy.updateQuote(orderHandler.SellOrders[symbol], sellPrice, position-sellSize >= -maxPosition); order != nil {
+		order.Symbol, order.OrderType, order.OrderVolume = symbol, "sell", sellSize
+		orders = append(orders, order)
+	}
+	orderHandler.PlaceOrders(orders)
+}
+
+// 撤销价格不合适的挂单，没有挂单时返回需要新挂的订单
+func (strategy *calendarSpread) updateQuote(orderBook *common.OrderBook, price float64, allowed bool) *common.Order {
+	var cancelOrders []*common.Order
+	orderBook.Mutex.RLock()
+	size := orderBook.Size()
+	for _, order := range orderBook.Data {
+		if order.Status == common.CANCEL {
+			continue
+		}
+		if !allowed || math.Abs(order.OrderPrice-price)/price > strategy.cfg.MinDeltaRate {
+			cancelOrders = append(cancelOrders, order)
+		}
+	}
+	orderBook.Mutex.RUnlock()
+	if len(cancelOrders) > 0 {
+		strategy.orders.CancelOrdersByClientID(cancelOrders)
+	}
+	// 撤单还没有确认时不挂新单
+	if size > 0 || !allowed {
+		return nil
+	}
+	return &common.Order{OrderPrice: price}
+}
+
+// 挂单成交后用对冲合约的市价单对冲，两个合约每张的价值不同时按价值换算张数
+// 换算后不够1张的部分记在 HedgeLedger 中，累计够1张之后再对冲
+func (strategy *calendarSpread) hedgeFill(symbol string, orderType string, volume float64, clientOrderID string) {
+	cfg, ctxt, orderHandler := strategy.cfg, strategy.ctxt, strategy.orders
+	if cfg.FunctionHedge != 1 {
+		return
+	}
+	hedgeSymbol, ok := cfg.CalendarPairs[symbol]
+	if !ok {
+		logger.Warn("calendar %s is not a quoted symbol, skip hedge", symbol)
+		return
+	}
+	symbolCfg, hedgeCfg := cfg.SymbolConfigs[symbol], cfg.SymbolConfigs[hedgeSymbol]
+	hedgeOrderType := common.GetHedgeOrderType(orderType)
+	contracts := orderHandler.HedgeLedger.SettleContracts(hedgeSymbol,
+		trading.SignedHedgeAmount(hedgeOrderType, volume*float64(symbolCfg.Cont)/float64(hedgeCfg.Cont)))
+	if contracts == 0 {
+		logger.Info("calendar hedge %s residual %f is less than 1 contract, wait for more fills",
+			hedgeSymbol, orderHandler.HedgeLedger.ContractResidual(hedgeSymbol))
+		return
+	}
+	// 累计的张数可能和这次成交的方向相反
+	hedgeOrderType = "buy"
+	if contracts < 0 {
+		hedgeOrderType = "sell"
+	}
+	hedgeContext := ctxt.GetSymbolContext(hedgeSymbol)
+	referencePrice := hedgeContext.BidPrice
+	if hedgeOrderType == "buy" {
+		referencePrice = hedgeContext.AskPrice
+	}
+
+	var order common.Order
+	order.Exchange = "Binance"
+	order.OrderType = hedgeOrderType
+	order.OrderPrice = referencePrice
+	order.OrderVolume = math.Abs(contracts)
+	order.Symbol = hedgeSymbol
+	order.ClientOrderID = common.GetHedgeClientOrderID(clientOrderID)
+	order.BaseAsset = hedgeCfg.BaseAsset
+	// 币本位的数量是张数
+	order.Precision = [2]int{0, hedgeCfg.Precision[1]}
+	orderHandler.PlaceHedgeOrder(&order, trading.HedgeVenueDelivery)
+}
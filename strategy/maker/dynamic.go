@@ -0,0 +1,226 @@
+package maker
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"cex/volatility"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+)
+
+// 波动估计的默认配置，和原来 100ms 采样、3000个价格的窗口一致
+const (
+	defaultVolatilityWindow         = 300 * 1000
+	defaultVolatilitySampleInterval = 100
+)
+
+// 采样价格每10s保存一次，单位：ms
+const volatilitySaveInterval = 10 * 1000
+
+// 预热使用的K线周期，单位：ms
+const (
+	warmStartKlineInterval   = "1m"
+	warmStartKlineIntervalMS = 60 * 1000
+)
+
+type DynamicConfig struct {
+	Params                 config.QuoteParams // 交易对的挂单参数
+	Volatility             *volatility.Sampler
+	Spread                 float64 // 最近一次估计的波动，数据不够时为0
+	AdjustedGapSize        float64
+	AdjustedForgivePercent float64
+}
+
+func (strategy *deliveryMaker) initDynamicConfigs() {
+	cfg := strategy.cfg
+	estimator := cfg.VolatilityEstimator
+	if estimator == "" {
+		estimator = volatility.Range
+	}
+	window := cfg.VolatilityWindow
+	if window <= 0 {
+		window = defaultVolatilityWindow
+	}
+	interval := cfg.VolatilitySampleInterval
+	if interval <= 0 {
+		interval = defaultVolatilitySampleInterval
+	}
+	strategy.volatilityWindow = window
+	strategy.dynamicConfigs = map[string]*DynamicConfig{}
+	for _, symbol := range cfg.Symbols {
+		volatilityEstimator, err := volatility.New(estimator, window, interval)
+		if err != nil {
+			panic(err)
+		}
+		params := cfg.GetQuoteParams(symbol)
+		dynamicConfig := DynamicConfig{
+			Params:                 params,
+			Volatility:             volatility.NewSampler(volatilityEstimator, window, interval, window/10),
+			AdjustedForgivePercent: params.ForgivePercent,
+		}
+		strategy.dynamicConfigs[symbol] = &dynamicConfig
+	}
+	logger.Info("volatility estimator=%s, window=%dms, interval=%dms", estimator, window, interval)
+
+	// 回测的时间是模拟的，不能用当前的历史价格预热
+	if !strategy.orders.Backtesting {
+		strategy.warmStartDynamicConfigs(window)
+	}
+}
+
+func (strategy *deliveryMaker) getDynamicConfig(symbol string) *DynamicConfig {
+	dynamicConfig := strategy.dynamicConfigs[symbol]
+	return dynamicConfig
+}
+
+// 先恢复上次保存的采样价格，再用K线补齐到现在，重启后不需要重新积累窗口
+func (strategy *deliveryMaker) warmStartDynamicConfigs(window int64) {
+	timestamp := common.GetTimestampInMS()
+	saved := loadVolatilityState(strategy.cfg.VolatilityStatePath)
+	klineClient, ok := strategy.orders.DeliveryOrderClient.(client.KlineClient)
+	for symbol, dynamicConfig := range strategy.dynamicConfigs {
+		restored := dynamicConfig.Volatility.Seed(recentSamples(saved[symbol], timestamp-window))
+		seeded := 0
+		if ok {
+			klines, err := klineClient.GetKlines(symbol, warmStartKlineInterval, int(window/warmStartKlineIntervalMS)+2)
+			if err == nil {
+				var samples []volatility.Sample
+				for _, kline := range klines {
+					samples = append(samples, klineSamples(kline, timestamp)...)
+				}
+				seeded = dynamicConfig.Volatility.Seed(recentSamples(samples, timestamp-window))
+			}
+		}
+		value, ready := dynamicConfig.Volatility.Value(timestamp)
+		logger.Info("Op=VolatilityWarmStart, Symbol=%s, Restored=%d, Klines=%d, Ready=%t, Spread=%f",
+			symbol, restored, seeded, ready, value)
+	}
+}
+
+// 时间晚于 since 的采样
+func recentSamples(samples []volatility.Sample, since int64) []volatility.Sample {
+	var recent []volatility.Sample
+	for _, sample := range samples {
+		if sample.Timestamp > since {
+			recent = append(recent, sample)
+		}
+	}
+	return recent
+}
+
+// 一根K线拆成开、高、低、收四个价格，平均分布在K线的时间内，阳线先到最低价，阴线先到最高价
+// 还没有结束的K线按当前时间结束
+func klineSamples(kline client.Kline, timestamp int64) []volatility.Sample {
+	end := kline.CloseTime
+	if end > timestamp {
+		end = timestamp
+	}
+	step := (end - kline.OpenTime) / 3
+	if step <= 0 {
+		return []volatility.Sample{{Timestamp: kline.OpenTime, Price: kline.Close}}
+	}
+	first, second := kline.High, kline.Low
+	if kline.Close >= kline.Open {
+		first, second = kline.Low, kline.High
+	}
+	return []volatility.Sample{
+		{Timestamp: kline.OpenTime, Price: kline.Open},
+		{Timestamp: kline.OpenTime + step, Price: first},
+		{Timestamp: kline.OpenTime + 2*step, Price: second},
+		{Timestamp: end, Price: kline.Close},
+	}
+}
+
+// 读取保存的采样价格，symbol => 采样，文件不存在或者损坏时从头积累
+func loadVolatilityState(path string) map[string][]volatility.Sample {
+	state := map[string][]volatility.Sample{}
+	if path == "" {
+		return state
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state
+	}
+	if err != nil {
+		logger.Warn("load volatility state failed, message is %s", err.Error())
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		logger.Warn("load volatility state failed, message is %s", err.Error())
+		return map[string][]volatility.Sample{}
+	}
+	return state
+}
+
+// 保存窗口内的采样价格，写入临时文件再重命名
+func (strategy *deliveryMaker) saveVolatilityState() {
+	cfg := strategy.cfg
+	if cfg.VolatilityStatePath == "" || strategy.orders.Backtesting {
+		return
+	}
+	strategy.dynamicMutex.Lock()
+	state := map[string][]volatility.Sample{}
+	for symbol, dynamicConfig := range strategy.dynamicConfigs {
+		state[symbol] = dynamicConfig.Volatility.Samples()
+	}
+	strategy.dynamicMutex.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		logger.Error("save volatility state failed, message is %s", err.Error())
+		return
+	}
+	tmpPath := cfg.VolatilityStatePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		logger.Error("save volatility state failed, message is %s", err.Error())
+		return
+	}
+	if err := os.Rename(tmpPath, cfg.VolatilityStatePath); err != nil {
+		logger.Error("save volatility state failed, message is %s", err.Error())
+	}
+}
+
+func (strategy *deliveryMaker) updateDynamicConfigs() {
+	strategy.dynamicMutex.Lock()
+	for symbol, dynamicConfig := range strategy.dynamicConfigs {
+		strategy.updateDynamicConfig(symbol, dynamicConfig)
+	}
+	strategy.dynamicMutex.Unlock()
+
+	timestamp := common.GetTimestampInMS()
+	if timestamp-strategy.lastVolatilitySaveTime >= volatilitySaveInterval {
+		strategy.lastVolatilitySaveTime = timestamp
+		strategy.saveVolatilityState()
+	}
+}
+
+func (strategy *deliveryMaker) updateDynamicConfig(symbol string, dynamicConfig *DynamicConfig) {
+	cfg := strategy.cfg
+	gapSizePercent := dynamicConfig.Params.GapSizePercent
+	forgivePercent := dynamicConfig.Params.ForgivePercent
+
+	symbolContext := strategy.ctxt.GetSymbolContext(symbol)
+	if symbolContext == nil || symbolContext.BidPrice < cfg.MinAccuracy {
+		return
+	}
+	logger.Debug("DynamicConfig Symbol: %s, SymbolContext: %+v", symbol, symbolContext)
+	timestamp := common.GetTimestampInMS()
+	dynamicConfig.Volatility.Update(timestamp, symbolContext.BidPrice)
+	gapSize := gapSizePercent * symbolContext.BidPrice
+
+	spread, ok := dynamicConfig.Volatility.Value(timestamp)
+	dynamicConfig.Spread = spread
+	if !ok {
+		dynamicConfig.AdjustedGapSize = gapSize
+		dynamicConfig.AdjustedForgivePercent = forgivePercent
+	} else {
+		dynamicConfig.AdjustedGapSize = gapSize + gapSize*spread*dynamicConfig.Params.SpreadTimes
+		dynamicConfig.AdjustedForgivePercent = forgivePercent - (math.Pow((spread/cfg.ExponentBaseDenominator), cfg.ExponentPower))/cfg.Denominator
+	}
+	logger.Debug("DynamicConfig Symbol: %s, Spread: %f, AdjustedGapSize: %f, AdjustedForgivePercent: %f, Ready: %t",
+		symbol, spread, dynamicConfig.AdjustedGapSize, dynamicConfig.AdjustedForgivePercent, ok)
+}
@@ -0,0 +1,495 @@
+package maker
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"math"
+	"sort"
+)
+
+// 取消价格不合适的订单
+func (strategy *deliveryMaker) cancelOrders(symbol string) {
+	timestamp := common.GetTimestampInMS()
+	symbolContext := strategy.ctxt.GetSymbolContext(symbol)
+	// 每200ms取消2次
+	if symbolContext == nil || timestamp-symbolContext.LastCancelTime < 200 {
+		return
+	}
+
+	cancelOrders := []*common.Order{}
+
+	dynamicConfig := strategy.getDynamicConfig(symbol)
+	account := strategy.ctxt.Accounts.GetAccount(strategy.cfg.Exchange, strategy.cfg.SwapType)
+	position := account.GetPositionsInfo(symbol)
+	// buy orders
+	orderBook := strategy.orders.BuyOrders[symbol]
+	orderBook.Mutex.RLock()
+	for i := 0; i < len(orderBook.Data); i++ {
+		order := orderBook.Data[i]
+		if order.Status != common.NEW && order.Status != common.CREATE && order.Status != common.CREATED {
+			continue
+		}
+		// 如果订单价格离盘口的距离比较远，暂时不考虑取消
+		gapSize := symbolContext.BidPrice - order.OrderPrice
+		logger.Debug("===orderPrice:%.2f, deliveryBidPrice:%.2f, gap: %.6f, gapSize>AdjustedGapSize: %b ",
+			order.OrderPrice, symbolContext.BidPrice, gapSize, gapSize > dynamicConfig.AdjustedGapSize)
+		if gapSize > dynamicConfig.AdjustedGapSize {
+			continue
+		}
+
+		// 判断如果当前币本位bid价格和现货的ask价格的价差，如果手续费返点cover不住，就取消。
+		// 加一个系数K，当仓位过高时，可以接受亏一些出货
+		spotPriceItem := strategy.ctxt.GetPriceItem(strategy.cfg.Exchange, symbol, "spot")
+		profitRatio := (spotPriceItem.BidPrice - symbolContext.AskPrice) / symbolContext.AskPrice
+		positionRatio := position.PositionAbs / strategy.orders.GetMaxPosition(symbol)
+		threashodl := dynamicConfig.Params.Commission - dynamicConfig.Params.CancelShift*positionRatio - dynamicConfig.Params.Loss
+		// 最多能接受亏掉补偿手续费在家个让利回吐仓位
+		if profitRatio < threashodl {
+			cancelOrders = append(cancelOrders, order)
+			logger.Info("===CancelOrder: index: %d, askPrice: %.2f, orderPrice: %.2f, spotBidPrice: %.2f, profitRatio: %.6f, threashold: %.6f, positionRatio: %.2f",
+				i, symbolContext.AskPrice, order.OrderPrice, spotPriceItem.BidPrice, profitRatio, threashodl, positionRatio)
+		}
+	}
+	orderBook.Mutex.RUnlock()
+
+	// sell orders
+	orderBook = strategy.orders.SellOrders[symbol]
+	orderBook.Mutex.RLock()
+	for i := 0; i < len(orderBook.Data); i++ {
+		order := orderBook.Data[i]
+		if order.Status != common.NEW && order.Status != common.CREATE && order.Status != common.CREATED {
+			continue
+		}
+
+		// 如果订单价格离盘口的距离比较远，暂时不考虑取消
+		gapSize := order.OrderPrice - symbolContext.AskPrice
+		logger.Debug("===orderPrice:%.2f, deliveryAskPrice:%.2f, gap: %.6f, gapSize>AdjustedGapSize: %b ",
+			order.OrderPrice, symbolContext.BidPrice, gapSize, gapSize > dynamicConfig.AdjustedGapSize)
+		if gapSize > dynamicConfig.AdjustedGapSize {
+			continue
+		}
+
+		// 判断如果当前币本位ask价格和现货的bid价格的价差，如果手续费返点cover不住，就取消。
+		// 加一个系数K，当仓位过高时，可以接受亏一些出货
+		spotPriceItem := strategy.ctxt.GetPriceItem(strategy.cfg.Exchange, symbol, "spot")
+		profitRatio := (symbolContext.BidPrice - spotPriceItem.AskPrice) / symbolContext.BidPrice
+		positionRatio := position.PositionAbs / strategy.orders.GetMaxPosition(symbol)
+		threashodl := dynamicConfig.Params.Commission - dynamicConfig.Params.CancelShift*positionRatio - dynamicConfig.Params.Loss
+		// 最多能接受亏掉补偿手续费在家个让利回吐仓位
+		if profitRatio < threashodl {
+			cancelOrders = append(cancelOrders, order)
+			logger.Info("===CancelOrder: index: %d, bidPrice: %.2f, orderPrice: %.2f, spotAskPrice: %.2f, lossRatio: %.6f, threashold: %.6f, positionRatio: %.2f",
+				i, symbolContext.BidPrice, order.OrderPrice, spotPriceItem.AskPrice, profitRatio, threashodl, positionRatio)
+		}
+	}
+	orderBook.Mutex.RUnlock()
+
+	logger.Debug("CancelOrders: %d", len(cancelOrders))
+	//strategy.orders.CancelOrdersByClientID(cancelOrders)
+	// 改成cancelAll
+	if len(cancelOrders) > 0 {
+		strategy.orders.CancelAllOrdersWithSymbol(symbol)
+		symbolContext.LastCancelTime = timestamp
+	}
+}
+
+func (strategy *deliveryMaker) updateOrders() {
+	// 频率限制的额度不多时，留给撤单和对冲
+	if headroom := strategy.orders.GetRateLimitHeadroom(); headroom < strategy.cfg.MinRateLimitHeadroom {
+		logger.Warn("rate limit headroom %.2f is less than %.2f, skip placing orders", headroom, strategy.cfg.MinRateLimitHeadroom)
+		return
+	}
+	account := strategy.ctxt.Accounts.GetAccount(strategy.cfg.Exchange, strategy.cfg.SwapType)
+	orders := []*common.Order{}
+	buyOrderBookSize, sellOrderBookSize := 0, 0
+
+	// buy orders
+	for symbol, orderBook := range strategy.orders.BuyOrders {
+		// 单方向最多持仓的张数，每档的张数见 GetOrderSize
+		maxPosition := strategy.orders.GetMaxPosition(symbol)
+		// 当前仓位，挂单随持仓量变化，long仓越多，越容易挂ask单，越难挂bid单，反之则反。
+		position := account.GetPositionsInfo(symbol)
+
+		symbolContext := strategy.ctxt.GetSymbolContext(symbol)
+		spotPriceItem := strategy.ctxt.GetPriceItem(strategy.cfg.Exchange, symbol, "spot")
+		futuresPriceItem := strategy.ctxt.GetPriceItem(strategy.cfg.Exchange, symbol, "futures")
+
+		if symbolContext.Risk != 0 || symbol == "BNBUSD_PERP" {
+			continue
+		}
+
+		if spotPriceItem == nil || futuresPriceItem == nil || symbolContext.BidPrice < strategy.cfg.MinAccuracy {
+			continue
+		}
+
+		dynamicConfig := strategy.getDynamicConfig(symbol)
+		// 按挂单模型根据持仓调整阶梯
+		quote := strategy.getQuote(symbol, position, symbolContext, dynamicConfig)
+		ratio := quote.Ratio
+		if quote.BidOffset > 0 {
+			strategy.cancelInsideOrders("buy", orderBook, symbolContext.BidPrice-quote.BidOffset-dynamicConfig.Params.GapSizeK*dynamicConfig.AdjustedGapSize, dynamicConfig)
+		}
+
+		tempOrderNum, tmpCreateOrderNum := dynamicConfig.Params.MaxOrderNum, 0
+		createdStart, targetPrices, targetSizes := len(orders), []float64{}, []float64{}
+		orderBook.Mutex.RLock()
+		buyOrderBookSize = orderBook.Size()
+		for i := 1; i <= tempOrderNum; i++ {
+			buyPrice := symbolContext.BidPrice - quote.BidOffset - float64(i)*dynamicConfig.Params.GapSizeK*dynamicConfig.AdjustedGapSize
+			size := strategy.orders.GetOrderSize(symbol, i, symbolContext.BidPrice)
+			inRange := strategy.isInRange(i, buyPrice, "buy", orderBook, dynamicConfig)
+
+			// 根据持仓获得修正后的buyPrice, 根据近期的波动，获得修正好的现货和U本位合约的buyPrice
+
+			adjustedDeliveryBuyPrice := getAdjustedPrice(buyPrice, ratio, position.Position)
+			adjustedSpotBuyPrice := spotPriceItem.BidPrice * dynamicConfig.AdjustedForgivePercent
+			adjustedFuturesBuyPrice := futuresPriceItem.BidPrice * dynamicConfig.AdjustedForgivePercent
+			logger.Debug("index: %d, buyPrice: %.2f, ratio: %f, position: %f, condition: %s|%s|%s|%s|%s",
+				i, buyPrice, ratio, position.Position,
+				!inRange,
+				adjustedDeliveryBuyPrice < adjustedSpotBuyPrice,
+				adjustedDeliveryBuyPrice < adjustedFuturesBuyPrice,
+				position.Position+size <= maxPosition,
+				tmpCreateOrderNum <= dynamicConfig.Params.MaxOrderOneStep)
+			if adjustedDeliveryBuyPrice < adjustedSpotBuyPrice && adjustedDeliveryBuyPrice < adjustedFuturesBuyPrice &&
+				position.Position+size <= maxPosition && len(targetPrices) < dynamicConfig.Params.MaxOrderNum {
+				targetPrices = append(targetPrices, buyPrice)
+				targetSizes = append(targetSizes, size)
+			}
+			if !inRange && adjustedDeliveryBuyPrice < adjustedSpotBuyPrice &&
+				adjustedDeliveryBuyPrice < adjustedFuturesBuyPrice &&
+				position.Position+size <= maxPosition &&
+				tmpCreateOrderNum < dynamicConfig.Params.MaxOrderOneStep {
+
+				logger.Info("===position: %.2f, size: %.2f, maxPosition: %.2f", position.Position, size, maxPosition)
+				logger.Info("===CreateOrder: index: %d, num: %d, bidPrice: %.2f, adjustedDeliveryBuyPrice: %.2f, adjustedSpotBuyPrice: %.2f, adjustedFuturesBuyPrice: %.2f",
+					i, tempOrderNum, symbolContext.BidPrice, adjustedDeliveryBuyPrice, adjustedSpotBuyPrice, adjustedFuturesBuyPrice)
+				order := common.Order{Symbol: symbol, OrderType: "buy", OrderVolume: size,
+					OrderPrice: buyPrice}
+				orders = append(orders, &order)
+				tmpCreateOrderNum++
+
+			}
+
+			if !inRange && (adjustedDeliveryBuyPrice > adjustedSpotBuyPrice || buyPrice > adjustedFuturesBuyPrice) {
+				tempOrderNum++
+			}
+		}
+		orderBook.Mutex.RUnlock()
+
+		if strategy.amendLadder("buy", orderBook, targetPrices, targetSizes, dynamicConfig) {
+			orders = orders[:createdStart]
+		}
+	}
+
+	// sell orders
+	for symbol, orderBook := range strategy.orders.SellOrders {
+		// 单方向最多持仓的张数，每档的张数见 GetOrderSize
+		maxPosition := strategy.orders.GetMaxPosition(symbol)
+		// 当前仓位，挂单随持仓量变化，long仓越多，越容易挂ask单，越难挂bid单，反之则反。
+		position := account.GetPositionsInfo(symbol)
+
+		symbolContext := strategy.ctxt.GetSymbolContext(symbol)
+		spotPriceItem := strategy.ctxt.GetPriceItem(strategy.cfg.Exchange, symbol, "spot")
+		futuresPriceItem := strategy.ctxt.GetPriceItem(strategy.cfg.Exchange, symbol, "futures")
+
+		if symbolContext.Risk != 0 || symbol == "BNBUSD_PERP" {
+			continue
+		}
+		if spotPriceItem == nil || futuresPriceItem == nil || symbolContext.BidPrice < strategy.cfg.MinAccuracy {
+			continue
+		}
+
+		dynamicConfig := strategy.getDynamicConfig(symbol)
+		// 按挂单模型根据持仓调整阶梯
+		quote := strategy.getQuote(symbol, position, symbolContext, dynamicConfig)
+		ratio := quote.Ratio
+		if quote.AskOffset > 0 {
+			strategy.cancelInsideOrders("sell", orderBook, symbolContext.AskPrice+quote.AskOffset+dynamicConfig.Params.GapSizeK*dynamicConfig.AdjustedGapSize, dynamicConfig)
+		}
+
+		tempOrderNum, tmpCreateOrderNum := dynamicConfig.Params.MaxOrderNum, 0
+		createdStart, targetPrices, targetSizes := len(orders), []float64{}, []float64{}
+		orderBook.Mutex.RLock()
+		sellOrderBookSize = orderBook.Size()
+		for i := 1; i <= tempOrderNum; i++ {
+			sellPrice := symbolContext.AskPrice + quote.AskOffset + float64(i)*dynamicConfig.Params.GapSizeK*dynamicConfig.AdjustedGapSize
+			size := strategy.orders.GetOrderSize(symbol, i, symbolContext.AskPrice)
+			inRange := strategy.isInRange(i, sellPrice, "sell", orderBook, dynamicConfig)
+
+			// 根据持仓获得修正后的sellPrice
+			adjustedDeliverySellPrice := getAdjustedPrice(sellPrice, ratio, position.Position)
+			adjustedSpotSellPrice := spotPriceItem.BidPrice / dynamicConfig.AdjustedForgivePercent
+			adjustedFuturesSellPrice := futuresPriceItem.BidPrice / dynamicConfig.AdjustedForgivePercent
+			logger.Debug("index: %d, buyPrice: %.2f, ratio: %f, position: %f, condition: %s|%s|%s|%s|%s",
+				i, sellPrice, ratio, position.Position,
+				!inRange,
+				adjustedDeliverySellPrice > adjustedSpotSellPrice,
+				adjustedDeliverySellPrice > adjustedFuturesSellPrice,
+				position.Position-size >= -maxPosition,
+				tmpCreateOrderNum <= dynamicConfig.Params.MaxOrderOneStep)
+			if adjustedDeliverySellPrice > adjustedSpotSellPrice && adjustedDeliverySellPrice > adjustedFuturesSellPrice &&
+				position.Position-size >= -maxPosition && len(targetPrices) < dynamicConfig.Params.MaxOrderNum {
+				targetPrices = append(targetPrices, sellPrice)
+				targetSizes = append(targetSizes, size)
+			}
+			if !inRange && adjustedDeliverySellPrice > adjustedSpotSellPrice &&
+				adjustedDeliverySellPrice > adjustedFuturesSellPrice &&
+				position.Position-size >= -maxPosition &&
+				tmpCreateOrderNum < dynamicConfig.Params.MaxOrderOneStep {
+				logger.Info("===position: %.2f, size: %.2f, maxPosition: %.2f", position.Position, size, maxPosition)
+				logger.Info("===CreateOrder: index: %d, num: %d, askPrice: %.2f, adjustedDeliverySellPrice: %.2f, adjustedSpotSellPrice: %.2f, adjustedFuturesSellPrice: %.2f",
+					i, tempOrderNum, symbolContext.AskPrice, adjustedDeliverySellPrice, adjustedSpotSellPrice, adjustedFuturesSellPrice)
+				order := common.Order{Symbol: symbol, OrderType: "sell", OrderVolume: size,
+					OrderPrice: sellPrice}
+				orders = append(orders, &order)
+				tmpCreateOrderNum++
+			}
+
+			if !inRange && (adjustedDeliverySellPrice < adjustedSpotSellPrice || adjustedDeliverySellPrice < adjustedFuturesSellPrice) {
+				tempOrderNum++
+			}
+		}
+		orderBook.Mutex.RUnlock()
+
+		if strategy.amendLadder("sell", orderBook, targetPrices, targetSizes, dynamicConfig) {
+			orders = orders[:createdStart]
+		}
+	}
+
+	logger.Info("CreateOrders: %d, buyOrderBookSize: %d, sellOrderBookSize: %d", len(orders), buyOrderBookSize, sellOrderBookSize)
+	strategy.orders.PlaceOrders(orders)
+}
+
+// 阶梯中需要修改的一个订单，snapshot 是订单在锁内的副本
+type ladderAmendment struct {
+	order    *common.Order
+	snapshot common.Order
+	price    float64
+	volume   float64
+}
+
+// 挂单数量和目标价格数量一致时，把已有的订单改到目标价格和数量，不用撤单再重新下单，盘口不会出现空档
+// targetPrices按离盘口从近到远排列，targetSizes是对应的张数，返回true表示已经按目标价格处理，这一轮不再下新订单
+func (strategy *deliveryMaker) amendLadder(orderType string, orderBook *common.OrderBook, targetPrices []float64, targetSizes []float64, dynamicConfig *DynamicConfig) bool {
+	amendClient, ok := strategy.orders.DeliveryOrderClient.(client.AmendOrderClient)
+	if !ok || len(targetPrices) == 0 {
+		return false
+	}
+
+	// 修改请求是异步的，按锁内复制的价格和数量比较、请求，orderbook中的订单只在锁内更新
+	// 要修改的订单在锁内标记为正在修改，修改结束前下一轮不会重复修改
+	var liveOrders []*common.Order
+	snapshots := make(map[*common.Order]common.Order)
+	orderBook.Mutex.Lock()
+	for _, order := range orderBook.Data {
+		// 还在下单、撤单、修改中或者已经部分成交的订单不修改，这一轮按原来的逻辑处理
+		if (order.Status != common.CREATE && order.Status != common.CREATED) || orderBook.IsAmending(order.ClientOrderID) {
+			orderBook.Mutex.Unlock()
+			return false
+		}
+		liveOrders = append(liveOrders, order)
+		snapshots[order] = *order
+	}
+	if len(liveOrders) != len(targetPrices) {
+		orderBook.Mutex.Unlock()
+		return false
+	}
+
+	// 离盘口近的订单改到离盘口近的目标价格
+	sort.Slice(liveOrders, func(i, j int) bool {
+		if orderType == "buy" {
+			return snapshots[liveOrders[i]].OrderPrice > snapshots[liveOrders[j]].OrderPrice
+		}
+		return snapshots[liveOrders[i]].OrderPrice < snapshots[liveOrders[j]].OrderPrice
+	})
+	// 和目标价格相差不到半个间距、张数相同的订单不修改
+	tolerance := dynamicConfig.AdjustedGapSize * dynamicConfig.Params.GapSizeK / 2
+	var amendments []ladderAmendment
+	for i, order := range liveOrders {
+		snapshot, price, volume := snapshots[order], targetPrices[i], targetSizes[i]
+		if math.Abs(snapshot.OrderPrice-price) < tolerance && snapshot.OrderVolume == volume {
+			continue
+		}
+		orderBook.SetAmending(order.ClientOrderID)
+		amendments = append(amendments, ladderAmendment{order: order, snapshot: snapshot, price: price, volume: volume})
+	}
+	orderBook.Mutex.Unlock()
+
+	for _, item := range amendments {
+		item := item
+		strategy.orders.RunAsync(func() {
+			strategy.amendOrder(amendClient, orderBook, item.order, &item.snapshot, item.price, item.volume)
+		})
+	}
+	return true
+}
+
+// 修改一个订单，成功后按交易所取整后的价格和数量更新orderbook，snapshot 是订单在锁内的副本
+// 不管成功还是失败都清除正在修改的标记
+func (strategy *deliveryMaker) amendOrder(amendClient client.AmendOrderClient, orderBook *common.OrderBook, order *common.Order, snapshot *common.Order, price float64, volume float64) {
+	logger.Info("OrderDebug: op=Amend, %s, newPrice=%f, newVolume=%f", snapshot.FormatString(), price, volume)
+	amendedPrice, amendedVolume, err := amendClient.AmendOrder(snapshot, price, volume)
+	if err == nil {
+		orderBook.Amend(snapshot.ClientOrderID, amendedPrice, amendedVolume)
+		return
+	}
+	orderBook.FinishAmend(snapshot.ClientOrderID)
+	switch client.GetOrderErrorKind(err) {
+	case client.ErrorKindOrderNotFound, client.ErrorKindRateLimited:
+		// 已经成交或者撤销，等订单推送更新orderbook；限频时下一轮再修改
+	default:
+		// 修改失败的订单撤掉，下一轮重新下单
+		strategy.orders.CancelOrdersByClientID([]*common.Order{order})
+	}
+}
+
+// 撤销比挂单模型第一档更靠近盘口的订单，持仓变化后这些订单的价格已经不合适
+// 相差不到半个间距的订单不撤，避免价格小幅变化时反复撤单
+func (strategy *deliveryMaker) cancelInsideOrders(orderType string, orderBook *common.OrderBook, firstPrice float64, dynamicConfig *DynamicConfig) {
+	tolerance := dynamicConfig.AdjustedGapSize * dynamicConfig.Params.GapSizeK / 2
+	var cancelOrders []*common.Order
+	orderBook.Mutex.RLock()
+	for _, order := range orderBook.Data {
+		if order.Status == common.CANCEL {
+			continue
+		}
+		if (orderType == "buy" && order.OrderPrice > firstPrice+tolerance) ||
+			(orderType == "sell" && order.OrderPrice < firstPrice-tolerance) {
+			cancelOrders = append(cancelOrders, order)
+		}
+	}
+	orderBook.Mutex.RUnlock()
+	if len(cancelOrders) > 0 {
+		logger.Info("CancelInsideOrders: %s, firstPrice=%f, num=%d", orderType, firstPrice, len(cancelOrders))
+		strategy.orders.CancelOrdersByClientID(cancelOrders)
+	}
+}
+
+func (strategy *deliveryMaker) isInRange(loop int, price float64, offset string, orderBook *common.OrderBook, dynamicConfig *DynamicConfig) bool {
+	for _, order := range orderBook.Data {
+		if loop != 0 {
+			if price <= order.OrderPrice+dynamicConfig.AdjustedGapSize*dynamicConfig.Params.GapSizeK && price >= order.OrderPrice-dynamicConfig.AdjustedForgivePercent*dynamicConfig.Params.GapSizeK {
+				return true
+			}
+		} else {
+			if offset == "buy" {
+				if order.OrderPrice >= price {
+					return true
+				}
+			} else if offset == "sell" {
+				if order.OrderPrice <= price {
+					return true
+				}
+			}
+
+		}
+	}
+	return false
+}
+
+// 取消距离较远的订单
+func (strategy *deliveryMaker) cancelFarOrders(symbol string) {
+	timestamp := common.GetTimestampInMS()
+	// 每2s取消2次
+	symbolContext := strategy.ctxt.GetSymbolContext(symbol)
+	if symbolContext == nil || timestamp-symbolContext.LastCancelFarTime < 2000 {
+		return
+	}
+
+	cancelOrders := []*common.Order{}
+	maxOrderNum := strategy.getDynamicConfig(symbol).Params.MaxOrderNum
+
+	// buy orders
+	orderBook := strategy.orders.BuyOrders[symbol]
+	size := orderBook.Size() - maxOrderNum
+	if size > 0 {
+		orderBook.Sort()
+
+		orderBook.Mutex.RLock()
+		for i := 0; i < size; i++ {
+			cancelOrders = append(cancelOrders, orderBook.Data[i])
+		}
+		orderBook.Mutex.RUnlock()
+	}
+
+	// sell orders
+	orderBook = strategy.orders.SellOrders[symbol]
+	size = orderBook.Size() - maxOrderNum
+	if size > 0 {
+		orderBook.Sort()
+
+		orderBook.Mutex.RLock()
+		for i := orderBook.Size() - 1; orderBook.Size()-i <= size; i-- {
+			cancelOrders = append(cancelOrders, orderBook.Data[i])
+		}
+		orderBook.Mutex.RUnlock()
+	}
+
+	if len(cancelOrders) > 2 {
+		symbolContext.LastCancelFarTime = timestamp
+	}
+	logger.Debug("CancelFarOrders: %+v", cancelOrders)
+	strategy.orders.CancelOrdersByClientID(cancelOrders)
+}
+
+// 取消间距较劲的订单
+func (strategy *deliveryMaker) cancelCloseDistanceOrders(symbol string) {
+	cancelOrders := []*common.Order{}
+
+	// buy orders， 第一个和最后一个订单不做处理
+	orderBook := strategy.orders.BuyOrders[symbol]
+	dynamicConfigs := strategy.getDynamicConfig(symbol)
+	size := orderBook.Size()
+	if size > 2 {
+		orderBook.Sort()
+		orderBook.Mutex.RLock()
+
+		cursor := size - 2
+		for i := size - 3; i > 0; i-- {
+			currOrder := orderBook.Data[i]
+			prevOrder := orderBook.Data[cursor]
+			if prevOrder.OrderPrice-currOrder.OrderPrice < dynamicConfigs.AdjustedGapSize {
+				logger.Info("===buy, curOrderPrice: %.2f, prevOrderPrice: %.2f, gapSize: %.2f, adjustedGapSize: %.2f",
+					currOrder.OrderPrice, prevOrder.OrderPrice, prevOrder.OrderPrice-currOrder.OrderPrice, dynamicConfigs.AdjustedGapSize)
+				cancelOrders = append(cancelOrders, currOrder)
+			} else {
+				cursor--
+			}
+		}
+		orderBook.Mutex.RUnlock()
+	}
+	// sell orders， 第一个和最后一个订单不做处理
+	orderBook = strategy.orders.SellOrders[symbol]
+	size = orderBook.Size()
+	if size > 2 {
+		orderBook.Sort()
+		orderBook.Mutex.RLock()
+		cursor := 1
+		for i := 2; i < size-1; i++ {
+			currOrder := orderBook.Data[i]
+			prevOrder := orderBook.Data[cursor]
+			if currOrder.OrderPrice-prevOrder.OrderPrice < dynamicConfigs.AdjustedGapSize {
+				logger.Info("===sell, curOrderPrice: %.2f, prevOrderPrice: %.2f, gapSize: %.2f, adjustedGapSize: %.2f",
+					currOrder.OrderPrice, prevOrder.OrderPrice, currOrder.OrderPrice-prevOrder.OrderPrice, dynamicConfigs.AdjustedGapSize)
+				cancelOrders = append(cancelOrders, currOrder)
+			} else {
+				cursor++
+			}
+		}
+		orderBook.Mutex.RUnlock()
+	}
+
+	logger.Debug("CancelCloseDistanceOrders: %+v", cancelOrders)
+	strategy.orders.CancelOrdersByClientID(cancelOrders)
+}
+
+func getAdjustedPrice(price float64, ratio, position float64) float64 {
+	if position > 0 {
+		return price * ratio
+	} else if position < 0 {
+		return price / ratio
+	}
+	return price
+}
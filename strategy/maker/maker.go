@@ -0,0 +1,162 @@
+// 币本位做市策略
+package maker
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"cex/engine"
+	"cex/trading"
+	"sync"
+	"time"
+)
+
+// 策略名称，也是默认的策略
+const Name = "delivery_maker"
+
+func init() {
+	engine.Register(Name, func() engine.Strategy { return &deliveryMaker{} })
+}
+
+// 币本位做市，挂单成交后用现货或者U本位对冲
+type deliveryMaker struct {
+	cfg    *config.Config
+	ctxt   *trading.Context
+	orders *trading.OrderHandler
+
+	dynamicConfigs map[string]*DynamicConfig
+	// 保护 Volatility，退出时保存采样价格和定时器可能同时执行
+	dynamicMutex sync.Mutex
+	// 上一次保存采样价格的时间
+	lastVolatilitySaveTime int64
+	// 估计波动的窗口长度，单位：ms
+	volatilityWindow int64
+}
+
+func (strategy *deliveryMaker) Name() string {
+	return Name
+}
+
+func (strategy *deliveryMaker) Init(e *engine.Engine) {
+	strategy.cfg = e.Config()
+	strategy.ctxt = e.Context()
+	strategy.orders = e.Orders()
+	checkQuoteModel(strategy.cfg)
+	strategy.initDynamicConfigs()
+	// post only 被拒绝后改到离盘口一个间距的价格
+	strategy.orders.RepriceGap = func(symbol string) float64 {
+		dynamicConfig := strategy.getDynamicConfig(symbol)
+		return dynamicConfig.Params.GapSizeK * dynamicConfig.AdjustedGapSize
+	}
+
+	// 每100ms计算一遍波动参数
+	e.AddTimer("updateDynamicConfigs", 100*time.Millisecond)
+	// 每1s更新一遍订单
+	e.AddTimer("updateOrders", 1*time.Second)
+	// 每3秒钟取消距离较远的订单
+	e.AddTimer("cancelFarOrders", 3*time.Second)
+	// 每3s取消一次间距较近的订单
+	e.AddTimer("cancelCloseDistanceOrders", 3*time.Second)
+	// 每100ms检查一次限价对冲单是否超时
+	e.AddTimer("checkLimitHedges", 100*time.Millisecond)
+	// 每1s检查一次价格变化后持仓需要的对冲数量
+	e.AddTimer("checkRehedge", 1*time.Second)
+	if strategy.orders.Backtesting {
+		return
+	}
+	// 每5s检查一次对冲订单是否已经成交
+	e.AddTimer("checkHedges", 5*time.Second)
+	// 每分钟检查一次净敞口，对冲失败时补齐
+	e.AddTimer("checkDelta", 60*time.Second)
+	// 每分钟更新一次账户状态
+	e.WatchAccounts(60*time.Second, engine.ProductDelivery, engine.ProductSpot)
+}
+
+func (strategy *deliveryMaker) OnPrice(product string, resp *client.PriceWSResponse) {
+	timeStamp := common.GetTimestampInMS()
+	switch product {
+	case engine.ProductDelivery:
+		// 价格变化大于一定比例才触发更新orders
+		if strategy.ctxt.UpdateDeliveryPrice(resp) {
+			symbol := resp.Symbol
+			strategy.orders.RunAsync(func() { strategy.cancelOrders(symbol) })
+		}
+	case engine.ProductFutures:
+		if resp.MsgType != "futuresBookTicker" {
+			return
+		}
+		bidPrice, bidVolume, askPrice, askVolume := trading.GetBookTicker(resp)
+		logger.Debug("binance futures bookTicker symbol: %s, bidPrice:%f, bidVolume:%f, askPrice:%f, askVolume:%f, updateTime:%d", resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, resp.TimeStamp)
+		strategy.orders.RunAsync(func() {
+			strategy.cancelOrdersOnPriceChange(strategy.ctxt.UpdatePrice(resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, timeStamp, "futures"))
+		})
+	case engine.ProductSpot:
+		bidPrice, bidVolume, askPrice, askVolume := trading.GetBookTicker(resp)
+		strategy.orders.RunAsync(func() {
+			strategy.cancelOrdersOnPriceChange(strategy.ctxt.UpdatePrice(resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, timeStamp, "spot"))
+		})
+		logger.Debug("binance spot bookTicker symbol: %s, bidPrice:%f, bidVolume:%f, askPrice:%f, askVolume:%f, updateTime:%d", resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, resp.TimeStamp)
+	}
+}
+
+// 价格变化大于一定比例才触发更新orders
+func (strategy *deliveryMaker) cancelOrdersOnPriceChange(deliverySymbols []string) {
+	for _, deliverySymbol := range deliverySymbols {
+		strategy.cancelOrders(deliverySymbol)
+	}
+}
+
+func (strategy *deliveryMaker) OnOrderUpdate(product string, resp *client.OrderWSResponse) {
+	if product == engine.ProductDelivery {
+		strategy.orders.HandleDeliveryOrder(resp, strategy.hedgeFill)
+		return
+	}
+	strategy.orders.HandleHedgeOrder(resp)
+}
+
+// 币本位挂单成交后下单对冲
+func (strategy *deliveryMaker) hedgeFill(symbol string, orderType string, volume float64, clientOrderID string) {
+	if strategy.cfg.FunctionHedge == 1 {
+		strategy.orders.HedgeFill(symbol, orderType, volume, clientOrderID)
+	}
+}
+
+// 漏掉的成交和成交消息一样对冲
+func (strategy *deliveryMaker) OnReconcileFill(symbol string, orderType string, volume float64) {
+	strategy.hedgeFill(symbol, orderType, volume, "reconcile")
+}
+
+func (strategy *deliveryMaker) OnTimer(name string) {
+	switch name {
+	case "updateDynamicConfigs":
+		strategy.updateDynamicConfigs()
+	case "updateOrders":
+		strategy.updateOrders()
+	case "cancelFarOrders":
+		for _, symbol := range strategy.ctxt.Symbols {
+			strategy.cancelFarOrders(symbol)
+		}
+	case "cancelCloseDistanceOrders":
+		for _, symbol := range strategy.ctxt.Symbols {
+			strategy.cancelCloseDistanceOrders(symbol)
+		}
+	case "checkLimitHedges":
+		strategy.orders.CheckLimitHedges()
+	case "checkRehedge":
+		strategy.orders.CheckRehedge()
+	case "checkHedges":
+		strategy.orders.CheckHedges()
+	case "checkDelta":
+		strategy.orders.CheckDelta()
+	}
+}
+
+func (strategy *deliveryMaker) OnAccount(accounts map[string]*client.Account) {
+	strategy.ctxt.UpdateAccount(accounts[engine.ProductDelivery], accounts[engine.ProductSpot])
+}
+
+// 退出时保存采样价格，重启后继续使用
+func (strategy *deliveryMaker) OnStop() {
+	strategy.saveVolatilityState()
+}
@@ -0,0 +1,80 @@
+package maker
+
+import (
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"cex/trading"
+	"fmt"
+	"math"
+)
+
+// 挂单模型
+const (
+	QuoteModelLinear            = "linear"
+	QuoteModelAvellanedaStoikov = "avellaneda_stoikov"
+)
+
+// 挂单阶梯按持仓的调整
+// 第 i 档买单的价格 = 买一价 - BidOffset - i * 间距，卖单 = 卖一价 + AskOffset + i * 间距
+// Ratio 用来修正挂单价格，修正后的价格和现货、U本位的价格比较，决定是否挂单
+type Quote struct {
+	BidOffset float64
+	AskOffset float64
+	Ratio     float64
+}
+
+// 检查挂单模型的配置，配置错误时不能启动
+func checkQuoteModel(cfg *config.Config) {
+	switch cfg.QuoteModel {
+	case "", QuoteModelLinear:
+	case QuoteModelAvellanedaStoikov:
+		for _, symbol := range cfg.Symbols {
+			symbolCfg := cfg.SymbolConfigs[symbol]
+			if symbolCfg.RiskAversion <= 0 || symbolCfg.OrderIntensity <= 0 {
+				panic(fmt.Sprintf("quote model %s needs RiskAversion and OrderIntensity of %s", cfg.QuoteModel, symbol))
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unknown quote model %s", cfg.QuoteModel))
+	}
+}
+
+// 按配置的挂单模型计算阶梯的调整
+func (strategy *deliveryMaker) getQuote(symbol string, position *common.DeliveryPosition, symbolContext *trading.SymbolContext, dynamicConfig *DynamicConfig) Quote {
+	if strategy.cfg.QuoteModel == QuoteModelAvellanedaStoikov {
+		return strategy.getAvellanedaStoikovQuote(symbol, position, symbolContext, dynamicConfig)
+	}
+	// long仓越多，越容易挂ask单，越难挂bid单，反之则反
+	contractNum := strategy.orders.GetOrderSize(symbol, 1, symbolContext.BidPrice)
+	return Quote{Ratio: 1 + dynamicConfig.Params.TickerShift*position.PositionAbs/contractNum}
+}
+
+// Avellaneda-Stoikov 模型，价格都换算成中间价的比例
+// 保留价格 r = mid * (1 - q * γ * σ²)，最优价差 δ = mid * (γ * σ² + 2/γ * ln(1 + γ/k))
+// q 是持仓的单数（持仓 / 第一档的张数），σ² 是 QuoteHorizon 内的方差，由估计的波动按时间折算
+// 第一档的价格是 r ∓ δ/2，不会比原来的第一档更靠近盘口，持仓的影响已经体现在价格中，Ratio 为1
+func (strategy *deliveryMaker) getAvellanedaStoikovQuote(symbol string, position *common.DeliveryPosition, symbolContext *trading.SymbolContext, dynamicConfig *DynamicConfig) Quote {
+	cfg := strategy.cfg
+	symbolCfg := cfg.SymbolConfigs[symbol]
+	horizon := cfg.QuoteHorizon
+	if horizon <= 0 {
+		horizon = strategy.volatilityWindow
+	}
+	gamma, k := symbolCfg.RiskAversion, symbolCfg.OrderIntensity
+	variance := dynamicConfig.Spread * dynamicConfig.Spread * float64(horizon) / float64(strategy.volatilityWindow)
+	mid := (symbolContext.BidPrice + symbolContext.AskPrice) / 2
+	inventory := position.Position / strategy.orders.GetOrderSize(symbol, 1, mid)
+	reservationPrice := mid * (1 - inventory*gamma*variance)
+	halfSpread := mid * (gamma*variance + 2/gamma*math.Log(1+gamma/k)) / 2
+
+	gapSize := dynamicConfig.Params.GapSizeK * dynamicConfig.AdjustedGapSize
+	quote := Quote{
+		BidOffset: math.Max(0, symbolContext.BidPrice-gapSize-(reservationPrice-halfSpread)),
+		AskOffset: math.Max(0, reservationPrice+halfSpread-(symbolContext.AskPrice+gapSize)),
+		Ratio:     1,
+	}
+	logger.Debug("Quote Symbol: %s, inventory: %f, variance: %.10f, reservationPrice: %f, halfSpread: %f, bidOffset: %f, askOffset: %f",
+		symbol, inventory, variance, reservationPrice, halfSpread, quote.BidOffset, quote.AskOffset)
+	return quote
+}
@@ -0,0 +1,50 @@
+package maker
+
+import (
+	"cex/common"
+	"cex/config"
+	"cex/trading"
+	"math"
+	"testing"
+)
+
+func TestGetAvellanedaStoikovQuote(t *testing.T) {
+	// 中间价 20000，窗口内的波动 1%，γ=0.1，k=100，半个最优价差约 200，第一档的间距 10
+	tests := []struct {
+		name          string
+		position      float64
+		wantBidOffset float64
+		wantAskOffset float64
+	}{
+		{"flat", 0, 189.000067, 189.000067},
+		{"long quotes lower", 3, 189.600067, 188.400067},
+		{"short quotes higher", -3, 188.400067, 189.600067},
+		{"offsets are not negative", -1000, 0, 389.000067},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Exchange:      "Binance",
+				SwapType:      "swap_cross",
+				MinAccuracy:   1e-8,
+				Simulated:     true,
+				QuoteModel:    QuoteModelAvellanedaStoikov,
+				Symbols:       []string{"BTCUSD_PERP"},
+				SymbolConfigs: map[string]config.SymbolConfig{"BTCUSD_PERP": {ContractNum: 1, RiskAversion: 0.1, OrderIntensity: 100}},
+			}
+			context := &trading.Context{}
+			context.Init(cfg)
+			orders := &trading.OrderHandler{Backtesting: true}
+			orders.Init(cfg, context, trading.NewSimulatedMarket(cfg))
+			strategy := &deliveryMaker{cfg: cfg, ctxt: context, orders: orders, volatilityWindow: defaultVolatilityWindow}
+
+			symbolContext := &trading.SymbolContext{Symbol: "BTCUSD_PERP", BidPrice: 19999, AskPrice: 20001}
+			dynamicConfig := &DynamicConfig{Params: config.QuoteParams{GapSizeK: 1}, Spread: 0.01, AdjustedGapSize: 10}
+			position := &common.DeliveryPosition{Symbol: "BTCUSD_PERP", Position: test.position, PositionAbs: math.Abs(test.position)}
+			quote := strategy.getAvellanedaStoikovQuote("BTCUSD_PERP", position, symbolContext, dynamicConfig)
+			if math.Abs(quote.BidOffset-test.wantBidOffset) > 1e-6 || math.Abs(quote.AskOffset-test.wantAskOffset) > 1e-6 || quote.Ratio != 1 {
+				t.Errorf("quote = %+v, want bid offset %f, ask offset %f", quote, test.wantBidOffset, test.wantAskOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// 测试行情和下单、撤单延迟的策略
+package probe
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"cex/engine"
+	"cex/trading"
+	"strings"
+	"time"
+)
+
+// 测试下单的价格离买一价的比例，保证不会成交
+const probePriceDistance = 0.005
+
+// 测试订单每5s撤销一次，单位：ms
+const probeCancelInterval = 5 * 1000
+
+func init() {
+	engine.Register("probe", func() engine.Strategy { return &probeStrategy{} })
+}
+
+// 测试行情和下单、撤单的延迟，不做市也不对冲，测试订单成交后不下对冲单
+// 行情延迟的日志格式是 消息类型|本地时间|消息时间|延迟，下单、撤单的耗时见 common.TimeCost，用 performance/stat.sh 统计
+type probeStrategy struct {
+	cfg    *config.Config
+	ctxt   *trading.Context
+	orders *trading.OrderHandler
+}
+
+func (strategy *probeStrategy) Name() string {
+	return "probe"
+}
+
+func (strategy *probeStrategy) Init(e *engine.Engine) {
+	strategy.cfg = e.Config()
+	strategy.ctxt = e.Context()
+	strategy.orders = e.Orders()
+	if strategy.cfg.FunctionHedge == 1 {
+		logger.Warn("strategy probe does not hedge, FunctionHedge is ignored")
+	}
+	// 每1s检查一次，没有测试订单时下单
+	e.AddTimer("placeOrders", 1*time.Second)
+	// 每1s检查一次，撤销测试订单
+	e.AddTimer("cancelOrders", 1*time.Second)
+}
+
+func (strategy *probeStrategy) OnPrice(product string, resp *client.PriceWSResponse) {
+	timeStamp := common.GetTimestampInMS()
+	logger.Info("%s|%d|%d|%d", resp.MsgType, timeStamp, resp.TimeStamp, timeStamp-resp.TimeStamp)
+	// 深度消息只统计延迟
+	if !strings.HasSuffix(resp.MsgType, "BookTicker") {
+		return
+	}
+	if product == engine.ProductDelivery {
+		strategy.ctxt.UpdateDeliveryPrice(resp)
+		return
+	}
+	bidPrice, bidVolume, askPrice, askVolume := trading.GetBookTicker(resp)
+	strategy.ctxt.UpdatePrice(resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, timeStamp, product)
+}
+
+func (strategy *probeStrategy) OnOrderUpdate(product string, resp *client.OrderWSResponse) {
+	if product == engine.ProductDelivery {
+		strategy.orders.HandleDeliveryOrder(resp, nil)
+	}
+}
+
+func (strategy *probeStrategy) OnTimer(name string) {
+	switch name {
+	case "placeOrders":
+		strategy.placeOrders()
+	case "cancelOrders":
+		strategy.cancelOrders()
+	}
+}
+
+func (strategy *probeStrategy) OnAccount(accounts map[string]*client.Account) {
+}
+
+// 每个交易对保持一个远离盘口的买单
+func (strategy *probeStrategy) placeOrders() {
+	cfg, ctxt := strategy.cfg, strategy.ctxt
+	var orders []*common.Order
+	for _, symbol := range ctxt.Symbols {
+		symbolContext := ctxt.GetSymbolContext(symbol)
+		if symbolContext.Risk != 0 || symbolContext.BidPrice < cfg.MinAccuracy {
+			continue
+		}
+		if strategy.probeOrderSize(symbol) > 0 {
+			continue
+		}
+		symbolCfg := cfg.SymbolConfigs[symbol]
+		orders = append(orders, &common.Order{
+			Symbol:      symbol,
+			OrderType:   "buy",
+			OrderVolume: float64(symbolCfg.ContractNum),
+			OrderPrice:  symbolContext.BidPrice * (1 - probePriceDistance),
+		})
+	}
+	strategy.orders.PlaceOrders(orders)
+}
+
+// 撤销交易对的所有订单
+func (strategy *probeStrategy) cancelOrders() {
+	timestamp := common.GetTimestampInMS()
+	for _, symbol := range strategy.ctxt.Symbols {
+		symbolContext := strategy.ctxt.GetSymbolContext(symbol)
+		if timestamp-symbolContext.LastCancelFarTime < probeCancelInterval {
+			continue
+		}
+		if strategy.probeOrderSize(symbol) == 0 {
+			continue
+		}
+		symbolContext.LastCancelFarTime = timestamp
+		strategy.orders.CancelAllOrdersWithSymbol(symbol)
+	}
+}
+
+// 交易对的测试订单数量
+func (strategy *probeStrategy) probeOrderSize(symbol string) int {
+	orderBook := strategy.orders.BuyOrders[symbol]
+	orderBook.Mutex.RLock()
+	defer orderBook.Mutex.RUnlock()
+	return orderBook.Size()
+}
@@ -1,13 +1,10 @@
-package main
+package trading
 
 import (
+	"cex/client"
 	"cex/common/logger"
 	"fmt"
-	"math"
-	"strconv"
 
-	"github.com/adshao/go-binance/v2"
-	"github.com/adshao/go-binance/v2/delivery"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -19,18 +16,23 @@ type AccountStatInfo struct {
 	positionAmt     float64
 }
 
-// 获取并更新账户信息
-func UpdateAccount() {
-	account := orderHandler.BinanceDeliveryOrderClient.GetAccount()
-	hedgeAccount := orderHandler.BinanceSpotOrderClient.GetAccount()
+// 更新账户信息，account 是币本位账户，hedgeAccount 是现货账户，获取失败时为nil
+func (context *Context) UpdateAccount(account *client.Account, hedgeAccount *client.Account) {
+	cfg := context.cfg
 	message := ""
 
 	// update to accountInfo
-	accountInfo := ctxt.Accounts.GetAccount(cfg.Exchange, cfg.SwapType)
-	for _, position := range account.Positions {
-		symbol := position.Symbol
-		accountInfo.GetPositionsInfo(symbol).Position, _ = strconv.ParseFloat(position.PositionAmt, 64)
-		accountInfo.GetPositionsInfo(symbol).PositionAbs = math.Abs(accountInfo.GetPositionsInfo(symbol).Position)
+	accountInfo := context.Accounts.GetAccount(cfg.Exchange, cfg.SwapType)
+	if account != nil {
+		for _, position := range account.Positions {
+			accountInfo.UpdatePosition(position.Symbol, position.PositionAmt)
+		}
+		// 挂单线程会同时读取，整体替换不修改原来的map
+		equities := map[string]float64{}
+		for _, asset := range account.Assets {
+			equities[asset.Asset] = asset.MarginBalance
+		}
+		accountInfo.Equities = equities
 	}
 
 	accountStatInfo := map[string]*AccountStatInfo{}
@@ -49,7 +51,7 @@ func UpdateAccount() {
 		dBalance := item.balance
 		sBalance := hedgeStatInfo[asset].balance
 		profit := dBalance + sBalance - item.initValue - hedgeStatInfo[asset].initValue
-		spotPriceItem := ctxt.GetPriceItem(cfg.Exchange, item.symbol, "spot")
+		spotPriceItem := context.GetPriceItem(cfg.Exchange, item.symbol, "spot")
 		if profit > 0 {
 			accountTotalProfitInUSD += profit * spotPriceItem.BidPrice
 		} else {
@@ -64,7 +66,7 @@ func UpdateAccount() {
 	accountTotalProfitInUSD += quoteAssetProfit
 	message += fmt.Sprintf("profit in %s=%.4f, ", cfg.QuoteAsset, quoteAssetProfit)
 
-	getAverageLeverage(account, accountStatInfo)
+	context.getAverageLeverage(account, accountStatInfo)
 	for symbol, item := range accountStatInfo {
 		// BNB 用来抵扣手续费，不算抵押资产
 		if symbol == "BNB" {
@@ -74,7 +76,7 @@ func UpdateAccount() {
 	}
 
 	message += fmt.Sprintf("TotalProfitInUSD=%.2f, ", accountTotalProfitInUSD)
-	if ctxt.Risk == 4 {
+	if context.Risk == 4 {
 		isSmall := true
 		for _, item := range accountStatInfo {
 			symbolCfg := cfg.SymbolConfigs[item.symbol]
@@ -84,15 +86,15 @@ func UpdateAccount() {
 			}
 		}
 		if isSmall {
-			ctxt.Risk = 0
+			context.Risk = 0
 			logger.Error("Leverage is smaller then max leverage, resume place order!")
 		}
 	}
-	if ctxt.Risk == 0 {
+	if context.Risk == 0 {
 		for _, item := range accountStatInfo {
 			symbolCfg := cfg.SymbolConfigs[item.symbol]
 			if item.averageLeverage > float64(symbolCfg.Leverage) {
-				ctxt.Risk = 4
+				context.Risk = 4
 				logger.Error("Leverage is bigger then max leverage, stop place order!")
 			}
 		}
@@ -100,49 +102,49 @@ func UpdateAccount() {
 
 	if message != "" {
 		logger.Warn(message)
+		if context.TelegramBot == nil {
+			return
+		}
 		msg := tgbotapi.NewMessage(cfg.TgChatID, message)
-		_, err := ctxt.TelegramBot.Send(msg)
+		_, err := context.TelegramBot.Send(msg)
 		if err != nil {
 			logger.Warn("send stat message failed, error: %+v", err)
 		}
 	}
 }
 
-func getProfit(account *delivery.Account, statInfo map[string]*AccountStatInfo) {
+func getProfit(account *client.Account, statInfo map[string]*AccountStatInfo) {
 	if account == nil {
 		return
 	}
-	if len(account.Assets) > 0 {
-		for _, asset := range account.Assets {
-			item, ok := statInfo[asset.Asset]
-			if !ok {
-				continue
-			}
-			item.balance, _ = strconv.ParseFloat(asset.MarginBalance, 64)
+	for _, asset := range account.Assets {
+		item, ok := statInfo[asset.Asset]
+		if !ok {
+			continue
 		}
+		item.balance = asset.MarginBalance
 	}
 }
 
 // spot用来对冲
-func getHedgeProfit(account *binance.Account, hedgeStatInfo map[string]*AccountStatInfo) {
+func getHedgeProfit(account *client.Account, hedgeStatInfo map[string]*AccountStatInfo) {
 	if account == nil {
 		return
 	}
-	if len(account.Balances) > 0 {
-		for _, asset := range account.Balances {
-			item, ok := hedgeStatInfo[asset.Asset]
-			if !ok {
-				continue
-			}
-			item.balance, _ = strconv.ParseFloat(asset.Free, 64)
+	for _, asset := range account.Assets {
+		item, ok := hedgeStatInfo[asset.Asset]
+		if !ok {
+			continue
 		}
+		item.balance = asset.Free
 	}
 }
 
-func getAverageLeverage(account *delivery.Account, statInfo map[string]*AccountStatInfo) {
+func (context *Context) getAverageLeverage(account *client.Account, statInfo map[string]*AccountStatInfo) {
 	if account == nil {
 		return
 	}
+	cfg := context.cfg
 	if len(account.Positions) > 0 {
 		for _, position := range account.Positions {
 			asset := cfg.SymbolConfigs[position.Symbol].BaseAsset
@@ -151,12 +153,12 @@ func getAverageLeverage(account *delivery.Account, statInfo map[string]*AccountS
 				continue
 			}
 
-			tmp, _ := strconv.ParseFloat(position.PositionAmt, 64)
+			tmp := position.PositionAmt
 			if tmp == 0 {
 				continue
 			}
 			item.positionAmt += tmp
-			spotPriceItem := ctxt.GetPriceItem(cfg.Exchange, position.Symbol, "spot")
+			spotPriceItem := context.GetPriceItem(cfg.Exchange, position.Symbol, "spot")
 			item.averageLeverage = item.positionAmt * float64(cfg.SymbolConfigs[position.Symbol].Cont) / spotPriceItem.BidPrice / item.balance
 		}
 	}
@@ -1,9 +1,11 @@
-package main
+package trading
 
 import (
+	"cex/client"
 	"cex/common"
 	"cex/common/logger"
 	"cex/config"
+	"math"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -18,7 +20,8 @@ type SymbolContext struct {
 	LastUpdateTime    int64   // 单位：ms，如果更新时间超过阈值，Risk设置成3，取消全部订单，并暂停下单直到恢复
 	LastCancelTime    int64   // 单位：ms，用来控制取消订单的频率
 	LastCancelFarTime int64   // 单位：ms，用来控制取消远距离订单的频率
-	Risk              int     // 风险控制：0可以挂单，1表示出错，2表示处于结算时间，3系统暂停等待价格更新，4超过最大仓位
+	Risk              int     // 风险控制：0可以挂单，1表示出错，2表示处于结算时间，3系统暂停等待价格更新，4超过最大仓位，5下单被拒绝暂停挂单
+	PauseUntil        int64   // 单位：ms，Risk为5时暂停挂单到这个时间
 }
 
 func (context *SymbolContext) Init(deliverySymbol string) {
@@ -32,6 +35,7 @@ func (context *SymbolContext) Init(deliverySymbol string) {
 	context.LastCancelTime = 0
 	context.LastCancelFarTime = 0
 	context.Risk = 0
+	context.PauseUntil = 0
 }
 
 type PriceDataItem struct {
@@ -50,6 +54,7 @@ type PriceData struct {
 
 // 整体上下文
 type Context struct {
+	cfg      *config.Config  // Init 时传入的配置
 	Accounts common.Accounts // 账户信息
 
 	Risk           int                 // 风险控制：0可以挂单，1表示出错，2表示处于结算时间，3系统启动等待价格更新
@@ -63,6 +68,7 @@ type Context struct {
 }
 
 func (context *Context) Init(cfg *config.Config) {
+	context.cfg = cfg
 	context.Risk = 0
 
 	// SymbolMap 存的是 U本位交易对和1至多个币本位交易对 的映射
@@ -83,20 +89,23 @@ func (context *Context) Init(cfg *config.Config) {
 
 		// 现货
 		spotKey := common.FormatPriceName(cfg.Exchange, symbol, "spot")
-		ctxt.Prices.Items[spotKey] = &PriceDataItem{Symbol: symbol}
+		context.Prices.Items[spotKey] = &PriceDataItem{Symbol: symbol}
 
 		// U本位永续
 		futuresKey := common.FormatPriceName(cfg.Exchange, symbol, "futures")
-		ctxt.Prices.Items[futuresKey] = &PriceDataItem{Symbol: symbol}
+		context.Prices.Items[futuresKey] = &PriceDataItem{Symbol: symbol}
 	}
 
 	context.Accounts.AddAccount(cfg.Exchange, cfg.SwapType)
 
-	// 初始化 telegramBot
+	// 初始化 telegramBot, 离线运行时不需要
+	if cfg.Simulated && cfg.TgBotToken == "" {
+		return
+	}
 	bot, err := tgbotapi.NewBotAPI(cfg.TgBotToken)
 	if err != nil {
-		logger.Error("init telegram bot failed, err is %#v", err)
-		ExitProcess()
+		// 还没有挂单，直接退出
+		logger.Fatal("init telegram bot failed, err is %#v", err)
 	}
 	context.TelegramBot = bot
 }
@@ -133,3 +142,107 @@ func (context *Context) GetDeliverySymbol(symbol string) []string {
 	deliverySymbol := context.SymbolMap[symbol]
 	return deliverySymbol
 }
+
+// 更新币本位的盘口价格，价格变化超过 MinDeltaRate 时返回true
+func (context *Context) UpdateDeliveryPrice(resp *client.PriceWSResponse) bool {
+	config := context.cfg
+	symbol := resp.Symbol
+	symbolCfg := config.SymbolConfigs[symbol]
+	symbolContext := context.GetSymbolContext(symbol)
+
+	timeStamp := common.GetTimestampInMS()
+	if resp.MsgType == "deliveryBookTicker" {
+		// 处理期货bookTicker
+		bidPrice, askPrice := 0.0, 0.0
+		bidVolume, askVolume := 0.0, 0.0
+		for _, item := range resp.Items {
+			if item.Volume < symbolCfg.EffectiveNum {
+				continue
+			}
+			if item.Direction == "buy" {
+				bidPrice = item.Price
+				bidVolume = item.Volume
+			} else if item.Direction == "sell" {
+				askPrice = item.Price
+				askVolume = item.Volume
+			}
+		}
+
+		buyDelta, sellDelta := 0.0, 0.0
+		if bidPrice > config.MinAccuracy {
+			buyDelta = math.Abs(bidPrice-symbolContext.BidPrice) / bidPrice
+			symbolContext.BidPrice = bidPrice
+			symbolContext.BidVolume = bidVolume
+			logger.Debug("binance delivery %s buy price is %f, quantity is %f at %d",
+				symbol, bidPrice, bidVolume, resp.TimeStamp)
+		}
+
+		if askPrice > config.MinAccuracy {
+			sellDelta = math.Abs(askPrice-symbolContext.AskPrice) / askPrice
+			symbolContext.AskPrice = askPrice
+			symbolContext.AskVolume = askVolume
+			logger.Debug("binance delivery %s sell price is %f, quantity is %f at %d",
+				symbol, askPrice, askVolume, resp.TimeStamp)
+		}
+		if bidPrice > config.MinAccuracy || askPrice > config.MinAccuracy {
+			symbolContext.LastUpdateTime = timeStamp
+		}
+
+		logger.Debug("binance delivery bookTicker symbol: %s, bidPrice:%f, bidVolume:%f, askPrice:%f, askVolume:%f, updateTime:%d", resp.Symbol, bidPrice, bidVolume, askPrice, askVolume, resp.TimeStamp)
+		return buyDelta > config.MinDeltaRate || sellDelta > config.MinDeltaRate
+	}
+	return false
+}
+
+// 更新参照组最新买卖价格，返回价格变化超过 MinDeltaRate 的币本位交易对
+func (context *Context) UpdatePrice(symbol string, bidPrice float64, bidVolume float64,
+	askPrice float64, askVolume float64, timestamp int64, ptype string) []string {
+	config := context.cfg
+
+	var changedSymbols []string
+
+	deliverySymbols := context.GetDeliverySymbol(symbol)
+	for _, deliverySymbol := range deliverySymbols {
+		name := common.FormatPriceName(config.Exchange, deliverySymbol, ptype)
+		priceDataItem, ok := context.Prices.Items[name]
+		if !ok {
+			continue
+		}
+		buyDelta, sellDelta := 0.0, 0.0
+
+		if bidPrice > config.MinAccuracy || askPrice > config.MinAccuracy {
+			priceDataItem.LastUpdateTime = timestamp
+		}
+
+		if bidPrice > config.MinAccuracy {
+			buyDelta = math.Abs(bidPrice-priceDataItem.AskPrice) / bidPrice
+			priceDataItem.BidPrice = bidPrice
+			priceDataItem.BidVolume = bidVolume
+		}
+
+		if askPrice > config.MinAccuracy {
+			sellDelta = math.Abs(askPrice-priceDataItem.AskPrice) / askPrice
+			priceDataItem.AskPrice = askPrice
+			priceDataItem.AskVolume = askVolume
+		}
+
+		if buyDelta > config.MinDeltaRate || sellDelta > config.MinDeltaRate {
+			changedSymbols = append(changedSymbols, deliverySymbol)
+		}
+	}
+	return changedSymbols
+}
+
+// bookTicker 的买一、卖一价格和数量
+func GetBookTicker(resp *client.PriceWSResponse) (bidPrice float64, bidVolume float64, askPrice float64, askVolume float64) {
+	for _, item := range resp.Items {
+		if item.Direction == "buy" {
+			bidPrice = item.Price
+			bidVolume = item.Volume
+		} else if item.Direction == "sell" {
+			askPrice = item.Price
+			askVolume = item.Volume
+		}
+	}
+	return bidPrice, bidVolume, askPrice, askVolume
+}
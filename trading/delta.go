@@ -0,0 +1,183 @@
+package trading
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 一个币种的净敞口，单位都是 Base Asset
+// 币本位的保证金本身就是多头敞口，空头持仓和现货、U本位的多头抵消
+type DeltaExposure struct {
+	Asset      string
+	Symbol     string  // 币种对应的币本位交易对，用来获取价格和配置
+	Collateral float64 // 币本位账户的保证金余额
+	Delivery   float64 // 币本位持仓按现货价格折算成币
+	Spot       float64 // 现货余额，包括冻结的部分
+	Futures    float64 // U本位持仓
+	Target     float64 // 期望的净敞口，InitValue + InitHedgeValue
+}
+
+// 净敞口
+func (exposure *DeltaExposure) Net() float64 {
+	return exposure.Collateral + exposure.Delivery + exposure.Spot + exposure.Futures
+}
+
+// 相对期望的偏差，正数表示多了需要卖出，负数表示少了需要买入
+func (exposure *DeltaExposure) Deviation() float64 {
+	return exposure.Net() - exposure.Target
+}
+
+// 检查每个币种的净敞口，超过 MaxDelta 时报警，配置了 DeltaAutoHedge 时下单补齐，由 CheckRehedge 调整对冲的币种不补
+func (handler *OrderHandler) CheckDelta() {
+	exposures := make(map[string]*DeltaExposure)
+	for _, symbol := range handler.ctxt.Symbols {
+		symbolCfg := handler.cfg.SymbolConfigs[symbol]
+		if symbolCfg.MaxDelta <= 0 || exposures[symbolCfg.BaseAsset] != nil {
+			continue
+		}
+		exposures[symbolCfg.BaseAsset] = &DeltaExposure{
+			Asset:  symbolCfg.BaseAsset,
+			Symbol: symbol,
+			Target: symbolCfg.InitValue + symbolCfg.InitHedgeValue,
+		}
+	}
+	if len(exposures) == 0 {
+		return
+	}
+	if !handler.collectDeltaExposures(exposures) {
+		return
+	}
+
+	assets := make([]string, 0, len(exposures))
+	for asset := range exposures {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	for _, asset := range assets {
+		handler.checkDeltaExposure(exposures[asset])
+	}
+}
+
+// 从币本位、现货、U本位账户获取余额和持仓，任何一个账户获取失败都不检查
+func (handler *OrderHandler) collectDeltaExposures(exposures map[string]*DeltaExposure) bool {
+	deliveryAccount, err := handler.DeliveryOrderClient.GetAccount()
+	if err != nil {
+		logger.Error("check delta get delivery account failed, message is %s", err.Error())
+		return false
+	}
+	spotAccount, err := handler.SpotOrderClient.GetAccount()
+	if err != nil {
+		logger.Error("check delta get spot account failed, message is %s", err.Error())
+		return false
+	}
+	var futuresAccount *client.Account
+	if handler.UsesFuturesHedge() {
+		futuresAccount, err = handler.FuturesOrderClient.GetAccount()
+		if err != nil {
+			logger.Error("check delta get futures account failed, message is %s", err.Error())
+			return false
+		}
+	}
+
+	for _, exposure := range exposures {
+		if asset := deliveryAccount.GetAsset(exposure.Asset); asset != nil {
+			exposure.Collateral = asset.MarginBalance
+		}
+		if asset := spotAccount.GetAsset(exposure.Asset); asset != nil {
+			exposure.Spot = asset.Free + asset.Locked
+		}
+	}
+	for _, position := range deliveryAccount.Positions {
+		symbolCfg, ok := handler.cfg.SymbolConfigs[position.Symbol]
+		if !ok || position.PositionAmt == 0 {
+			continue
+		}
+		exposure, ok := exposures[symbolCfg.BaseAsset]
+		if !ok {
+			continue
+		}
+		price := handler.getDeltaPrice(position.Symbol)
+		if price <= handler.cfg.MinAccuracy {
+			logger.Error("check delta %s failed, spot price is not ready", position.Symbol)
+			return false
+		}
+		exposure.Delivery += position.PositionAmt * float64(symbolCfg.Cont) / price
+	}
+	if futuresAccount != nil {
+		for _, exposure := range exposures {
+			futuresSymbol := common.FormatFuturesSymbol(exposure.Symbol, handler.cfg.QuoteAsset)
+			for _, position := range futuresAccount.Positions {
+				if position.Symbol == futuresSymbol {
+					exposure.Futures += position.PositionAmt
+				}
+			}
+		}
+	}
+	return true
+}
+
+// 币本位持仓折算成币的价格，使用现货的中间价
+func (handler *OrderHandler) getDeltaPrice(symbol string) float64 {
+	priceItem := handler.ctxt.GetPriceItem(handler.cfg.Exchange, symbol, "spot")
+	if priceItem == nil || priceItem.BidPrice <= handler.cfg.MinAccuracy || priceItem.AskPrice <= handler.cfg.MinAccuracy {
+		return 0
+	}
+	return (priceItem.BidPrice + priceItem.AskPrice) / 2
+}
+
+func (handler *OrderHandler) checkDeltaExposure(exposure *DeltaExposure) {
+	symbolCfg := handler.cfg.SymbolConfigs[exposure.Symbol]
+	deviation := exposure.Deviation()
+	logger.Info("Op=DeltaCheck, Asset=%s, Collateral=%f, Delivery=%f, Spot=%f, Futures=%f, Net=%f, Target=%f, Deviation=%f",
+		exposure.Asset, exposure.Collateral, exposure.Delivery, exposure.Spot, exposure.Futures, exposure.Net(), exposure.Target, deviation)
+	if math.Abs(deviation) <= symbolCfg.MaxDelta {
+		return
+	}
+
+	logger.Error("Op=DeltaExceeded, Asset=%s, Net=%f, Target=%f, Deviation=%f, MaxDelta=%f",
+		exposure.Asset, exposure.Net(), exposure.Target, deviation, symbolCfg.MaxDelta)
+	handler.sendOrderAlarm(fmt.Sprintf("%s 净敞口偏差%.4f，超过%.4f，净敞口:%.4f，期望:%.4f",
+		exposure.Asset, deviation, symbolCfg.MaxDelta, exposure.Net(), exposure.Target))
+	if !handler.cfg.DeltaAutoHedge || handler.cfg.FunctionHedge != 1 {
+		return
+	}
+	// 对冲单还没有结束时余额还在变化，下次再检查
+	if handler.HedgeTracker.HasPending(exposure.Asset) {
+		logger.Warn("check delta %s has pending hedges, skip repair", exposure.Asset)
+		return
+	}
+
+	// 价格变化带来的偏差由 CheckRehedge 补齐，这里再补会重复对冲，两边来回调整
+	ledger := handler.HedgeLedger
+	if handler.hasRehedgeBook(exposure.Asset) {
+		logger.Warn("check delta %s is rehedged by position, skip repair", exposure.Asset)
+		return
+	}
+
+	// 账本中累计的数量已经包含在偏差中，只补上账本之外的部分
+	amount := -deviation - ledger.Residual(exposure.Asset)
+	hedgeOrderType := "buy"
+	if deviation > 0 {
+		hedgeOrderType = "sell"
+	}
+	venue := handler.chooseHedgeVenue(exposure.Symbol, hedgeOrderType)
+	logger.Warn("Op=DeltaRepair, Asset=%s, Deviation=%f, Amount=%f, Venue=%s", exposure.Asset, deviation, amount, venue)
+	handler.hedgeResidual(exposure.Symbol, venue, amount, "delta")
+}
+
+// 币种是否有交易对按持仓重新对冲，见 CheckRehedge
+func (handler *OrderHandler) hasRehedgeBook(asset string) bool {
+	for _, symbol := range handler.ctxt.Symbols {
+		if handler.cfg.SymbolConfigs[symbol].BaseAsset != asset {
+			continue
+		}
+		if _, ok := handler.HedgeLedger.GetBook(symbol); ok {
+			return true
+		}
+	}
+	return false
+}
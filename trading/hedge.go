@@ -0,0 +1,421 @@
+package trading
+
+import (
+	"cex/client"
+	"cex/common"
+	"cex/common/logger"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// 对冲订单发出后多久没有全部成交就报警，单位：ms
+const hedgeConfirmTimeout = 10 * 1000
+
+// 对冲的市场，SymbolConfig.HedgeVenue
+const (
+	HedgeVenueSpot    = "spot"    // 现货，默认
+	HedgeVenueFutures = "futures" // U本位永续
+	HedgeVenueAuto    = "auto"    // 按盘口价格和吃单手续费选择成本低的
+
+	HedgeVenueDelivery = "delivery" // 币本位的另一个合约，跨期价差策略使用，见 strategy/calendar
+)
+
+// 盘口价格超过这个时间没有更新，auto 不选择这个市场，单位：ms
+const hedgePriceExpiration = 1000
+
+// 是否有交易对使用U本位对冲，需要订阅U本位的订单消息
+func (handler *OrderHandler) UsesFuturesHedge() bool {
+	for _, symbol := range handler.ctxt.Symbols {
+		venue := handler.cfg.SymbolConfigs[symbol].HedgeVenue
+		if venue == HedgeVenueFutures || venue == HedgeVenueAuto {
+			return true
+		}
+	}
+	return false
+}
+
+// 选择对冲的市场，auto 比较手续费之后的成交价，买入按卖一价，卖出按买一价
+func (handler *OrderHandler) chooseHedgeVenue(symbol string, hedgeOrderType string) string {
+	venue := handler.cfg.SymbolConfigs[symbol].HedgeVenue
+	if venue == HedgeVenueFutures {
+		return venue
+	}
+	if venue != HedgeVenueAuto {
+		return HedgeVenueSpot
+	}
+
+	now := common.GetTimestampInMS()
+	// 两边的价格都没有更新时使用现货
+	bestVenue, bestCost := HedgeVenueSpot, 0.0
+	for _, candidate := range []string{HedgeVenueSpot, HedgeVenueFutures} {
+		priceItem := handler.ctxt.GetPriceItem(handler.cfg.Exchange, symbol, candidate)
+		if priceItem == nil || now-priceItem.LastUpdateTime > hedgePriceExpiration {
+			continue
+		}
+		fee := handler.cfg.SpotTakerFee
+		if candidate == HedgeVenueFutures {
+			fee = handler.cfg.FuturesTakerFee
+		}
+		// 买入的成本越低越好，卖出的收入越高越好，统一成越小越好
+		var cost float64
+		if hedgeOrderType == "buy" {
+			if priceItem.AskPrice <= handler.cfg.MinAccuracy {
+				continue
+			}
+			cost = priceItem.AskPrice * (1 + fee)
+		} else {
+			if priceItem.BidPrice <= handler.cfg.MinAccuracy {
+				continue
+			}
+			cost = -priceItem.BidPrice * (1 - fee)
+		}
+		if bestCost == 0 || cost < bestCost {
+			bestVenue, bestCost = candidate, cost
+		}
+	}
+	return bestVenue
+}
+
+// 计算对冲数量的价格，和原来的逻辑一致：买入用买一价，卖出用卖一价
+func (handler *OrderHandler) getHedgePrice(symbol string, venue string, hedgeOrderType string) float64 {
+	priceItem := handler.ctxt.GetPriceItem(handler.cfg.Exchange, symbol, venue)
+	if priceItem == nil {
+		return 0
+	}
+	if hedgeOrderType == "buy" {
+		return priceItem.BidPrice
+	}
+	return priceItem.AskPrice
+}
+
+// 对冲单能立即成交的盘口价格，计算滑点和限价单的价格上限时使用：买入用卖一价，卖出用买一价
+func (handler *OrderHandler) getHedgeReferencePrice(symbol string, venue string, hedgeOrderType string) float64 {
+	priceItem := handler.ctxt.GetPriceItem(handler.cfg.Exchange, symbol, venue)
+	if priceItem == nil {
+		return 0
+	}
+	if hedgeOrderType == "buy" {
+		return priceItem.AskPrice
+	}
+	return priceItem.BidPrice
+}
+
+// 限价对冲单的价格，相对盘口价格的滑点不超过 HedgeSlippage，买入向下取整，卖出向上取整
+func (handler *OrderHandler) getSlippageCapPrice(referencePrice float64, hedgeOrderType string, precision int) float64 {
+	scale := math.Pow10(precision)
+	if hedgeOrderType == "buy" {
+		return math.Floor(referencePrice*(1+handler.cfg.HedgeSlippage)*scale) / scale
+	}
+	return math.Ceil(referencePrice*(1-handler.cfg.HedgeSlippage)*scale) / scale
+}
+
+// 检查超时的限价对冲单，撤单后剩余部分用市价单
+func (handler *OrderHandler) CheckLimitHedges() {
+	for _, order := range handler.HedgeTracker.DueLimitOrders(common.GetTimestampInMS()) {
+		order := order
+		handler.RunAsync(func() { handler.FallbackLimitHedge(&order) })
+	}
+}
+
+// 币本位成交之后用现货或者U本位对冲，volume 是合约张数
+// 需要对冲的数量先记入 HedgeLedger，累计超过 MinHedgeSize 之后才下单，不够的部分留到下次成交
+// 对冲订单的ClientOrderID带上 clientOrderID，对冲的成交消息通过它关联到币本位的成交
+func (handler *OrderHandler) HedgeFill(symbol string, orderType string, volume float64, clientOrderID string) {
+	symbolCfg := handler.cfg.SymbolConfigs[symbol]
+	hedgeOrderType := common.GetHedgeOrderType(orderType)
+	venue := handler.chooseHedgeVenue(symbol, hedgeOrderType)
+	price := handler.getHedgePrice(symbol, venue, hedgeOrderType)
+	if price <= handler.cfg.MinAccuracy {
+		logger.Error("hedge %s %s failed, %s price is not ready", symbol, orderType, venue)
+		return
+	}
+	cont := float64(symbolCfg.Cont)
+	amount := volume * cont / price
+	logger.Info("===volume:%f, price:%f, amount:%f, minHedgeSize:%f, hedgeOrderType: %s, venue: %s",
+		volume, price, amount, symbolCfg.MinHedgeSize, hedgeOrderType, venue)
+	// 币本位买入，持仓增加，对冲卖出
+	contracts := volume
+	if orderType == "sell" {
+		contracts = -volume
+	}
+	handler.HedgeLedger.Book(symbol, contracts, SignedHedgeAmount(hedgeOrderType, amount))
+	handler.hedgeResidual(symbol, venue, SignedHedgeAmount(hedgeOrderType, amount), clientOrderID)
+}
+
+// 把 amount（买入为正，卖出为负）记入账本，累计的数量够 MinHedgeSize 时下对冲单
+func (handler *OrderHandler) hedgeResidual(symbol string, venue string, amount float64, clientOrderID string) {
+	symbolCfg := handler.cfg.SymbolConfigs[symbol]
+	precision := symbolCfg.Precision
+	if venue == HedgeVenueFutures && symbolCfg.FuturesPrecision != [2]int{} {
+		precision = symbolCfg.FuturesPrecision
+	}
+
+	ledger := handler.HedgeLedger
+	hedgeAmount := ledger.Settle(symbolCfg.BaseAsset, amount, precision[0], symbolCfg.MinHedgeSize)
+	logger.Info("hedge residual %s, amount:%f, hedgeAmount:%f, residual:%f, venue: %s",
+		symbolCfg.BaseAsset, amount, hedgeAmount, ledger.Residual(symbolCfg.BaseAsset), venue)
+	if hedgeAmount == 0 {
+		return
+	}
+
+	// 账本中累计的数量可能和这次成交的方向相反
+	hedgeOrderType := "buy"
+	if hedgeAmount < 0 {
+		hedgeOrderType = "sell"
+	}
+	referencePrice := handler.getHedgeReferencePrice(symbol, venue, hedgeOrderType)
+	if referencePrice <= handler.cfg.MinAccuracy {
+		referencePrice = handler.getHedgePrice(symbol, venue, hedgeOrderType)
+	}
+
+	var order common.Order
+	order.Exchange = "Binance"
+	order.OrderType = hedgeOrderType
+	order.OrderPrice = referencePrice
+	order.OrderVolume = math.Abs(hedgeAmount)
+	order.Symbol = symbol
+	order.ClientOrderID = common.GetHedgeClientOrderID(clientOrderID)
+	order.BaseAsset = symbolCfg.BaseAsset
+	order.QuoteAsset = handler.cfg.QuoteAsset
+	order.Precision = precision
+	handler.PlaceHedgeOrder(&order, venue)
+}
+
+// 一笔对冲的执行情况，通过对冲市场的订单消息更新
+// 限价单超时后剩余部分用市价单，两个订单属于同一笔对冲
+type HedgeRecord struct {
+	ClientOrderID       string // 对冲订单的ClientOrderID，限价单超时后是第一个订单的
+	OriginClientOrderID string // 触发对冲的币本位订单的ClientOrderID
+	Venue               string // 对冲的市场，spot 或 futures
+	Symbol              string // 币本位交易对
+	OrderType           string
+	ExpectedPrice       float64 // 下单时对冲市场的盘口价格
+	Volume              float64 // 下单数量
+	FilledVolume        float64
+	FilledQuote         float64 // 成交金额，用来计算成交均价
+	Fee                 float64
+	FeeAsset            string
+	CreateAt            int64 // 单位：ms
+
+	order               common.Order // 对冲订单，限价单超时后用来下市价单
+	activeClientOrderID string       // 当前订单的ClientOrderID，结束时这笔对冲才结束；为空表示正在撤销限价单
+	fallbackAt          int64        // 限价单超时的时间，0表示不是限价单或者已经处理
+	fallback            bool         // 剩余部分是否用了市价单
+}
+
+// 成交均价
+func (record *HedgeRecord) AveragePrice() float64 {
+	if record.FilledVolume <= 0 {
+		return 0
+	}
+	return record.FilledQuote / record.FilledVolume
+}
+
+// 成交均价相对下单时盘口价格的滑点，正数表示成交价格更差
+func (record *HedgeRecord) Slippage() float64 {
+	if record.ExpectedPrice <= 0 || record.FilledVolume <= 0 {
+		return 0
+	}
+	slippage := (record.AveragePrice() - record.ExpectedPrice) / record.ExpectedPrice
+	if record.OrderType == "sell" {
+		slippage = -slippage
+	}
+	return slippage
+}
+
+// 跟踪已经发出的对冲订单，确认每笔对冲都已经成交并统计滑点
+type HedgeTracker struct {
+	mutex       sync.Mutex
+	records     map[string]*HedgeRecord // ClientOrderID => HedgeRecord，限价单和市价单指向同一个记录
+	minAccuracy float64                 // 成交数量的误差
+}
+
+func NewHedgeTracker(minAccuracy float64) *HedgeTracker {
+	return &HedgeTracker{records: map[string]*HedgeRecord{}, minAccuracy: minAccuracy}
+}
+
+// 下单前登记，对冲市场的成交消息可能比下单接口先返回
+// order.OrderPrice 是盘口价格，用来计算滑点
+func (tracker *HedgeTracker) Add(order *common.Order, venue string) {
+	originClientOrderID, _ := common.ParseHedgeClientOrderID(order.ClientOrderID)
+	// 下单数量按精度取整，和下单时一致
+	volume, _ := strconv.ParseFloat(strconv.FormatFloat(order.OrderVolume, 'f', order.Precision[0], 64), 64)
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.records[order.ClientOrderID] = &HedgeRecord{
+		ClientOrderID:       order.ClientOrderID,
+		OriginClientOrderID: originClientOrderID,
+		Venue:               venue,
+		Symbol:              order.Symbol,
+		OrderType:           order.OrderType,
+		ExpectedPrice:       order.OrderPrice,
+		Volume:              volume,
+		CreateAt:            common.GetTimestampInMS(),
+		order:               *order,
+		activeClientOrderID: order.ClientOrderID,
+	}
+}
+
+// 限价单下单成功，到时间后由 DueLimitOrders 返回
+func (tracker *HedgeTracker) SetFallbackAt(clientOrderID string, fallbackAt int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if record, ok := tracker.records[clientOrderID]; ok {
+		record.fallbackAt = fallbackAt
+	}
+}
+
+// 超时的限价单，返回对冲订单，之后限价单的撤单消息不会结束这笔对冲
+func (tracker *HedgeTracker) DueLimitOrders(now int64) []common.Order {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	var orders []common.Order
+	for clientOrderID, record := range tracker.records {
+		if clientOrderID != record.ClientOrderID || record.fallbackAt == 0 || now < record.fallbackAt {
+			continue
+		}
+		record.fallbackAt = 0
+		record.activeClientOrderID = ""
+		orders = append(orders, record.order)
+	}
+	return orders
+}
+
+// 正在跟踪的对冲，返回的记录只能读取不变的字段
+func (tracker *HedgeTracker) Get(clientOrderID string) *HedgeRecord {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.records[clientOrderID]
+}
+
+// 限价单撤销后，剩余部分用市价单 fallbackClientOrderID
+func (tracker *HedgeTracker) Fallback(clientOrderID string, fallbackClientOrderID string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if record, ok := tracker.records[clientOrderID]; ok {
+		record.activeClientOrderID = fallbackClientOrderID
+		record.fallback = true
+		tracker.records[fallbackClientOrderID] = record
+	}
+}
+
+// 是否有还没有结束的对冲，这时账户余额还没有反映对冲的结果
+func (tracker *HedgeTracker) HasPending(asset string) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for _, record := range tracker.records {
+		if record.order.BaseAsset == asset {
+			return true
+		}
+	}
+	return false
+}
+
+// 下单失败，不再跟踪
+func (tracker *HedgeTracker) Remove(clientOrderID string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if record, ok := tracker.records[clientOrderID]; ok {
+		tracker.remove(record)
+	}
+}
+
+// 调用时需要持有锁
+func (tracker *HedgeTracker) remove(record *HedgeRecord) {
+	delete(tracker.records, record.ClientOrderID)
+	delete(tracker.records, record.activeClientOrderID)
+}
+
+// 处理对冲市场的订单消息，全部成交或者当前订单结束时返回对应的记录，否则返回nil
+func (tracker *HedgeTracker) Update(resp *client.OrderWSResponse) *HedgeRecord {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	record, ok := tracker.records[resp.Order.ClientOrderID]
+	if !ok {
+		return nil
+	}
+	switch resp.Status {
+	case "PARTIALLY_FILLED", "FILLED":
+		record.FilledVolume += resp.Order.OrderVolume
+		record.FilledQuote += resp.Order.OrderPrice * resp.Order.OrderVolume
+		record.Fee += resp.Fee
+		if resp.FeeAsset != "" {
+			record.FeeAsset = resp.FeeAsset
+		}
+		// 限价单撤单前可能已经全部成交
+		if record.FilledVolume >= record.Volume-tracker.minAccuracy {
+			break
+		}
+		if resp.Status == "PARTIALLY_FILLED" || resp.Order.ClientOrderID != record.activeClientOrderID {
+			return nil
+		}
+	case "CANCELED", "EXPIRED", "REJECTED":
+		if resp.Order.ClientOrderID != record.activeClientOrderID {
+			return nil
+		}
+	default:
+		return nil
+	}
+	tracker.remove(record)
+	return record
+}
+
+// 超时没有全部成交的对冲，返回后不再跟踪
+func (tracker *HedgeTracker) Expired(now int64, timeout int64) []*HedgeRecord {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	var records []*HedgeRecord
+	for clientOrderID, record := range tracker.records {
+		if clientOrderID == record.ClientOrderID && now-record.CreateAt > timeout {
+			records = append(records, record)
+		}
+	}
+	for _, record := range records {
+		tracker.remove(record)
+	}
+	return records
+}
+
+// 现货、U本位的订单消息，只处理对冲订单
+func (handler *OrderHandler) HandleHedgeOrder(resp *client.OrderWSResponse) {
+	record := handler.HedgeTracker.Update(resp)
+	if record == nil {
+		return
+	}
+	if record.FilledVolume < record.Volume-handler.cfg.MinAccuracy {
+		logger.Error("Op=HedgeUnfilled, Venue=%s, Symbol=%s, ClientOrderID=%s, OriginClientOrderID=%s, Status=%s, Volume=%f, FilledVolume=%f",
+			record.Venue, record.Symbol, record.ClientOrderID, record.OriginClientOrderID, resp.Status, record.Volume, record.FilledVolume)
+		handler.sendOrderAlarm(fmt.Sprintf("对冲未完全成交，%s %s %s %.4f，成交%.4f，状态:%s",
+			record.Venue, record.Symbol, record.OrderType, record.Volume, record.FilledVolume, resp.Status))
+		// 没有成交的部分放回账本，和之后的成交一起对冲
+		handler.restoreHedgeResidual(record.Venue, &record.order, record.Volume-record.FilledVolume)
+		return
+	}
+	logger.Info("Op=HedgeFilled, Venue=%s, Symbol=%s, ClientOrderID=%s, OriginClientOrderID=%s, Direction=%s, ExpectedPrice=%f, AveragePrice=%f, Volume=%f, Slippage=%.6f, Fallback=%t, Fee=%f %s, Cost=%dms",
+		record.Venue, record.Symbol, record.ClientOrderID, record.OriginClientOrderID, record.OrderType, record.ExpectedPrice,
+		record.AveragePrice(), record.FilledVolume, record.Slippage(), record.fallback, record.Fee, record.FeeAsset,
+		common.GetTimestampInMS()-record.CreateAt)
+}
+
+// 没有对冲的数量放回账本，币本位对冲的数量是张数，按对冲合约记录
+func (handler *OrderHandler) restoreHedgeResidual(venue string, order *common.Order, volume float64) {
+	if venue == HedgeVenueDelivery {
+		handler.HedgeLedger.AddContracts(order.Symbol, SignedHedgeAmount(order.OrderType, volume))
+		return
+	}
+	handler.HedgeLedger.Add(order.BaseAsset, SignedHedgeAmount(order.OrderType, volume))
+}
+
+// 检查超时没有确认成交的对冲订单
+func (handler *OrderHandler) CheckHedges() {
+	// 限价单需要等待成交，超时后再下市价单
+	timeout := hedgeConfirmTimeout + handler.cfg.HedgeLimitTimeout
+	for _, record := range handler.HedgeTracker.Expired(common.GetTimestampInMS(), timeout) {
+		logger.Error("Op=HedgeTimeout, Venue=%s, Symbol=%s, ClientOrderID=%s, OriginClientOrderID=%s, Volume=%f, FilledVolume=%f",
+			record.Venue, record.Symbol, record.ClientOrderID, record.OriginClientOrderID, record.Volume, record.FilledVolume)
+		handler.sendOrderAlarm(fmt.Sprintf("对冲%ds内没有确认成交，%s %s %s %.4f，成交%.4f",
+			timeout/1000, record.Venue, record.Symbol, record.OrderType, record.Volume, record.FilledVolume))
+	}
+}
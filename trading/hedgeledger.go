@@ -0,0 +1,226 @@
+package trading
+
+import (
+	"cex/common/logger"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"sync"
+)
+
+// 未对冲数量的账本，按币种累计每次成交需要对冲的数量
+// 不够 MinHedgeSize 的成交、按精度取整舍掉的部分都记在账本中，累计超过 MinHedgeSize 之后再一起对冲
+// 同时按交易对记录已经对冲的币本位持仓，价格变化后用来重新计算需要的对冲数量，见 CheckRehedge
+// 跨期价差用币本位合约对冲，按对冲合约记录不够1张的部分
+// 配置了 HedgeLedgerPath 时每次变化都写入文件，重启之后继续累计
+type HedgeLedger struct {
+	mutex     sync.Mutex
+	path      string
+	residuals map[string]float64    // BaseAsset => 未对冲的数量，正数需要买入，负数需要卖出
+	books     map[string]*HedgeBook // 币本位交易对 => 对冲的持仓
+	contracts map[string]float64    // 对冲合约 => 未对冲的张数，正数需要买入，负数需要卖出
+}
+
+// 一个币本位交易对已经对冲的持仓
+type HedgeBook struct {
+	Contracts float64 // 币本位持仓，多正空负，单位：张
+	Hedged    float64 // 对冲的数量（Base Asset），买入为正，卖出为负
+}
+
+// 保存到文件的内容
+type hedgeLedgerFile struct {
+	Residuals map[string]float64
+	Books     map[string]*HedgeBook
+	Contracts map[string]float64
+}
+
+// 创建账本，path 不为空时从文件恢复
+func NewHedgeLedger(path string) *HedgeLedger {
+	ledger := &HedgeLedger{path: path, residuals: map[string]float64{}, books: map[string]*HedgeBook{}, contracts: map[string]float64{}}
+	if path == "" {
+		return ledger
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ledger
+	}
+	if err != nil {
+		panic(err)
+	}
+	// 文件损坏时不能当作没有未对冲的数量，需要人工处理
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		panic(err)
+	}
+	if isLegacyHedgeLedgerFile(fields) {
+		// 旧版本的文件只有 BaseAsset => 未对冲的数量，转换成现在的格式
+		if err := json.Unmarshal(data, &ledger.residuals); err != nil {
+			panic(err)
+		}
+		logger.Warn("hedge ledger %s is in the legacy format, migrate it", path)
+		ledger.save()
+	} else {
+		file := hedgeLedgerFile{Residuals: ledger.residuals, Books: ledger.books, Contracts: ledger.contracts}
+		if err := json.Unmarshal(data, &file); err != nil {
+			panic(err)
+		}
+		if file.Residuals != nil {
+			ledger.residuals = file.Residuals
+		}
+		if file.Books != nil {
+			ledger.books = file.Books
+		}
+		if file.Contracts != nil {
+			ledger.contracts = file.Contracts
+		}
+	}
+	for asset, residual := range ledger.residuals {
+		logger.Info("Op=HedgeLedgerLoad, Asset=%s, Residual=%f", asset, residual)
+	}
+	for symbol, book := range ledger.books {
+		logger.Info("Op=HedgeLedgerLoad, Symbol=%s, Contracts=%f, Hedged=%f", symbol, book.Contracts, book.Hedged)
+	}
+	for symbol, contracts := range ledger.contracts {
+		logger.Info("Op=HedgeLedgerLoad, Symbol=%s, ContractResidual=%f", symbol, contracts)
+	}
+	return ledger
+}
+
+// 有 hedgeLedgerFile 以外的字段时是旧版本的文件，字段名是币种
+func isLegacyHedgeLedgerFile(fields map[string]json.RawMessage) bool {
+	for name := range fields {
+		switch name {
+		case "Residuals", "Books", "Contracts":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// 对冲方向对应的数量符号，买入为正，卖出为负
+func SignedHedgeAmount(hedgeOrderType string, amount float64) float64 {
+	if hedgeOrderType == "sell" {
+		return -amount
+	}
+	return amount
+}
+
+// 记入需要对冲的数量，对冲下单失败、没有全部成交时把剩余的数量加回来
+func (ledger *HedgeLedger) Add(asset string, amount float64) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	ledger.residuals[asset] += amount
+	ledger.save()
+}
+
+// 记入一次成交需要对冲的数量，返回这次可以对冲的数量（带符号）
+// 可以对冲的数量按精度向零取整，绝对值不够 minHedgeSize 时返回0，数量留在账本中
+func (ledger *HedgeLedger) Settle(asset string, amount float64, precision int, minHedgeSize float64) float64 {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	hedgeAmount := settleResidual(ledger.residuals, asset, amount, precision, minHedgeSize)
+	ledger.save()
+	return hedgeAmount
+}
+
+// 记入对冲合约需要对冲的张数，返回这次可以对冲的整数张数（带符号），不够1张的部分留在账本中
+func (ledger *HedgeLedger) SettleContracts(symbol string, contracts float64) float64 {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	hedgeContracts := settleResidual(ledger.contracts, symbol, contracts, 0, 1)
+	ledger.save()
+	return hedgeContracts
+}
+
+// 对冲合约下单失败、没有全部成交时把剩余的张数加回来
+func (ledger *HedgeLedger) AddContracts(symbol string, contracts float64) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	ledger.contracts[symbol] += contracts
+	ledger.save()
+}
+
+// 对冲合约未对冲的张数
+func (ledger *HedgeLedger) ContractResidual(symbol string) float64 {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	return ledger.contracts[symbol]
+}
+
+// 把 amount 加到 key 的未对冲数量上，按精度向零取整后的部分可以对冲，绝对值不够 minHedgeSize 时全部留下
+func settleResidual(residuals map[string]float64, key string, amount float64, precision int, minHedgeSize float64) float64 {
+	residual := residuals[key] + amount
+	scale := math.Pow10(precision)
+	// 加上一个很小的数，避免 0.003 这样的数量因为浮点误差被舍成 0.002
+	hedgeAmount := math.Trunc(residual*scale+math.Copysign(1e-6, residual)) / scale
+	if hedgeAmount == 0 || math.Abs(hedgeAmount) < minHedgeSize {
+		hedgeAmount = 0
+	}
+	residuals[key] = residual - hedgeAmount
+	return hedgeAmount
+}
+
+// 当前未对冲的数量
+func (ledger *HedgeLedger) Residual(asset string) float64 {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	return ledger.residuals[asset]
+}
+
+// 开始记录交易对对冲的持仓，已经有记录时返回false
+func (ledger *HedgeLedger) InitBook(symbol string, contracts float64, hedged float64) bool {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	if _, ok := ledger.books[symbol]; ok {
+		return false
+	}
+	ledger.books[symbol] = &HedgeBook{Contracts: contracts, Hedged: hedged}
+	ledger.save()
+	return true
+}
+
+// 记入币本位成交的张数和对应的对冲数量，还没有开始记录时忽略，开始记录时会按持仓初始化
+func (ledger *HedgeLedger) Book(symbol string, contracts float64, hedged float64) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	book, ok := ledger.books[symbol]
+	if !ok {
+		return
+	}
+	book.Contracts += contracts
+	book.Hedged += hedged
+	ledger.save()
+}
+
+// 交易对对冲的持仓
+func (ledger *HedgeLedger) GetBook(symbol string) (HedgeBook, bool) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	book, ok := ledger.books[symbol]
+	if !ok {
+		return HedgeBook{}, false
+	}
+	return *book, true
+}
+
+// 写入临时文件再重命名，避免写到一半退出导致文件损坏，调用时需要持有锁
+func (ledger *HedgeLedger) save() {
+	if ledger.path == "" {
+		return
+	}
+	data, err := json.Marshal(hedgeLedgerFile{Residuals: ledger.residuals, Books: ledger.books, Contracts: ledger.contracts})
+	if err != nil {
+		logger.Error("save hedge ledger failed, message is %s", err.Error())
+		return
+	}
+	tmpPath := ledger.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		logger.Error("save hedge ledger failed, message is %s", err.Error())
+		return
+	}
+	if err := os.Rename(tmpPath, ledger.path); err != nil {
+		logger.Error("save hedge ledger failed, message is %s", err.Error())
+	}
+}
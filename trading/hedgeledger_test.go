@@ -0,0 +1,116 @@
+package trading
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHedgeLedgerSettle(t *testing.T) {
+	tests := []struct {
+		name         string
+		amounts      []float64
+		precision    int
+		minHedgeSize float64
+		hedged       float64 // 最后一次可以对冲的数量
+		residual     float64
+	}{
+		{"less than precision", []float64{0.0005}, 3, 0.001, 0, 0.0005},
+		{"accumulate to precision", []float64{0.0005, 0.0005}, 3, 0.001, 0.001, 0},
+		{"truncate to precision", []float64{0.0037}, 3, 0.001, 0.003, 0.0007},
+		{"float error", []float64{0.0006, 0.0024}, 3, 0.001, 0.003, 0},
+		{"less than min hedge size", []float64{0.004}, 3, 0.005, 0, 0.004},
+		{"sell", []float64{-0.0037}, 3, 0.001, -0.003, -0.0007},
+		{"opposite directions", []float64{0.0007, -0.0015}, 3, 0.001, 0, -0.0008},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := NewHedgeLedger("")
+			var hedged float64
+			for _, amount := range test.amounts {
+				hedged = ledger.Settle("BTC", amount, test.precision, test.minHedgeSize)
+			}
+			if math.Abs(hedged-test.hedged) > 1e-9 {
+				t.Errorf("hedged = %v, want %v", hedged, test.hedged)
+			}
+			if residual := ledger.Residual("BTC"); math.Abs(residual-test.residual) > 1e-9 {
+				t.Errorf("residual = %v, want %v", residual, test.residual)
+			}
+		})
+	}
+}
+
+func TestHedgeLedgerSettleContracts(t *testing.T) {
+	ledger := NewHedgeLedger("")
+	for i, want := range []float64{0, 0, 1, 0} {
+		if got := ledger.SettleContracts("BTCUSD_PERP", 0.4); got != want {
+			t.Errorf("settle %d = %v, want %v", i, got, want)
+		}
+	}
+	if residual := ledger.ContractResidual("BTCUSD_PERP"); math.Abs(residual-0.6) > 1e-9 {
+		t.Errorf("residual = %v, want 0.6", residual)
+	}
+}
+
+func TestHedgeLedgerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	ledger := NewHedgeLedger(path)
+	ledger.Add("BTC", 0.0005)
+	ledger.InitBook("BTCUSD_PERP", 2, -0.01)
+	ledger.Book("BTCUSD_PERP", 1, -0.005)
+	ledger.AddContracts("BTCUSD_PERP", -0.3)
+
+	restored := NewHedgeLedger(path)
+	if residual := restored.Residual("BTC"); residual != 0.0005 {
+		t.Errorf("residual = %v, want 0.0005", residual)
+	}
+	book, ok := restored.GetBook("BTCUSD_PERP")
+	if !ok || book.Contracts != 3 || math.Abs(book.Hedged+0.015) > 1e-9 {
+		t.Errorf("book = %+v, %t, want {3 -0.015}", book, ok)
+	}
+	if residual := restored.ContractResidual("BTCUSD_PERP"); residual != -0.3 {
+		t.Errorf("contract residual = %v, want -0.3", residual)
+	}
+}
+
+func TestHedgeLedgerLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		residuals map[string]float64
+		panics    bool
+	}{
+		{"empty", `{}`, map[string]float64{}, false},
+		{"current", `{"Residuals":{"BTC":0.0005},"Books":{}}`, map[string]float64{"BTC": 0.0005}, false},
+		{"legacy", `{"BTC":0.0005,"ETH":-0.002}`, map[string]float64{"BTC": 0.0005, "ETH": -0.002}, false},
+		{"corrupt", `{"BTC":`, nil, true},
+		{"unknown field", `{"Residuals":{"BTC":0.0005},"Other":{}}`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ledger.json")
+			if err := os.WriteFile(path, []byte(test.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if recovered := recover(); (recovered != nil) != test.panics {
+					t.Errorf("panic = %v, want panic %t", recovered, test.panics)
+				}
+			}()
+			ledger := NewHedgeLedger(path)
+			for asset, want := range test.residuals {
+				if residual := ledger.Residual(asset); residual != want {
+					t.Errorf("%s residual = %v, want %v", asset, residual, want)
+				}
+			}
+			// 旧版本的文件加载后按现在的格式保存
+			restored := NewHedgeLedger(path)
+			for asset, want := range test.residuals {
+				if residual := restored.Residual(asset); residual != want {
+					t.Errorf("%s restored residual = %v, want %v", asset, residual, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package trading
+
+import (
+	"cex/common/logger"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// 测试中的日志写到临时目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "cex-test")
+	if err != nil {
+		panic(err)
+	}
+	logger.InitLogger(filepath.Join(dir, "test.log"), zapcore.ErrorLevel)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
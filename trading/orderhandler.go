@@ -0,0 +1,663 @@
+package trading
+
+import (
+	"cex/client"
+	"cex/client/simulator"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 下单被拒绝后暂停交易对挂单的时间，单位：ms
+const (
+	insufficientMarginPause = 60 * 1000
+	unknownSymbolPause      = 10 * 60 * 1000
+)
+
+// 下单、撤单和对冲，所有策略共用
+// 配置和上下文在 Init 时传入，策略通过 engine.Engine 获取
+type OrderHandler struct {
+	cfg  *config.Config
+	ctxt *Context
+
+	BuyOrders  map[string]*common.OrderBook
+	SellOrders map[string]*common.OrderBook
+
+	// 只依赖交易接口，不依赖具体的交易所实现
+	DeliveryOrderClient client.OrderClient // 币本位，挂单
+	FuturesOrderClient  client.OrderClient // U本位
+	SpotOrderClient     client.OrderClient // 现货，对冲
+	MinAccuracy         float64
+
+	HedgeTracker *HedgeTracker // 对冲订单的成交情况
+	HedgeLedger  *HedgeLedger  // 还没有对冲的数量
+
+	// 是否在回测，回测时所有异步调用都改成同步，保证结果可以复现，需要在 Init 之前设置
+	Backtesting bool
+	// post only 被拒绝后改价时离盘口的距离，由策略设置，没有设置时改到盘口价格
+	RepriceGap func(symbol string) float64
+}
+
+// market 不为nil时使用模拟交易所
+func (handler *OrderHandler) Init(cfg *config.Config, context *Context, market *SimulatedMarket) {
+	handler.cfg = cfg
+	handler.ctxt = context
+	binanceConfig := client.Config{
+		AccessKey:    cfg.BinanceAPIKey,
+		SecretKey:    cfg.BinanceSecretKey,
+		APILimit:     cfg.APILimit,
+		LimitProcess: cfg.LimitProcess,
+	}
+	var deliveryClient *client.BinanceDeliveryClient
+	if market != nil {
+		// 使用模拟交易所
+		handler.DeliveryOrderClient = simulator.NewOrderClient(market.Delivery)
+		handler.FuturesOrderClient = simulator.NewOrderClient(market.Futures)
+		handler.SpotOrderClient = simulator.NewOrderClient(market.Spot)
+	} else {
+		deliveryClient = new(client.BinanceDeliveryClient)
+		futuresClient := new(client.BinanceFuturesClient)
+		spotClient := new(client.BinanceSpotClient)
+		handler.DeliveryOrderClient = deliveryClient
+		handler.FuturesOrderClient = futuresClient
+		handler.SpotOrderClient = spotClient
+	}
+	handler.DeliveryOrderClient.Init(binanceConfig)
+	handler.FuturesOrderClient.Init(binanceConfig)
+	handler.SpotOrderClient.Init(binanceConfig)
+
+	handler.BuyOrders = map[string]*common.OrderBook{}
+	handler.SellOrders = map[string]*common.OrderBook{}
+	for _, symbol := range context.Symbols {
+		handler.BuyOrders[symbol] = &common.OrderBook{}
+		handler.BuyOrders[symbol].Init()
+		handler.SellOrders[symbol] = &common.OrderBook{}
+		handler.SellOrders[symbol].Init()
+		// 设置交易对的杠杆
+		if deliveryClient != nil {
+			symbolCfg := cfg.SymbolConfigs[symbol]
+			deliveryClient.ChangeLeverage(symbol, symbolCfg.Leverage)
+		}
+	}
+
+	handler.MinAccuracy = cfg.MinAccuracy
+	handler.HedgeTracker = NewHedgeTracker(cfg.MinAccuracy)
+	// 模拟交易所的成交不能写入实盘的账本
+	ledgerPath := cfg.HedgeLedgerPath
+	if cfg.Simulated {
+		ledgerPath = ""
+	}
+	handler.HedgeLedger = NewHedgeLedger(ledgerPath)
+}
+
+// 异步执行，回测时同步执行
+func (handler *OrderHandler) RunAsync(f func()) {
+	if handler.Backtesting {
+		f()
+		return
+	}
+	go f()
+}
+
+// 取消订单（必须相同交易对）
+func (handler *OrderHandler) CancelOrdersByClientID(orders []*common.Order) {
+	clientOrderIDs := []string{}
+	clientOrderIDMap := make(map[string]*common.Order)
+	for _, order := range orders {
+		clientOrderIDs = append(clientOrderIDs, order.ClientOrderID)
+		clientOrderIDMap[order.ClientOrderID] = order
+	}
+
+	size := len(clientOrderIDs)
+	if size <= 0 {
+		return
+	}
+	symbol := orders[0].Symbol
+
+	// 每次最多取消10个订单
+	for i := 0; i < size; i += 10 {
+		end := i + 10
+		if end > size {
+			end = size
+		}
+		lst := clientOrderIDs[i:end]
+		successIDs, err := handler.DeliveryOrderClient.CancelOrdersByClientID(&lst, symbol)
+		for _, id := range successIDs {
+			_, ok := clientOrderIDMap[id]
+			if ok {
+				clientOrderIDMap[id].Status = common.CANCEL
+			}
+		}
+		// 超过频率限制时后面的批次也会失败，等下一轮再取消
+		if client.IsOrderErrorKind(err, client.ErrorKindRateLimited) {
+			logger.Warn("CancelOrdersByClientID %s rate limited, %d orders left", symbol, size-end)
+			break
+		}
+	}
+}
+
+func (handler *OrderHandler) CancelAllOrdersWithSymbol(symbol string) bool {
+	buyOrderBook := handler.BuyOrders[symbol]
+	sellOrderBook := handler.SellOrders[symbol]
+	logger.Debug("CancelAllOrders %s buy order size: %d, sell order size: %d", symbol,
+		buyOrderBook.Size(), sellOrderBook.Size())
+
+	if len(buyOrderBook.Data) > 0 || len(sellOrderBook.Data) > 0 {
+		return handler.DeliveryOrderClient.CancelAllOrders(symbol) == nil
+	}
+	return true
+}
+
+func (handler *OrderHandler) CancelAllOrders() bool {
+	logger.Info("CancelAllOrders order size: %d", handler.Size())
+	success := true
+	for _, symbol := range handler.ctxt.Symbols {
+		buyOrderBook := handler.BuyOrders[symbol]
+		sellOrderBook := handler.SellOrders[symbol]
+		if len(buyOrderBook.Data) > 0 || len(sellOrderBook.Data) > 0 {
+			if handler.DeliveryOrderClient.CancelAllOrders(symbol) != nil {
+				success = false
+			}
+		}
+	}
+	return success
+}
+
+func (handler *OrderHandler) CancelAllOrdersWithoutCheckOrderBook() bool {
+	logger.Info("CancelAllOrdersWithoutCheckOrderBook order size: %d", handler.Size())
+	success := true
+	for _, symbol := range handler.ctxt.Symbols {
+		if handler.DeliveryOrderClient.CancelAllOrders(symbol) != nil {
+			success = false
+		}
+	}
+	return success
+}
+
+func (handler *OrderHandler) Size() int {
+	size := 0
+	for _, orderbook := range handler.BuyOrders {
+		size += orderbook.Size()
+	}
+	for _, orderbook := range handler.SellOrders {
+		size += orderbook.Size()
+	}
+	return size
+}
+
+// 对冲订单，venue是对冲的市场，见 HedgeVenueSpot、HedgeVenueFutures
+// 配置了 HedgeSlippage 时先下限价单限制滑点，超时后剩余部分用市价单，见 FallbackLimitHedge
+func (handler *OrderHandler) PlaceHedgeOrder(order *common.Order, venue string) {
+	hedgeClient := handler.getHedgeClient(venue)
+	handler.HedgeTracker.Add(order, venue)
+	if limitClient, ok := hedgeClient.(client.LimitOrderClient); ok && handler.cfg.HedgeSlippage > 0 {
+		if handler.placeLimitHedge(limitClient, order, venue) {
+			return
+		}
+	}
+
+	// 这里的逻辑是用市价单来对冲订单
+	logger.Info("OrderDebug: Hedge op=New, venue=%s, %s", venue, order.FormatString())
+	err := placeWithRetry(func() error {
+		_, err := hedgeClient.PlaceMarketOrder(order)
+		return err
+	})
+	if err == nil {
+		return
+	}
+	handler.HedgeTracker.Remove(order.ClientOrderID)
+	handler.restoreHedgeResidual(venue, order, order.OrderVolume)
+	logger.Error("OrderDebug: Hedge op=Failed, venue=%s, %s, error is %s", venue, order.FormatString(), err.Error())
+	handler.sendOrderAlarm(fmt.Sprintf("对冲失败，%s %s %s %.4f，原因:%s", venue, order.Symbol, order.OrderType, order.OrderVolume, err.Error()))
+}
+
+// 限价对冲单，价格是盘口价格加上最大滑点，返回false时需要改用市价单
+func (handler *OrderHandler) placeLimitHedge(limitClient client.LimitOrderClient, order *common.Order, venue string) bool {
+	limitOrder := *order
+	limitOrder.OrderPrice = handler.getSlippageCapPrice(order.OrderPrice, order.OrderType, order.Precision[1])
+	logger.Info("OrderDebug: Hedge op=NewLimit, venue=%s, %s", venue, limitOrder.FormatString())
+	err := placeWithRetry(func() error {
+		_, err := limitClient.PlaceLimitOrder(&limitOrder)
+		return err
+	})
+	// 结果未知时订单可能已经存在，超时后撤单会确认
+	if err != nil && !client.IsOrderErrorKind(err, client.ErrorKindUnknownStatus) {
+		logger.Warn("OrderDebug: Hedge op=LimitFailed, venue=%s, %s, error is %s, use market order",
+			venue, limitOrder.FormatString(), err.Error())
+		return false
+	}
+	handler.HedgeTracker.SetFallbackAt(order.ClientOrderID, common.GetTimestampInMS()+handler.cfg.HedgeLimitTimeout)
+	return true
+}
+
+// 限价对冲单超时，撤单后没有成交的部分用市价单
+func (handler *OrderHandler) FallbackLimitHedge(order *common.Order) {
+	record := handler.HedgeTracker.Get(order.ClientOrderID)
+	if record == nil {
+		return
+	}
+	venue := record.Venue
+	limitClient, ok := handler.getHedgeClient(venue).(client.LimitOrderClient)
+	if !ok {
+		return
+	}
+	filledVolume, err := limitClient.CancelOrder(order)
+	if client.IsOrderErrorKind(err, client.ErrorKindOrderNotFound) {
+		// 已经全部成交
+		return
+	}
+	if err != nil {
+		logger.Error("OrderDebug: Hedge op=CancelLimitFailed, venue=%s, %s, error is %s", venue, order.FormatString(), err.Error())
+		handler.sendOrderAlarm(fmt.Sprintf("对冲限价单撤单失败，%s %s %s %.4f，原因:%s", venue, order.Symbol, order.OrderType, order.OrderVolume, err.Error()))
+		return
+	}
+
+	marketOrder := *order
+	marketOrder.OrderVolume = record.Volume - filledVolume
+	if strconv.FormatFloat(marketOrder.OrderVolume, 'f', order.Precision[0], 64) == strconv.FormatFloat(0, 'f', order.Precision[0], 64) {
+		return
+	}
+	marketOrder.ClientOrderID = common.GetHedgeClientOrderID(record.OriginClientOrderID)
+	handler.HedgeTracker.Fallback(order.ClientOrderID, marketOrder.ClientOrderID)
+	logger.Warn("OrderDebug: Hedge op=Fallback, venue=%s, filled=%f, %s", venue, filledVolume, marketOrder.FormatString())
+	err = placeWithRetry(func() error {
+		_, err := handler.getHedgeClient(venue).PlaceMarketOrder(&marketOrder)
+		return err
+	})
+	if err == nil {
+		return
+	}
+	handler.HedgeTracker.Remove(order.ClientOrderID)
+	handler.restoreHedgeResidual(venue, &marketOrder, marketOrder.OrderVolume)
+	logger.Error("OrderDebug: Hedge op=Failed, venue=%s, %s, error is %s", venue, marketOrder.FormatString(), err.Error())
+	handler.sendOrderAlarm(fmt.Sprintf("对冲失败，%s %s %s %.4f，原因:%s", venue, marketOrder.Symbol, marketOrder.OrderType, marketOrder.OrderVolume, err.Error()))
+}
+
+// 对冲市场的交易接口
+func (handler *OrderHandler) getHedgeClient(venue string) client.OrderClient {
+	switch venue {
+	case HedgeVenueFutures:
+		return handler.FuturesOrderClient
+	case HedgeVenueDelivery:
+		return handler.DeliveryOrderClient
+	}
+	return handler.SpotOrderClient
+}
+
+// 对冲下单，结果未知时用相同的ClientOrderID重试一次，返回重复订单说明之前的请求已经成功
+func placeWithRetry(place func() error) error {
+	err := place()
+	if client.IsOrderErrorKind(err, client.ErrorKindUnknownStatus) {
+		err = place()
+	}
+	if client.IsOrderErrorKind(err, client.ErrorKindDuplicateOrder) {
+		return nil
+	}
+	return err
+}
+
+// 从orderbook中删除订单
+func (handler *OrderHandler) DeleteByClientOrderID(symbol string, orderType string, clientOrderID string) {
+	if orderType == "buy" {
+		orderBook, ok := handler.BuyOrders[symbol]
+		if ok {
+			orderBook.DeleteByClientOrderID(clientOrderID)
+		}
+	} else if orderType == "sell" {
+		orderBook, ok := handler.SellOrders[symbol]
+		if ok {
+			orderBook.DeleteByClientOrderID(clientOrderID)
+		}
+	}
+}
+
+// 更新orderBook中订单的状态
+func (handler *OrderHandler) UpdateStatus(symbol string, orderType string, clientOrderID string, status int) {
+	if orderType == "buy" {
+		orderbook, ok := handler.BuyOrders[symbol]
+		if ok {
+			orderbook.UpdateStatus(clientOrderID, status)
+		}
+	} else if orderType == "sell" {
+		orderbook, ok := handler.SellOrders[symbol]
+		if ok {
+			orderbook.UpdateStatus(clientOrderID, status)
+		}
+	}
+}
+
+// 币本位接口频率限制剩余额度的比例，不支持查询的client返回1
+func (handler *OrderHandler) GetRateLimitHeadroom() float64 {
+	limitedClient, ok := handler.DeliveryOrderClient.(client.RateLimitedClient)
+	if !ok {
+		return 1
+	}
+	return limitedClient.GetRateLimiter().Headroom()
+}
+
+// 调用API下单，离盘口近的订单先下
+// 支持批量下单的client按批次依次下单，否则每个订单单独下单
+func (handler *OrderHandler) PlaceOrders(orders []*common.Order) {
+	if len(orders) == 0 {
+		return
+	}
+	handler.sortByDistance(orders)
+	batchClient, ok := handler.DeliveryOrderClient.(client.BatchOrderClient)
+	if !ok {
+		for i := 0; i < len(orders); i++ {
+			order := orders[i]
+			handler.RunAsync(func() { handler.PlaceOrder(order) })
+		}
+		return
+	}
+	handler.RunAsync(func() {
+		batchSize := batchClient.MaxBatchOrders()
+		for i := 0; i < len(orders); i += batchSize {
+			end := i + batchSize
+			if end > len(orders) {
+				end = len(orders)
+			}
+			handler.placeBatchOrders(batchClient, orders[i:end])
+		}
+	})
+}
+
+// 按离盘口的距离从近到远排序，距离用盘口价格的比例，不同交易对可以比较
+func (handler *OrderHandler) sortByDistance(orders []*common.Order) {
+	distances := make(map[*common.Order]float64, len(orders))
+	for _, order := range orders {
+		symbolContext := handler.ctxt.GetSymbolContext(order.Symbol)
+		if order.OrderType == "buy" {
+			distances[order] = (symbolContext.BidPrice - order.OrderPrice) / symbolContext.BidPrice
+		} else {
+			distances[order] = (order.OrderPrice - symbolContext.AskPrice) / symbolContext.AskPrice
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return distances[orders[i]] < distances[orders[j]]
+	})
+}
+
+// 调用API下单
+func (handler *OrderHandler) PlaceOrder(order *common.Order) {
+	orderBook := handler.addOrder(order)
+	orderID, err := handler.DeliveryOrderClient.PlaceOrderGTX(order)
+	if err != nil {
+		orderID, err = handler.retryPlaceOrder(order, err)
+	}
+	handler.updatePlacedOrder(orderBook, order, orderID, err)
+}
+
+// 批量下单，每个订单的结果单独处理，需要改价或者重试的订单单独重试
+func (handler *OrderHandler) placeBatchOrders(batchClient client.BatchOrderClient, orders []*common.Order) {
+	orderBooks := make([]*common.OrderBook, len(orders))
+	for i, order := range orders {
+		orderBooks[i] = handler.addOrder(order)
+	}
+	results := batchClient.PlaceBatchOrdersGTX(orders)
+	for i, order := range orders {
+		orderID, err := results[i].OrderID, results[i].Err
+		if err != nil {
+			orderID, err = handler.retryPlaceOrder(order, err)
+		}
+		handler.updatePlacedOrder(orderBooks[i], order, orderID, err)
+	}
+}
+
+// 生成ClientOrderID并加入orderbook，返回订单所在的orderbook
+func (handler *OrderHandler) addOrder(order *common.Order) *common.OrderBook {
+	order.CreateAt = time.Now().Unix()
+	order.ClientOrderID = common.GetClientOrderID()
+	logger.Info("OrderDebug: op=New, %s", order.FormatString())
+	orderBook := handler.BuyOrders[order.Symbol]
+	if order.OrderType == "sell" {
+		orderBook = handler.SellOrders[order.Symbol]
+	}
+	orderBook.Add(order)
+	return orderBook
+}
+
+// 根据下单结果更新订单状态，失败的订单从orderbook删除
+func (handler *OrderHandler) updatePlacedOrder(orderBook *common.OrderBook, order *common.Order, orderID string, err error) {
+	if err == nil {
+		order.OrderID = orderID
+		if order.Status == common.NEW {
+			order.Status = common.CREATE
+		}
+		return
+	}
+	handler.handlePlaceOrderError(order, err)
+	order.Status = common.FAILED
+	// 从队列删除
+	orderBook.DeleteByClientOrderID(order.ClientOrderID)
+}
+
+// 下单失败后重试，post only被拒绝时改价重试一次，结果未知时用相同的ClientOrderID重试一次
+// 重试返回重复订单说明之前的请求已经成功，orderID等订单推送更新
+func (handler *OrderHandler) retryPlaceOrder(order *common.Order, err error) (string, error) {
+	switch client.GetOrderErrorKind(err) {
+	case client.ErrorKindPostOnlyRejected:
+		if !handler.repriceOrder(order) {
+			return "", err
+		}
+	case client.ErrorKindUnknownStatus:
+		logger.Warn("OrderDebug: op=Retry, %s, error is %s", order.FormatString(), err.Error())
+	default:
+		return "", err
+	}
+
+	orderID, err := handler.DeliveryOrderClient.PlaceOrderGTX(order)
+	if client.IsOrderErrorKind(err, client.ErrorKindDuplicateOrder) {
+		return "", nil
+	}
+	return orderID, err
+}
+
+// post only被拒绝说明盘口已经移动，把价格改到当前盘口外一个间距，间距见 RepriceGap
+func (handler *OrderHandler) repriceOrder(order *common.Order) bool {
+	symbolContext := handler.ctxt.GetSymbolContext(order.Symbol)
+	if symbolContext == nil || symbolContext.BidPrice < handler.cfg.MinAccuracy {
+		return false
+	}
+	gapSize := 0.0
+	if handler.RepriceGap != nil {
+		gapSize = handler.RepriceGap(order.Symbol)
+	}
+	price := order.OrderPrice
+	if order.OrderType == "buy" {
+		price = math.Min(price, symbolContext.BidPrice) - gapSize
+	} else {
+		price = math.Max(price, symbolContext.AskPrice) + gapSize
+	}
+	if price <= 0 {
+		return false
+	}
+	logger.Info("OrderDebug: op=Reprice, %s, newPrice=%f", order.FormatString(), price)
+	order.OrderPrice = price
+	return true
+}
+
+// 按照错误的分类处理下单失败
+func (handler *OrderHandler) handlePlaceOrderError(order *common.Order, err error) {
+	switch kind := client.GetOrderErrorKind(err); kind {
+	case client.ErrorKindPostOnlyRejected, client.ErrorKindRateLimited:
+		// 改价后仍然被拒绝，或者额度不够，下一轮再挂
+		logger.Warn("OrderDebug: op=Failed, %s, error is %s", order.FormatString(), err.Error())
+	case client.ErrorKindInsufficientMargin:
+		handler.PauseSymbol(order.Symbol, insufficientMarginPause, err.Error())
+	case client.ErrorKindUnknownSymbol:
+		handler.PauseSymbol(order.Symbol, unknownSymbolPause, err.Error())
+	case client.ErrorKindUnknownStatus:
+		// 重试后仍然未知，订单可能已经在交易所，撤掉避免和本地的orderbook不一致
+		logger.Error("OrderDebug: op=Failed, %s, error is %s", order.FormatString(), err.Error())
+		clientOrderIDs := []string{order.ClientOrderID}
+		handler.DeliveryOrderClient.CancelOrdersByClientID(&clientOrderIDs, order.Symbol)
+	default:
+		logger.Error("OrderDebug: op=Failed, %s, error is %s", order.FormatString(), err.Error())
+		handler.sendOrderAlarm(fmt.Sprintf("下单失败，%s，原因:%s", order.Symbol, err.Error()))
+	}
+}
+
+// 暂停交易对挂单，duration单位：ms，到期后由CheckStatus恢复
+func (handler *OrderHandler) PauseSymbol(symbol string, duration int64, reason string) {
+	symbolContext := handler.ctxt.GetSymbolContext(symbol)
+	if symbolContext == nil {
+		return
+	}
+	symbolContext.PauseUntil = common.GetTimestampInMS() + duration
+	if symbolContext.Risk == 5 {
+		return
+	}
+	// 已经因为其他原因停止挂单的，不覆盖
+	if symbolContext.Risk != 0 && symbolContext.Risk != 3 {
+		return
+	}
+	symbolContext.Risk = 5
+	logger.Error("%s pause placing orders for %ds, reason: %s", symbol, duration/1000, reason)
+	handler.sendOrderAlarm(fmt.Sprintf("%s暂停挂单%ds，原因:%s", symbol, duration/1000, reason))
+}
+
+// 对账发现的成交交给策略对冲，volume 是持仓变化的张数，返回false表示策略不处理漏掉的成交
+type ReconcileFillFunc func(symbol string, orderType string, volume float64) bool
+
+// 用户数据流断线期间的订单、持仓消息已经丢失，通过 REST 核对
+// 交易所上已经没有的订单从orderbook删除，orderbook中没有的订单撤销；持仓不一致时差额交给 reconcileFill
+func (handler *OrderHandler) Reconcile(reconcileFill ReconcileFillFunc) {
+	logger.Warn("reconcile open orders and positions after user data stream reconnected")
+	// 刚下的订单可能还不在查询结果中，不处理
+	startAt := time.Now().Unix() - 2
+	for _, symbol := range handler.ctxt.Symbols {
+		openOrders, err := handler.DeliveryOrderClient.GetOpenOrders(symbol)
+		if err != nil {
+			logger.Error("reconcile %s get open orders failed, message is %s", symbol, err.Error())
+			continue
+		}
+		handler.reconcileOrders(symbol, openOrders, startAt)
+	}
+	handler.reconcilePositions(reconcileFill)
+}
+
+func (handler *OrderHandler) reconcileOrders(symbol string, openOrders []*common.Order, startAt int64) {
+	exchangeOrders := make(map[string]bool)
+	for _, order := range openOrders {
+		exchangeOrders[order.ClientOrderID] = true
+	}
+
+	localOrders := make(map[string]bool)
+	for _, orderBook := range []*common.OrderBook{handler.BuyOrders[symbol], handler.SellOrders[symbol]} {
+		var missing []string
+		orderBook.Mutex.RLock()
+		for _, order := range orderBook.Data {
+			localOrders[order.ClientOrderID] = true
+			if order.Status == common.NEW || order.CreateAt >= startAt || exchangeOrders[order.ClientOrderID] {
+				continue
+			}
+			missing = append(missing, order.ClientOrderID)
+		}
+		orderBook.Mutex.RUnlock()
+		for _, clientOrderID := range missing {
+			logger.Warn("reconcile %s order %s is not open on exchange, remove it", symbol, clientOrderID)
+			orderBook.DeleteByClientOrderID(clientOrderID)
+		}
+	}
+
+	var unknownOrders []*common.Order
+	for _, order := range openOrders {
+		if !localOrders[order.ClientOrderID] {
+			logger.Warn("reconcile %s order is unknown, cancel it, %s", symbol, order.FormatString())
+			unknownOrders = append(unknownOrders, order)
+		}
+	}
+	handler.CancelOrdersByClientID(unknownOrders)
+}
+
+// 断线期间的成交没有对冲，持仓的差额就是漏掉的成交
+func (handler *OrderHandler) reconcilePositions(reconcileFill ReconcileFillFunc) {
+	positions, err := handler.DeliveryOrderClient.GetPositions()
+	if err != nil {
+		logger.Error("reconcile get positions failed, message is %s", err.Error())
+		return
+	}
+	account := handler.ctxt.Accounts.GetAccount(handler.cfg.Exchange, handler.cfg.SwapType)
+	for _, position := range positions {
+		if !common.InArray(position.Symbol, handler.ctxt.Symbols) {
+			continue
+		}
+		localPosition := account.GetPositionsInfo(position.Symbol).Position
+		delta := position.PositionAmt - localPosition
+		if math.Abs(delta) < handler.cfg.MinAccuracy {
+			continue
+		}
+		logger.Warn("reconcile %s position changed from %f to %f", position.Symbol, localPosition, position.PositionAmt)
+		account.UpdatePosition(position.Symbol, position.PositionAmt)
+		orderType := "buy"
+		if delta < 0 {
+			orderType = "sell"
+		}
+		// 和成交消息一样由策略决定怎么对冲
+		if !reconcileFill(position.Symbol, orderType, math.Abs(delta)) {
+			logger.Warn("reconcile %s %s %f is not hedged, strategy does not handle missed fills",
+				position.Symbol, orderType, math.Abs(delta))
+		}
+	}
+}
+
+// 下单相关的报警，最多1分钟发一次
+func (handler *OrderHandler) sendOrderAlarm(message string) {
+	common.SendMessgeWithInterval(handler.ctxt.TelegramBot, handler.cfg.TgChatID, message, 60*1000)
+}
+
+// 处理币本位的订单和持仓消息，挂单成交时调用 onFill，volume 是这次成交的张数，onFill 为 nil 时成交不对冲
+func (handler *OrderHandler) HandleDeliveryOrder(resp *client.OrderWSResponse, onFill func(symbol string, orderType string, volume float64, clientOrderID string)) {
+	context := handler.ctxt
+	config := handler.cfg
+	symbol := resp.Order.Symbol
+
+	logger.Info("binance delivery order resp is: %+v", resp)
+	if resp.MsgType == "ORDER_TRADE_UPDATE" {
+		orderType := resp.Order.OrderType
+		clientOrderID := resp.Order.ClientOrderID
+		// 订单成交
+		if resp.Status == "PARTIALLY_FILLED" || resp.Status == "FILLED" {
+			deliveryContext := context.GetSymbolContext(resp.Order.Symbol)
+			spotPriceItem := context.GetPriceItem(config.Exchange, symbol, "spot")
+			logger.Info("Op=Fill, Exchange=Binance, Direction=%s, filled price=%f, amount=%f, OrderID=%d, ClientOrderID=%s, BuyPrice=%.2f, SellPrice=%.2f, Symbol=%s, sBidPrice=%.4f, sAskPrice=%.4f",
+				resp.Order.OrderType, resp.Order.OrderPrice, resp.Order.OrderVolume, resp.Order.OrderID,
+				resp.Order.ClientOrderID, deliveryContext.BidPrice, deliveryContext.AskPrice,
+				symbol, spotPriceItem.BidPrice, spotPriceItem.AskPrice)
+
+			if onFill != nil {
+				onFill(symbol, orderType, resp.Order.OrderVolume, clientOrderID)
+			}
+
+			if resp.Status == "FILLED" {
+				handler.DeleteByClientOrderID(symbol, orderType, clientOrderID)
+			}
+		} else if resp.Status == "EXPIRED" {
+			logger.Info("EXPIRED, order=%s", resp.Order.FormatString())
+			handler.DeleteByClientOrderID(symbol, orderType, clientOrderID)
+		} else if resp.Status == "CANCELED" {
+			logger.Info("CANCELED, order=%s", resp.Order.FormatString())
+			handler.DeleteByClientOrderID(symbol, orderType, clientOrderID)
+		} else if resp.Status == "NEW" {
+			logger.Info("NEW, Exchange=Binance, Direction=%s, original price=%f, original amount=%f, OrderID=%s, ClientOrderID=%s",
+				orderType, resp.Order.OrderPrice, resp.Order.OrderVolume, resp.Order.OrderID, clientOrderID)
+			handler.UpdateStatus(symbol, orderType, resp.Order.ClientOrderID, common.CREATED)
+		}
+	} else if resp.MsgType == "ACCOUNT_UPDATE" {
+		// ACCOUNT_UPDATE 返回的仓位是全量信息
+		if resp.Status == "ORDER_UPDATE" {
+			symbol := resp.Symbol
+			account := context.Accounts.GetAccount(resp.Exchange, "swap_cross")
+
+			account.UpdatePosition(symbol, resp.Position)
+			logger.Warn("Binance position update, Symbol: %s, PositionMargin=%f",
+				symbol, resp.Position)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package trading
+
+import (
+	"cex/common/logger"
+	"math"
+)
+
+// 币本位是反向合约，一张合约对应的币是 Cont / price，价格变化后成交时的对冲数量就不对了
+// 按当前价格重新计算持仓需要的对冲数量，偏差超过 RehedgeBand 时补上差额
+func (handler *OrderHandler) CheckRehedge() {
+	if handler.cfg.FunctionHedge != 1 {
+		return
+	}
+	account := handler.ctxt.Accounts.GetAccount(handler.cfg.Exchange, handler.cfg.SwapType)
+	for _, symbol := range handler.ctxt.Symbols {
+		symbolCfg := handler.cfg.SymbolConfigs[symbol]
+		if symbolCfg.RehedgeBand <= 0 {
+			continue
+		}
+		// 对冲单还没有结束时不调整，避免和还在执行的对冲重叠，下次再检查
+		if handler.HedgeTracker.HasPending(symbolCfg.BaseAsset) {
+			logger.Debug("check rehedge %s has pending hedges, skip it", symbol)
+			continue
+		}
+		price := handler.getDeltaPrice(symbol)
+		if price <= handler.cfg.MinAccuracy {
+			continue
+		}
+		cont := float64(symbolCfg.Cont)
+		ledger := handler.HedgeLedger
+		book, ok := ledger.GetBook(symbol)
+		if !ok {
+			// 第一次检查时认为当前的持仓已经按当前价格对冲
+			position := account.GetPositionsInfo(symbol).Position
+			if ledger.InitBook(symbol, position, -position*cont/price) {
+				logger.Info("Op=RehedgeInit, Symbol=%s, Contracts=%f, Price=%f", symbol, position, price)
+			}
+			continue
+		}
+
+		// 币本位空头需要买入对冲，多头需要卖出
+		required := -book.Contracts * cont / price
+		drift := required - book.Hedged
+		if math.Abs(drift) <= symbolCfg.RehedgeBand {
+			continue
+		}
+		hedgeOrderType := "buy"
+		if drift < 0 {
+			hedgeOrderType = "sell"
+		}
+		venue := handler.chooseHedgeVenue(symbol, hedgeOrderType)
+		logger.Warn("Op=Rehedge, Symbol=%s, Contracts=%f, Price=%f, Required=%f, Hedged=%f, Drift=%f, Venue=%s",
+			symbol, book.Contracts, price, required, book.Hedged, drift, venue)
+		ledger.Book(symbol, 0, drift)
+		handler.hedgeResidual(symbol, venue, drift, "rehedge")
+	}
+}
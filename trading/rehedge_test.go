@@ -0,0 +1,58 @@
+package trading
+
+import (
+	"cex/common"
+	"cex/config"
+	"math"
+	"testing"
+)
+
+func TestCheckRehedge(t *testing.T) {
+	// 成交时按 20000 对冲，每张 100 USD，MinHedgeSize 足够大，补的对冲数量留在账本中
+	tests := []struct {
+		name      string
+		contracts float64
+		price     float64
+		pending   bool    // 是否有还没有结束的对冲单
+		wantDrift float64 // 买入为正，卖出为负
+	}{
+		{"short position price up sells", -10, 25000, false, -0.01},
+		{"short position price down buys", -10, 16000, false, 0.0125},
+		{"long position price up buys", 10, 25000, false, 0.01},
+		{"long position price down sells", 10, 16000, false, -0.0125},
+		{"within band", -10, 20010, false, 0},
+		{"pending hedge waits", -10, 25000, true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Exchange:      "Binance",
+				SwapType:      "swap_cross",
+				MinAccuracy:   1e-8,
+				FunctionHedge: 1,
+				Symbols:       []string{"BTCUSD_PERP"},
+				SymbolConfigs: map[string]config.SymbolConfig{"BTCUSD_PERP": {
+					Cont: 100, BaseAsset: "BTC", Precision: [2]int{3, 2}, MinHedgeSize: 1, RehedgeBand: 0.001,
+				}},
+			}
+			context := &Context{cfg: cfg, Symbols: cfg.Symbols, Prices: PriceData{Items: map[string]*PriceDataItem{
+				common.FormatPriceName(cfg.Exchange, "BTCUSD_PERP", "spot"): {Symbol: "BTCUSD_PERP", BidPrice: test.price, AskPrice: test.price},
+			}}}
+			ledger := NewHedgeLedger("")
+			handler := &OrderHandler{cfg: cfg, ctxt: context, HedgeTracker: NewHedgeTracker(cfg.MinAccuracy), HedgeLedger: ledger}
+			ledger.InitBook("BTCUSD_PERP", test.contracts, -test.contracts*100/20000)
+			if test.pending {
+				handler.HedgeTracker.Add(&common.Order{Symbol: "BTCUSD_PERP", BaseAsset: "BTC", ClientOrderID: "hedge"}, HedgeVenueSpot)
+			}
+
+			handler.CheckRehedge()
+			if residual := ledger.Residual("BTC"); math.Abs(residual-test.wantDrift) > 1e-9 {
+				t.Errorf("drift = %v, want %v", residual, test.wantDrift)
+			}
+			book, _ := ledger.GetBook("BTCUSD_PERP")
+			if wantHedged := -test.contracts*100/20000 + test.wantDrift; math.Abs(book.Hedged-wantHedged) > 1e-9 {
+				t.Errorf("hedged = %v, want %v", book.Hedged, wantHedged)
+			}
+		})
+	}
+}
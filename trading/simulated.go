@@ -0,0 +1,67 @@
+package trading
+
+import (
+	"cex/client/simulator"
+	"cex/common"
+	"cex/common/logger"
+	"cex/config"
+	"time"
+)
+
+// 模拟交易所，配置了Simulated时使用，可以离线跑通整个流程
+type SimulatedMarket struct {
+	Delivery *simulator.Exchange
+	Futures  *simulator.Exchange
+	Spot     *simulator.Exchange
+	Feed     *simulator.RandomWalkFeed
+}
+
+func NewSimulatedMarket(cfg *config.Config) *SimulatedMarket {
+	market := &SimulatedMarket{
+		Delivery: simulator.NewExchange(cfg.Exchange, "delivery", cfg.QuoteAsset),
+		Futures:  simulator.NewExchange(cfg.Exchange, "futures", cfg.QuoteAsset),
+		Spot:     simulator.NewExchange(cfg.Exchange, "spot", cfg.QuoteAsset),
+		Feed:     simulator.NewRandomWalkFeed(time.Now().UnixNano()),
+	}
+
+	for _, symbol := range cfg.Symbols {
+		// 回放时行情来自录制文件
+		if cfg.ReplayPath != "" {
+			break
+		}
+		price, ok := cfg.SimulatedPrices[symbol]
+		if !ok || price <= 0 {
+			logger.Warn("simulated price of %s is not configured, skip it", symbol)
+			continue
+		}
+		// U本位和现货共用一个标的，e.g. BTCBUSD
+		underlying := common.FormatFuturesSymbol(symbol, cfg.QuoteAsset)
+		market.Feed.AddUnderlying(underlying, price)
+		market.Feed.AddSymbol(underlying, market.Delivery, symbol, cfg.SimulatedBasis[symbol])
+		market.Feed.AddSymbol(underlying, market.Futures, underlying, 0)
+		market.Feed.AddSymbol(underlying, market.Spot, underlying, 0)
+	}
+
+	for symbol, tickSize := range cfg.SimulatedTickSize {
+		market.Delivery.SetTickSize(symbol, tickSize)
+	}
+
+	exchanges := map[string]*simulator.Exchange{
+		"delivery": market.Delivery,
+		"futures":  market.Futures,
+		"spot":     market.Spot,
+	}
+	for product, balances := range cfg.SimulatedBalances {
+		exchange, ok := exchanges[product]
+		if !ok {
+			continue
+		}
+		for asset, amount := range balances {
+			exchange.SetBalance(asset, amount)
+		}
+	}
+
+	// 先走一步，保证订单簿中有深度
+	market.Feed.Step()
+	return market
+}
@@ -0,0 +1,75 @@
+package trading
+
+import (
+	"cex/config"
+	"fmt"
+	"math"
+)
+
+// 挂单数量的计算方式
+const (
+	SizeModeContracts = "contracts" // 每单 ContractNum 张
+	SizeModeNotional  = "notional"  // 每单 OrderNotional USD
+	SizeModeEquity    = "equity"    // 每单是币本位账户权益的 OrderEquityPercent
+)
+
+// 检查挂单数量的配置，配置错误时不能启动
+func InitOrderSizing(cfg *config.Config) {
+	for _, symbol := range cfg.Symbols {
+		symbolCfg := cfg.SymbolConfigs[symbol]
+		switch symbolCfg.SizeMode {
+		case "", SizeModeContracts:
+		case SizeModeNotional:
+			if symbolCfg.OrderNotional <= 0 {
+				panic(fmt.Sprintf("size mode %s needs OrderNotional of %s", symbolCfg.SizeMode, symbol))
+			}
+		case SizeModeEquity:
+			if symbolCfg.OrderEquityPercent <= 0 {
+				panic(fmt.Sprintf("size mode %s needs OrderEquityPercent of %s", symbolCfg.SizeMode, symbol))
+			}
+		default:
+			panic(fmt.Sprintf("unknown size mode %s of %s", symbolCfg.SizeMode, symbol))
+		}
+		if symbolCfg.Cont <= 0 && (symbolCfg.SizeMode == SizeModeNotional || symbolCfg.SizeMode == SizeModeEquity || symbolCfg.MaxPositionNotional > 0) {
+			panic(fmt.Sprintf("%s needs Cont to convert USD to contracts", symbol))
+		}
+	}
+}
+
+// 第 level 档（从1开始）挂单的张数，price 是币本位的价格，用来把权益换算成 USD
+// 每往外一档乘以 LevelSizeFactor，按张向下取整，最少1张
+func (handler *OrderHandler) GetOrderSize(symbol string, level int, price float64) float64 {
+	symbolCfg := handler.cfg.SymbolConfigs[symbol]
+	size := handler.getBaseOrderSize(symbol, price)
+	if symbolCfg.LevelSizeFactor > 0 && level > 1 {
+		size *= math.Pow(symbolCfg.LevelSizeFactor, float64(level-1))
+	}
+	// 加上一个很小的数，避免 3 这样的张数因为浮点误差被舍成 2
+	return math.Max(1, math.Floor(size+1e-9))
+}
+
+// 第一档挂单的张数，没有取整
+func (handler *OrderHandler) getBaseOrderSize(symbol string, price float64) float64 {
+	symbolCfg := handler.cfg.SymbolConfigs[symbol]
+	switch symbolCfg.SizeMode {
+	case SizeModeNotional:
+		return symbolCfg.OrderNotional / float64(symbolCfg.Cont)
+	case SizeModeEquity:
+		equity := handler.ctxt.Accounts.GetAccount(handler.cfg.Exchange, handler.cfg.SwapType).Equities[symbolCfg.BaseAsset]
+		// 还没有获取到账户信息时按 ContractNum 挂单
+		if equity <= 0 || price < handler.cfg.MinAccuracy {
+			return float64(symbolCfg.ContractNum)
+		}
+		return equity * price * symbolCfg.OrderEquityPercent / float64(symbolCfg.Cont)
+	}
+	return float64(symbolCfg.ContractNum)
+}
+
+// 单方向最多持仓的张数
+func (handler *OrderHandler) GetMaxPosition(symbol string) float64 {
+	symbolCfg := handler.cfg.SymbolConfigs[symbol]
+	if symbolCfg.MaxPositionNotional > 0 {
+		return math.Floor(symbolCfg.MaxPositionNotional/float64(symbolCfg.Cont) + 1e-9)
+	}
+	return float64(symbolCfg.MaxContractNum)
+}
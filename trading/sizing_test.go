@@ -0,0 +1,97 @@
+package trading
+
+import (
+	"cex/config"
+	"testing"
+)
+
+// 只有 BTCUSD_PERP 的下单处理，账户信息是空的
+func newSizingOrderHandler(symbolCfg config.SymbolConfig) *OrderHandler {
+	cfg := &config.Config{
+		Exchange:      "Binance",
+		SwapType:      "swap_cross",
+		MinAccuracy:   1e-8,
+		Symbols:       []string{"BTCUSD_PERP"},
+		SymbolConfigs: map[string]config.SymbolConfig{"BTCUSD_PERP": symbolCfg},
+	}
+	context := &Context{cfg: cfg, Symbols: cfg.Symbols}
+	context.Accounts.AddAccount(cfg.Exchange, cfg.SwapType)
+	return &OrderHandler{cfg: cfg, ctxt: context}
+}
+
+func TestGetOrderSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		symbolCfg config.SymbolConfig
+		equity    float64 // BaseAsset 的保证金余额
+		level     int
+		price     float64
+		want      float64
+	}{
+		{"contracts", config.SymbolConfig{ContractNum: 3}, 0, 1, 20000, 3},
+		{"contracts level factor", config.SymbolConfig{ContractNum: 3, LevelSizeFactor: 1.5}, 0, 3, 20000, 6},
+		{"level factor float error", config.SymbolConfig{ContractNum: 100, LevelSizeFactor: 0.29}, 0, 2, 20000, 29},
+		{"level factor shrinks to 1", config.SymbolConfig{ContractNum: 2, LevelSizeFactor: 0.5}, 0, 3, 20000, 1},
+		{"notional", config.SymbolConfig{SizeMode: SizeModeNotional, OrderNotional: 1050, Cont: 100}, 0, 1, 20000, 10},
+		{"notional less than 1 contract", config.SymbolConfig{SizeMode: SizeModeNotional, OrderNotional: 50, Cont: 100}, 0, 1, 20000, 1},
+		{"equity", config.SymbolConfig{SizeMode: SizeModeEquity, OrderEquityPercent: 0.05, Cont: 100, BaseAsset: "BTC"}, 0.5, 1, 20000, 5},
+		{"equity level factor", config.SymbolConfig{SizeMode: SizeModeEquity, OrderEquityPercent: 0.05, Cont: 100, BaseAsset: "BTC", LevelSizeFactor: 2}, 0.5, 2, 20000, 10},
+		{"equity not ready", config.SymbolConfig{SizeMode: SizeModeEquity, OrderEquityPercent: 0.05, Cont: 100, BaseAsset: "BTC", ContractNum: 2}, 0, 1, 20000, 2},
+		{"equity without price", config.SymbolConfig{SizeMode: SizeModeEquity, OrderEquityPercent: 0.05, Cont: 100, BaseAsset: "BTC", ContractNum: 2}, 0.5, 1, 0, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newSizingOrderHandler(test.symbolCfg)
+			handler.ctxt.Accounts.GetAccount(handler.cfg.Exchange, handler.cfg.SwapType).Equities = map[string]float64{"BTC": test.equity}
+			if size := handler.GetOrderSize("BTCUSD_PERP", test.level, test.price); size != test.want {
+				t.Errorf("size = %v, want %v", size, test.want)
+			}
+		})
+	}
+}
+
+func TestGetMaxPosition(t *testing.T) {
+	tests := []struct {
+		name      string
+		symbolCfg config.SymbolConfig
+		want      float64
+	}{
+		{"contracts", config.SymbolConfig{MaxContractNum: 20, Cont: 100}, 20},
+		{"notional", config.SymbolConfig{MaxContractNum: 20, MaxPositionNotional: 1050, Cont: 100}, 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newSizingOrderHandler(test.symbolCfg)
+			if maxPosition := handler.GetMaxPosition("BTCUSD_PERP"); maxPosition != test.want {
+				t.Errorf("max position = %v, want %v", maxPosition, test.want)
+			}
+		})
+	}
+}
+
+func TestInitOrderSizing(t *testing.T) {
+	tests := []struct {
+		name      string
+		symbolCfg config.SymbolConfig
+		panics    bool
+	}{
+		{"default", config.SymbolConfig{ContractNum: 1}, false},
+		{"notional", config.SymbolConfig{SizeMode: SizeModeNotional, OrderNotional: 100, Cont: 100}, false},
+		{"notional without OrderNotional", config.SymbolConfig{SizeMode: SizeModeNotional, Cont: 100}, true},
+		{"equity without OrderEquityPercent", config.SymbolConfig{SizeMode: SizeModeEquity, Cont: 100}, true},
+		{"notional without Cont", config.SymbolConfig{SizeMode: SizeModeNotional, OrderNotional: 100}, true},
+		{"max position without Cont", config.SymbolConfig{MaxPositionNotional: 1000}, true},
+		{"unknown mode", config.SymbolConfig{SizeMode: "usd"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newSizingOrderHandler(test.symbolCfg)
+			defer func() {
+				if recovered := recover(); (recovered != nil) != test.panics {
+					t.Errorf("panic = %v, want panic %t", recovered, test.panics)
+				}
+			}()
+			InitOrderSizing(handler.cfg)
+		})
+	}
+}
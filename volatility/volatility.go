@@ -0,0 +1,270 @@
+// 价格波动的估计，按时间采样，所有估计值都是窗口内的波动占价格的比例
+package volatility
+
+import (
+	"fmt"
+	"math"
+)
+
+// 估计方法
+const (
+	Range    = "range"    // 窗口内的 (最高价 - 最低价) / 最新价
+	EWMA     = "ewma"     // 对数收益率方差的指数移动平均，换算到窗口长度
+	Realized = "realized" // 窗口内对数收益率的平方和开根号
+)
+
+// 波动估计，timestamp 单位：ms，调用方保证按时间顺序传入
+type Estimator interface {
+	Add(timestamp int64, price float64)
+	// 返回窗口内的波动，数据不够时 ok 为 false
+	Value(timestamp int64) (value float64, ok bool)
+}
+
+// 按名称创建估计方法，window 是窗口长度，interval 是采样间隔，单位：ms
+func New(name string, window int64, interval int64) (Estimator, error) {
+	if window <= 0 || interval <= 0 || interval > window {
+		return nil, fmt.Errorf("invalid volatility window %d or interval %d", window, interval)
+	}
+	switch name {
+	case Range:
+		return &rangeEstimator{window: window}, nil
+	case EWMA:
+		return newEWMAEstimator(window, interval), nil
+	case Realized:
+		return &realizedEstimator{window: window}, nil
+	}
+	return nil, fmt.Errorf("unknown volatility estimator %s", name)
+}
+
+// 一次采样的价格，保存到文件后重启时用来恢复窗口
+type Sample struct {
+	Timestamp int64
+	Price     float64
+}
+
+// 按固定时间间隔采样，两次采样之间的价格丢弃
+// 数据积累的时间超过 warmup 之后才返回估计值，保留窗口内的采样价格
+type Sampler struct {
+	estimator Estimator
+	window    int64
+	interval  int64
+	warmup    int64
+	first     int64  // 第一次采样的时间，0表示还没有采样
+	last      int64  // 上一次采样的时间
+	history   window // 窗口内采样的价格
+}
+
+func NewSampler(estimator Estimator, window int64, interval int64, warmup int64) *Sampler {
+	return &Sampler{estimator: estimator, window: window, interval: interval, warmup: warmup}
+}
+
+// 距离上一次采样超过 interval 时采样，返回是否采样
+func (sampler *Sampler) Update(timestamp int64, price float64) bool {
+	if price <= 0 {
+		return false
+	}
+	if sampler.first != 0 && timestamp-sampler.last < sampler.interval {
+		return false
+	}
+	if sampler.first == 0 {
+		sampler.first = timestamp
+	}
+	sampler.last = timestamp
+	sampler.estimator.Add(timestamp, price)
+	sampler.history.push(sample{timestamp, price})
+	for sampler.history.front().timestamp <= timestamp-sampler.window {
+		sampler.history.popFront()
+	}
+	return true
+}
+
+// 用历史价格预热，比上一次采样早的价格忽略，返回使用的数量
+func (sampler *Sampler) Seed(samples []Sample) int {
+	count := 0
+	for _, s := range samples {
+		if sampler.Update(s.Timestamp, s.Price) {
+			count++
+		}
+	}
+	return count
+}
+
+// 窗口内采样的价格，按时间排序
+func (sampler *Sampler) Samples() []Sample {
+	samples := make([]Sample, 0, sampler.history.len())
+	for _, s := range sampler.history.samples[sampler.history.head:] {
+		samples = append(samples, Sample{Timestamp: s.timestamp, Price: s.value})
+	}
+	return samples
+}
+
+// 上一次采样的时间，没有采样时返回0
+func (sampler *Sampler) Last() int64 {
+	return sampler.last
+}
+
+// 当前的波动，数据积累不够 warmup 时 ok 为 false
+func (sampler *Sampler) Value(timestamp int64) (float64, bool) {
+	if sampler.first == 0 || timestamp-sampler.first < sampler.warmup {
+		return 0, false
+	}
+	return sampler.estimator.Value(timestamp)
+}
+
+type sample struct {
+	timestamp int64
+	value     float64
+}
+
+// 按时间过期的队列，只在队尾添加，从队头删除
+type window struct {
+	samples []sample
+	head    int
+}
+
+func (w *window) push(s sample) {
+	w.samples = append(w.samples, s)
+}
+
+func (w *window) len() int {
+	return len(w.samples) - w.head
+}
+
+func (w *window) front() sample {
+	return w.samples[w.head]
+}
+
+func (w *window) back() sample {
+	return w.samples[len(w.samples)-1]
+}
+
+func (w *window) popFront() sample {
+	s := w.samples[w.head]
+	w.head++
+	// 删除的数量超过一半时整理一次，避免底层数组一直增长
+	if w.head > len(w.samples)/2 {
+		w.samples = append(w.samples[:0], w.samples[w.head:]...)
+		w.head = 0
+	}
+	return s
+}
+
+func (w *window) popBack() {
+	w.samples = w.samples[:len(w.samples)-1]
+}
+
+// 单调队列记录窗口内的最高价和最低价，每个价格最多进出队列一次
+type rangeEstimator struct {
+	window int64
+	maxs   window // 价格单调递减，队头是最高价
+	mins   window // 价格单调递增，队头是最低价
+	last   float64
+}
+
+func (estimator *rangeEstimator) Add(timestamp int64, price float64) {
+	for estimator.maxs.len() > 0 && estimator.maxs.back().value <= price {
+		estimator.maxs.popBack()
+	}
+	estimator.maxs.push(sample{timestamp, price})
+	for estimator.mins.len() > 0 && estimator.mins.back().value >= price {
+		estimator.mins.popBack()
+	}
+	estimator.mins.push(sample{timestamp, price})
+	estimator.last = price
+	estimator.expire(timestamp)
+}
+
+func (estimator *rangeEstimator) expire(timestamp int64) {
+	for estimator.maxs.len() > 0 && estimator.maxs.front().timestamp <= timestamp-estimator.window {
+		estimator.maxs.popFront()
+	}
+	for estimator.mins.len() > 0 && estimator.mins.front().timestamp <= timestamp-estimator.window {
+		estimator.mins.popFront()
+	}
+}
+
+func (estimator *rangeEstimator) Value(timestamp int64) (float64, bool) {
+	estimator.expire(timestamp)
+	if estimator.maxs.len() == 0 || estimator.mins.len() == 0 {
+		return 0, false
+	}
+	return (estimator.maxs.front().value - estimator.mins.front().value) / estimator.last, true
+}
+
+// 对数收益率平方的指数移动平均，span 是窗口内的采样次数，系数 2 / (span + 1)
+// 两次采样间隔不是 interval 时（预热的K线、价格中断），收益率的平方按时间折算成一个 interval 的方差，系数按间隔的次数折算
+type ewmaEstimator struct {
+	interval  int64
+	span      float64
+	alpha     float64
+	variance  float64 // 一个 interval 的方差
+	count     int
+	last      float64
+	timestamp int64
+}
+
+func newEWMAEstimator(window int64, interval int64) *ewmaEstimator {
+	span := float64(window / interval)
+	return &ewmaEstimator{interval: interval, span: span, alpha: 2 / (span + 1)}
+}
+
+func (estimator *ewmaEstimator) Add(timestamp int64, price float64) {
+	if estimator.last > 0 && timestamp > estimator.timestamp {
+		r := math.Log(price / estimator.last)
+		steps := float64(timestamp-estimator.timestamp) / float64(estimator.interval)
+		variance := r * r / steps
+		if estimator.count == 0 {
+			estimator.variance = variance
+		} else {
+			alpha := 1 - math.Pow(1-estimator.alpha, steps)
+			estimator.variance = alpha*variance + (1-alpha)*estimator.variance
+		}
+		estimator.count++
+	}
+	estimator.last = price
+	estimator.timestamp = timestamp
+}
+
+// 单次采样的方差乘以窗口内的采样次数，换算成整个窗口的波动
+func (estimator *ewmaEstimator) Value(timestamp int64) (float64, bool) {
+	if estimator.count == 0 {
+		return 0, false
+	}
+	return math.Sqrt(estimator.variance * estimator.span), true
+}
+
+// 窗口内对数收益率的平方和，过期的收益率从和中减掉
+type realizedEstimator struct {
+	window  int64
+	returns window
+	sum     float64
+	last    float64
+}
+
+func (estimator *realizedEstimator) Add(timestamp int64, price float64) {
+	if estimator.last > 0 {
+		r := math.Log(price / estimator.last)
+		estimator.returns.push(sample{timestamp, r * r})
+		estimator.sum += r * r
+	}
+	estimator.last = price
+	estimator.expire(timestamp)
+}
+
+func (estimator *realizedEstimator) expire(timestamp int64) {
+	for estimator.returns.len() > 0 && estimator.returns.front().timestamp <= timestamp-estimator.window {
+		estimator.sum -= estimator.returns.popFront().value
+	}
+	// 加减的浮点误差可能让和变成很小的负数，队列为空时清零
+	if estimator.returns.len() == 0 || estimator.sum < 0 {
+		estimator.sum = 0
+	}
+}
+
+func (estimator *realizedEstimator) Value(timestamp int64) (float64, bool) {
+	estimator.expire(timestamp)
+	if estimator.returns.len() == 0 {
+		return 0, false
+	}
+	return math.Sqrt(estimator.sum), true
+}